@@ -3,16 +3,13 @@ package Util
 import (
 	"PanIndex/config"
 	"PanIndex/entity"
-	"PanIndex/model"
-	"bytes"
+	"PanIndex/metrics"
 	"encoding/json"
+	"fmt"
 	"github.com/eddieivan01/nic"
 	jsoniter "github.com/json-iterator/go"
-	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
-	"mime/multipart"
-	"net/http"
+	"io"
 	"sort"
 	"strings"
 	"time"
@@ -20,44 +17,55 @@ import (
 
 var Alis = map[string]entity.TokenResp{}
 
-func AliRefreshToken(account entity.Account) string {
+//阿里云盘接口请求，记录接口耗时
+func aliPost(url string, option nic.Option) (*nic.Response, error) {
+	session := nic.NewSession()
+	metrics.Hook(session, "aliyundrive")
+	return session.Post(url, option)
+}
+
+//刷新令牌，失败时返回接口的错误信息，原有令牌保留到过期
+func AliRefreshToken(account entity.Account) (refreshToken string, err error) {
 	defer func() {
 		if p := recover(); p != nil {
 			log.Errorln(p)
+			err = fmt.Errorf("%v", p)
 		}
 	}()
-	resp, err := nic.Post("https://auth.aliyundrive.com/v2/account/token", nic.H{
+	resp, err := aliPost("https://auth.aliyundrive.com/v2/account/token", nic.H{
 		JSON: nic.KV{
 			"refresh_token": account.RefreshToken,
 			"grant_type":    "refresh_token",
 		},
 	})
 	if err != nil {
-		panic(err.Error())
-		return ""
+		return "", err
 	}
 	var tokenResp entity.TokenResp
 	err = jsoniter.Unmarshal(resp.Bytes, &tokenResp)
 	if err != nil {
-		panic(err.Error())
-		return ""
+		return "", err
+	}
+	if tokenResp.RespError.Code != "" || tokenResp.RefreshToken == "" {
+		return "", fmt.Errorf("令牌刷新失败：%s %s", tokenResp.RespError.Code, tokenResp.RespError.Message)
 	}
 	Alis[account.Id] = tokenResp
-	return tokenResp.RefreshToken
+	return tokenResp.RefreshToken, nil
 }
 
-func AliGetFiles(accountId, rootId, fileId, p string) {
+//获取某一目录下的文件列表（单层）
+func AliGetFiles(accountId, fileId, p string) (list []entity.FileNode, err error) {
 	tokenResp := Alis[accountId]
 	auth := tokenResp.TokenType + " " + tokenResp.AccessToken
 	defer func() {
-		if p := recover(); p != nil {
-			log.Errorln(p)
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
 		}
 	}()
 	limit := 100
 	nextMarker := ""
 	for {
-		resp, err := nic.Post("https://api.aliyundrive.com/v2/file/list", nic.H{
+		resp, err := aliPost("https://api.aliyundrive.com/v2/file/list", nic.H{
 			Headers: nic.KV{
 				"authorization": auth,
 			},
@@ -68,6 +76,7 @@ func AliGetFiles(accountId, rootId, fileId, p string) {
 				"image_thumbnail_process": "image/resize,w_400/format,jpeg",
 				"image_url_process":       "image/resize,w_1920/format,jpeg",
 				"limit":                   limit,
+				"marker":                  nextMarker,
 				"order_by":                "updated_at",
 				"order_direction":         "DESC",
 				"parent_file_id":          fileId,
@@ -75,11 +84,14 @@ func AliGetFiles(accountId, rootId, fileId, p string) {
 			},
 		})
 		if err != nil {
-			panic(err.Error())
+			return list, err
 		}
 		byteFiles := []byte(resp.Text)
+		if code := jsoniter.Get(byteFiles, "code").ToString(); code != "" {
+			return list, fmt.Errorf("%s: %s", code, jsoniter.Get(byteFiles, "message").ToString())
+		}
 		d := jsoniter.Get(byteFiles, "items")
-		nextMarker = jsoniter.Get(byteFiles, "nextMarker").ToString()
+		nextMarker = jsoniter.Get(byteFiles, "next_marker").ToString()
 		var m []map[string]interface{}
 		json.Unmarshal([]byte(d.ToString()), &m)
 		for _, item := range m {
@@ -119,6 +131,10 @@ func AliGetFiles(accountId, rootId, fileId, p string) {
 					fn.MediaType = 0
 				}
 				fn.DownloadUrl = item["download_url"].(string)
+				//图片、视频的缩略图地址
+				if thumbnail, ok := item["thumbnail"].(string); ok {
+					fn.SmallUrl = thumbnail
+				}
 			} else {
 				fn.FileType = ""
 				fn.IsFolder = true
@@ -145,22 +161,18 @@ func AliGetFiles(accountId, rootId, fileId, p string) {
 			} else {
 				fn.Path = p + "/" + fn.FileName
 			}
-			if fn.IsFolder == true {
-				AliGetFiles(accountId, rootId, fn.FileId, fn.Path)
-			}
-			fn.Id = uuid.NewV4().String()
-			model.SqliteDb.Create(fn)
+			list = append(list, fn)
 		}
 		if nextMarker == "" {
 			break
 		}
 	}
-
+	return list, nil
 }
 func AliGetDownloadUrl(accountId, fileId string) string {
 	tokenResp := Alis[accountId]
 	auth := tokenResp.TokenType + " " + tokenResp.AccessToken
-	resp, err := nic.Post("https://api.aliyundrive.com/v2/file/get_download_url", nic.H{
+	resp, err := aliPost("https://api.aliyundrive.com/v2/file/get_download_url", nic.H{
 		Headers: nic.KV{
 			"authorization": auth,
 		},
@@ -183,67 +195,116 @@ func AliGetDownloadUrl(accountId, fileId string) string {
 	return downUrl
 }
 
-func AliUpload(accountId, parentId string, files []*multipart.FileHeader) bool {
+func AliUpload(accountId, parentId, name string, size int64, r io.Reader) error {
 	tokenResp := Alis[accountId]
 	auth := tokenResp.TokenType + " " + tokenResp.AccessToken
-	for _, file := range files {
-		t1 := time.Now()
-		log.Debugf("开始上传文件：%s，大小：%d", file.Filename, file.Size)
-		resp, _ := nic.Post("https://api.aliyundrive.com/v2/file/create_with_proof", nic.H{
-			Headers: nic.KV{
-				"authorization": auth,
-			},
-			JSON: nic.KV{
-				"drive_id": tokenResp.DefaultDriveId,
-				"part_info_list": []nic.KV{nic.KV{
-					"part_number": 1,
-				},
-				},
-				"pre_hash":        "",
-				"parent_file_id":  parentId,
-				"name":            file.Filename,
-				"type":            "file",
-				"check_name_mode": "auto_rename",
-				"size":            file.Size,
-			},
-		})
-		rapidUpload := jsoniter.Get(resp.Bytes, "rapid_upload").ToBool()
-		if rapidUpload {
-			//秒传成功
-			log.Debugf("上传接口返回：%s", resp.Text)
-			log.Debugf("文件：%s，上传成功，耗时：%s", file.Filename, ShortDur(time.Now().Sub(t1)))
-			return true
-		}
-		fileId := jsoniter.Get(resp.Bytes, "file_id").ToString()
-		uploadId := jsoniter.Get(resp.Bytes, "upload_id").ToString()
-		driveId := jsoniter.Get(resp.Bytes, "drive_id").ToString()
-		partInfoListString := jsoniter.Get(resp.Bytes, "part_info_list").ToString()
-		partInfoList := []entity.AliPartInfo{}
-		jsoniter.UnmarshalFromString(partInfoListString, &partInfoList)
-		log.Debugf("文件分片数：%d", len(partInfoList))
-		for _, partInfo := range partInfoList {
-			fileContent, _ := file.Open()
-			byteContent, _ := ioutil.ReadAll(fileContent)
-			client := &http.Client{}
-			req, err := http.NewRequest(http.MethodPut, partInfo.UploadUrl, bytes.NewBuffer(byteContent))
-			if err != nil {
-				log.Error("上传失败")
-				return false
-			}
-			client.Do(req)
-		}
-		resp, _ = nic.Post("https://api.aliyundrive.com/v2/file/complete", nic.H{
-			Headers: nic.KV{
-				"authorization": auth,
-			},
-			JSON: nic.KV{
-				"drive_id":  driveId,
-				"file_id":   fileId,
-				"upload_id": uploadId,
-			},
-		})
+	t1 := time.Now()
+	log.Debugf("开始上传文件：%s，大小：%d", name, size)
+	ps, count := SplitParts(size)
+	parts := []nic.KV{}
+	for i := 1; i <= count; i++ {
+		parts = append(parts, nic.KV{"part_number": i})
+	}
+	resp, err := aliPost("https://api.aliyundrive.com/v2/file/create_with_proof", nic.H{
+		Headers: nic.KV{
+			"authorization": auth,
+		},
+		JSON: nic.KV{
+			"drive_id":        tokenResp.DefaultDriveId,
+			"part_info_list":  parts,
+			"pre_hash":        "",
+			"parent_file_id":  parentId,
+			"name":            name,
+			"type":            "file",
+			"check_name_mode": "auto_rename",
+			"size":            size,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	rapidUpload := jsoniter.Get(resp.Bytes, "rapid_upload").ToBool()
+	if rapidUpload {
+		//秒传成功
 		log.Debugf("上传接口返回：%s", resp.Text)
-		log.Debugf("文件：%s，上传成功，耗时：%s", file.Filename, ShortDur(time.Now().Sub(t1)))
+		log.Debugf("文件：%s，上传成功，耗时：%s", name, ShortDur(time.Now().Sub(t1)))
+		return nil
+	}
+	fileId := jsoniter.Get(resp.Bytes, "file_id").ToString()
+	uploadId := jsoniter.Get(resp.Bytes, "upload_id").ToString()
+	driveId := jsoniter.Get(resp.Bytes, "drive_id").ToString()
+	partInfoListString := jsoniter.Get(resp.Bytes, "part_info_list").ToString()
+	partInfoList := []entity.AliPartInfo{}
+	jsoniter.UnmarshalFromString(partInfoListString, &partInfoList)
+	log.Debugf("文件分片数：%d", len(partInfoList))
+	uploadUrls := []string{}
+	for _, partInfo := range partInfoList {
+		uploadUrls = append(uploadUrls, partInfo.UploadUrl)
+	}
+	if err = PutParts(uploadUrls, size, ps, r); err != nil {
+		return err
+	}
+	resp, err = aliPost("https://api.aliyundrive.com/v2/file/complete", nic.H{
+		Headers: nic.KV{
+			"authorization": auth,
+		},
+		JSON: nic.KV{
+			"drive_id":  driveId,
+			"file_id":   fileId,
+			"upload_id": uploadId,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Debugf("上传接口返回：%s", resp.Text)
+	log.Debugf("文件：%s，上传成功，耗时：%s", name, ShortDur(time.Now().Sub(t1)))
+	return nil
+}
+
+//创建目录
+func AliMkdir(accountId, parentId, name string) error {
+	tokenResp := Alis[accountId]
+	auth := tokenResp.TokenType + " " + tokenResp.AccessToken
+	resp, err := aliPost("https://api.aliyundrive.com/adrive/v2/file/createWithFolders", nic.H{
+		Headers: nic.KV{
+			"authorization": auth,
+		},
+		JSON: nic.KV{
+			"drive_id":        tokenResp.DefaultDriveId,
+			"parent_file_id":  parentId,
+			"name":            name,
+			"type":            "folder",
+			"check_name_mode": "refuse",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if code := jsoniter.Get(resp.Bytes, "code").ToString(); code != "" {
+		return fmt.Errorf("%s: %s", code, jsoniter.Get(resp.Bytes, "message").ToString())
+	}
+	return nil
+}
+
+//删除文件（移入回收站）
+func AliDelete(accountId, fileId string) error {
+	tokenResp := Alis[accountId]
+	auth := tokenResp.TokenType + " " + tokenResp.AccessToken
+	resp, err := aliPost("https://api.aliyundrive.com/v2/recyclebin/trash", nic.H{
+		Headers: nic.KV{
+			"authorization": auth,
+		},
+		JSON: nic.KV{
+			"drive_id": tokenResp.DefaultDriveId,
+			"file_id":  fileId,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if code := jsoniter.Get(resp.Bytes, "code").ToString(); code != "" {
+		return fmt.Errorf("%s: %s", code, jsoniter.Get(resp.Bytes, "message").ToString())
 	}
-	return true
+	return nil
 }
@@ -1,8 +1,11 @@
 package Util
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -32,3 +35,44 @@ func GetRandomString(n int) string {
 	rand.Read(randBytes)
 	return fmt.Sprintf("%x", randBytes)
 }
+
+//分片上传时每个分片的大小，分片数超过上限时增大分片
+const partSize = 10 * 1024 * 1024
+const maxParts = 10000
+
+//按分片大小切分文件，返回分片大小及分片数，空文件为1个分片
+func SplitParts(size int64) (int64, int) {
+	ps := int64(partSize)
+	if size > ps*maxParts {
+		ps = (size + maxParts - 1) / maxParts
+	}
+	count := int((size + ps - 1) / ps)
+	if count == 0 {
+		count = 1
+	}
+	return ps, count
+}
+
+//依次上传分片，每个分片从r中读取对应长度的内容
+func PutParts(uploadUrls []string, size, ps int64, r io.Reader) error {
+	for i, uploadUrl := range uploadUrls {
+		partLen := size - int64(i)*ps
+		if partLen > ps {
+			partLen = ps
+		}
+		req, err := http.NewRequest(http.MethodPut, uploadUrl, io.LimitReader(r, partLen))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = partLen
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode >= http.StatusBadRequest {
+			return errors.New(fmt.Sprintf("分片%d上传失败：%s", i+1, res.Status))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package Util
+
+import "testing"
+
+func TestSplitParts(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int64
+		wantSize  int64
+		wantCount int
+	}{
+		{"空文件", 0, partSize, 1},
+		{"1字节", 1, partSize, 1},
+		{"刚好1个分片", partSize, partSize, 1},
+		{"多1字节", partSize + 1, partSize, 2},
+		{"多个分片", 3*partSize + 5, partSize, 4},
+		{"刚好达到分片数上限", partSize * maxParts, partSize, maxParts},
+		{"超过上限时增大分片", partSize*maxParts + 1, partSize + 1, maxParts},
+		{"超过上限且不能整除", 2*partSize*maxParts + 3, 2*partSize + 1, maxParts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, count := SplitParts(tt.size)
+			if ps != tt.wantSize || count != tt.wantCount {
+				t.Errorf("SplitParts(%d) = %d, %d, want %d, %d", tt.size, ps, count, tt.wantSize, tt.wantCount)
+			}
+			if ps*int64(count) < tt.size || count > maxParts {
+				t.Errorf("SplitParts(%d) = %d, %d，分片不能覆盖整个文件", tt.size, ps, count)
+			}
+		})
+	}
+}
@@ -3,21 +3,21 @@ package Util
 import (
 	"PanIndex/config"
 	"PanIndex/entity"
-	"PanIndex/model"
-	"bytes"
+	"PanIndex/metrics"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/eddieivan01/nic"
 	jsoniter "github.com/json-iterator/go"
-	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
+	"math"
 	math_rand "math/rand"
 	"mime/multipart"
 	"net/http"
@@ -32,90 +32,46 @@ import (
 //var CLoud189Session nic.Session
 var CLoud189Sessions = map[string]nic.Session{}
 
-//获取文件列表
-func Cloud189GetFiles(accountId, rootId, fileId, prefix string) {
+//获取某一目录下的文件列表（单层）
+func Cloud189GetFiles(accountId, fileId, p string) ([]entity.FileNode, error) {
 	CLoud189Session := CLoud189Sessions[accountId]
-	defer func() {
-		if p := recover(); p != nil {
-			log.Errorln(p)
-		}
-	}()
+	list := []entity.FileNode{}
 	pageNum := 1
 	for {
 		url := fmt.Sprintf("https://cloud.189.cn/v2/listFiles.action?fileId=%s&mediaType=&keyword=&inGroupSpace=false&orderBy=3&order=DESC&pageNum=%d&pageSize=100&noCache=%s", fileId, pageNum, random())
 		resp, err := CLoud189Session.Get(url, nil)
 		if err != nil {
-			panic(err.Error())
+			return list, err
 		}
 		byteFiles := []byte(resp.Text)
 		totalCount := jsoniter.Get(byteFiles, "recordCount").ToInt()
 		d := jsoniter.Get(byteFiles, "data")
-		paths := jsoniter.Get(byteFiles, "path")
-		ps := []entity.Paths{}
-		err = jsoniter.Unmarshal([]byte(paths.ToString()), &ps)
-		p := ""
-		flag := false
-		if err == nil {
-			for _, item := range ps {
-				if flag == true && item.FileId != rootId {
-					if strings.HasSuffix(p, "/") != true {
-						p += "/" + item.FileName
-					} else {
-						p += item.FileName
-					}
-				}
-				if item.FileId == rootId {
-					flag = true
-				}
-				if flag == true && item.FileId == rootId {
-					p += "/"
-				}
-			}
+		if d.ValueType() != jsoniter.ArrayValue {
+			return list, fmt.Errorf("天翼云网盘列表获取失败：%s", resp.Text)
 		}
-		if p == "/" && prefix != "" {
-			p = prefix
-		} else if p != "/" && prefix != "" {
-			p = prefix + p
+		m := []entity.FileNode{}
+		err = jsoniter.Unmarshal([]byte(d.ToString()), &m)
+		if err != nil {
+			return list, err
 		}
-		if d != nil {
-			m := []entity.FileNode{}
-			err = jsoniter.Unmarshal([]byte(d.ToString()), &m)
-			if err == nil {
-				for _, item := range m {
-					item.AccountId = accountId
-					if p == "/" {
-						item.Path = "/" + item.FileName
-					} else {
-						item.Path = p + "/" + item.FileName
-					}
-					item.Id = uuid.NewV4().String()
-					item.Delete = 1
-					item.ParentPath = p
-					item.SizeFmt = FormatFileSize(item.FileSize)
-					if item.IsFolder == true {
-						Cloud189GetFiles(accountId, rootId, item.FileId, prefix)
-					} else {
-						//如果是文件，解析下载直链
-						/*dRedirectRep, _ := CLoud189Session.Get("https://cloud.189.cn/downloadFile.action?fileStr="+item.FileIdDigest+"&downloadType=1", nic.H{
-							AllowRedirect: false,
-						})
-						redirectUrl := dRedirectRep.Header.Get("Location")
-						dRedirectRep, _ = CLoud189Session.Get(redirectUrl, nic.H{
-							AllowRedirect: false,
-						})
-						item.DownloadUrl = dRedirectRep.Header.Get("Location")*/
-					}
-					if config.GloablConfig.HideFileId != "" {
-						listSTring := strings.Split(config.GloablConfig.HideFileId, ",")
-						sort.Strings(listSTring)
-						i := sort.SearchStrings(listSTring, item.FileId)
-						if i < len(listSTring) && listSTring[i] == item.FileId {
-							item.Hide = 1
-						}
-					}
-					model.SqliteDb.Create(item)
+		for _, item := range m {
+			item.AccountId = accountId
+			if p == "/" {
+				item.Path = "/" + item.FileName
+			} else {
+				item.Path = p + "/" + item.FileName
+			}
+			item.ParentPath = p
+			item.SizeFmt = FormatFileSize(item.FileSize)
+			if config.GloablConfig.HideFileId != "" {
+				listSTring := strings.Split(config.GloablConfig.HideFileId, ",")
+				sort.Strings(listSTring)
+				i := sort.SearchStrings(listSTring, item.FileId)
+				if i < len(listSTring) && listSTring[i] == item.FileId {
+					item.Hide = 1
 				}
 			}
+			list = append(list, item)
 		}
 		if pageNum*100 < totalCount {
 			pageNum++
@@ -123,6 +79,7 @@ func Cloud189GetFiles(accountId, rootId, fileId, prefix string) {
 			break
 		}
 	}
+	return list, nil
 }
 func GetDownlaodUrl(accountId, fileIdDigest string) string {
 	CLoud189Session := CLoud189Sessions[accountId]
@@ -158,18 +115,11 @@ func GetDownlaodUrlNew(accountId, fileIdDigest string) string {
 	redirectUrl := dRedirectRep.Header.Get("Location")
 	return redirectUrl
 }
-func GetDownlaodMultiFiles(accountId, fileId string) string {
-	CLoud189Session := CLoud189Sessions[accountId]
-	dRedirectRep, _ := CLoud189Session.Get(fmt.Sprintf("https://cloud.189.cn/downloadMultiFiles.action?fileIdS=%s&downloadType=1&recursive=1", fileId), nic.H{
-		AllowRedirect: false,
-	})
-	redirectUrl := dRedirectRep.Header.Get("Location")
-	return redirectUrl
-}
 
-//天翼云网盘登录
-func Cloud189Login(accountId, user, password string) string {
+//天翼云网盘登录，失败时返回错误代码（restCode）及原因
+func Cloud189Login(accountId, user, password string) (string, error) {
 	CLoud189Session := CLoud189Sessions[accountId]
+	metrics.Hook(&CLoud189Session, "cloud189")
 	url := "https://cloud.189.cn/udb/udb_login.jsp?pageId=1&redirectURL=/main.action"
 	res, _ := CLoud189Session.Get(url, nil)
 	b := res.Text
@@ -179,7 +129,7 @@ func Cloud189Login(accountId, user, password string) string {
 	if len(ltTextArr) > 0 {
 		lt = ltTextArr[1]
 	} else {
-		return ""
+		return "", errors.New("登录页面获取失败")
 	}
 	captchaToken := regexp.MustCompile(`captchaToken' value='(.+?)'`).FindStringSubmatch(b)[1]
 	returnUrl := regexp.MustCompile(`returnUrl = '(.+?)'`).FindStringSubmatch(b)[1]
@@ -223,7 +173,7 @@ func Cloud189Login(accountId, user, password string) string {
 		toUrl := jsoniter.Get([]byte(loginResp.Text), "toUrl").ToString()
 		res, _ := CLoud189Session.Get(toUrl, nil)
 		CLoud189Sessions[accountId] = CLoud189Session
-		return res.Cookies()[0].Value
+		return res.Cookies()[0].Value, nil
 	}
 	errorReason := jsoniter.Get([]byte(loginResp.Text), "msg").ToString()
 	if errorReason == "" {
@@ -237,7 +187,7 @@ func Cloud189Login(accountId, user, password string) string {
 		}
 	}
 	log.Warningln("[登录接口]登录失败，错误代码：" + strconv.Itoa(restCode) + " (" + errorReason + ")")
-	return ""
+	return "", fmt.Errorf("登录失败，错误代码：%d (%s)", restCode, errorReason)
 }
 
 //分享链接跳转下载
@@ -380,35 +330,79 @@ func GetValidateCode(accountId, params string) string {
 	return ""
 }
 
-func Cloud189UploadFiles(accountId, parentId string, files []*multipart.FileHeader) bool {
+func Cloud189UploadFile(accountId, parentId, name string, size int64, r io.Reader) error {
 	CLoud189Session := CLoud189Sessions[accountId]
-	response, _ := CLoud189Session.Get("https://cloud.189.cn/main.action#home", nil)
+	response, err := CLoud189Session.Get("https://cloud.189.cn/main.action#home", nil)
+	if err != nil {
+		return err
+	}
 	sessionKey := GetCurBetweenStr(response.Text, "window.edrive.sessionKey = '", "';")
 	log.Debug(sessionKey)
-	for _, file := range files {
-		t1 := time.Now()
-		log.Debugf("开始上传文件：%s，大小：%d", file.Filename, file.Size)
-		fileContent, _ := file.Open()
-		byteContent, _ := ioutil.ReadAll(fileContent)
-		reader := bytes.NewReader(byteContent)
-		b := &bytes.Buffer{}
-		writer := multipart.NewWriter(b)
+	if sessionKey == "" {
+		return errors.New("天翼云网盘上传失败：未获取到sessionKey，请检查登录状态")
+	}
+	t1 := time.Now()
+	log.Debugf("开始上传文件：%s，大小：%d", name, size)
+	//边读边写，避免大文件整个读入内存
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
 		writer.WriteField("parentId", parentId)
 		writer.WriteField("sessionKey", sessionKey)
 		writer.WriteField("opertype", "1")
-		writer.WriteField("fname", file.Filename)
-		part, _ := writer.CreateFormFile("Filedata", file.Filename)
-		io.Copy(part, reader)
-		writer.Close()
-		r, _ := http.NewRequest("POST", "https://hb02.upload.cloud.189.cn/v1/DCIWebUploadAction", b)
-		r.Header.Add("Content-Type", writer.FormDataContentType())
-		res, _ := http.DefaultClient.Do(r)
-		defer res.Body.Close()
-		body, _ := ioutil.ReadAll(res.Body)
-		log.Debugf("上传接口返回：%s", string(body))
-		log.Debugf("文件：%s，上传成功，耗时：%s", file.Filename, ShortDur(time.Now().Sub(t1)))
+		writer.WriteField("fname", name)
+		part, err := writer.CreateFormFile("Filedata", name)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	req, err := http.NewRequest("POST", "https://hb02.upload.cloud.189.cn/v1/DCIWebUploadAction", pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	log.Debugf("上传接口返回：%s", string(body))
+	if err = cloud189UploadResult(res.StatusCode, body); err != nil {
+		return err
+	}
+	log.Debugf("文件：%s，上传成功，耗时：%s", name, ShortDur(time.Now().Sub(t1)))
+	return nil
+}
+
+//校验上传接口的返回，没有返回新文件的id时视为上传失败
+func cloud189UploadResult(status int, body []byte) error {
+	if status != http.StatusOK || jsoniter.Get(body, "id").ToString() == "" {
+		return fmt.Errorf("天翼云网盘上传失败：%d %s", status, string(body))
+	}
+	return nil
+}
+
+//创建目录
+func Cloud189Mkdir(accountId, parentId, name string) error {
+	CLoud189Session := CLoud189Sessions[accountId]
+	resp, err := CLoud189Session.Post("https://cloud.189.cn/v2/createFolder.action", nic.H{
+		Data: nic.KV{
+			"parentId": parentId,
+			"fileName": name,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if jsoniter.Get(resp.Bytes, "fileId").ToString() == "" {
+		return fmt.Errorf("天翼云网盘目录创建失败：%s", resp.Text)
 	}
-	return true
+	return nil
 }
 
 var b64map = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
@@ -477,6 +471,25 @@ func FormatFileSize(fileSize int64) (size string) {
 	}
 }
 
+//解析文件大小，支持K、M、G、T单位（1024进制），如：100、10K、1.5G
+func ParseFileSize(s string) int64 {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	unit := float64(1)
+	for i, u := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(s, u) {
+			unit = math.Pow(1024, float64(i+1))
+			s = strings.TrimSuffix(s, u)
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0
+	}
+	return int64(size * unit)
+}
+
 func GetBetweenStr(str, start, end string) string {
 	n := strings.Index(str, start)
 	if n == -1 {
@@ -0,0 +1,25 @@
+package Util
+
+import "testing"
+
+func TestCloud189UploadResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{"成功", 200, `{"id":"71234567890","name":"a.txt","size":5}`, false},
+		{"会话过期", 200, `{"errorCode":"InvalidSessionKey","errorMsg":"会话已过期"}`, true},
+		{"返回的不是JSON", 200, `<html>error</html>`, true},
+		{"状态码错误", 500, `{"id":"71234567890"}`, true},
+		{"空返回", 200, ``, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cloud189UploadResult(tt.status, []byte(tt.body)); (err != nil) != tt.wantErr {
+				t.Errorf("cloud189UploadResult() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package Util
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//每个账号保留的空闲连接数
+const poolMaxIdle = 4
+
+//空闲超过该时间的连接不再使用，避免被服务器断开的连接
+const poolIdleTimeout = time.Minute
+
+//账号id -> *connPool，用于sftp、ftp等需要保持连接的模式
+var connPools sync.Map
+
+type idleConn struct {
+	conn io.Closer
+	time time.Time
+}
+
+type connPool struct {
+	mu   sync.Mutex
+	key  string //连接配置（地址、账号、密码等），变化后重建连接
+	idle []idleConn
+}
+
+//从账号的连接池中取出连接，没有空闲连接时新建
+func getConn(accountId, key string, dial func() (io.Closer, error)) (io.Closer, error) {
+	v, _ := connPools.LoadOrStore(accountId, &connPool{key: key})
+	pool := v.(*connPool)
+	pool.mu.Lock()
+	if pool.key != key {
+		pool.closeIdle()
+		pool.key = key
+	}
+	for len(pool.idle) > 0 {
+		ic := pool.idle[len(pool.idle)-1]
+		pool.idle = pool.idle[:len(pool.idle)-1]
+		if time.Since(ic.time) < poolIdleTimeout {
+			pool.mu.Unlock()
+			return ic.conn, nil
+		}
+		ic.conn.Close()
+	}
+	pool.mu.Unlock()
+	return dial()
+}
+
+//连接放回连接池，broken为true（网络错误等）时直接关闭
+func putConn(accountId, key string, conn io.Closer, broken bool) {
+	v, ok := connPools.Load(accountId)
+	if !ok || broken {
+		conn.Close()
+		return
+	}
+	pool := v.(*connPool)
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.key != key || len(pool.idle) >= poolMaxIdle {
+		conn.Close()
+		return
+	}
+	pool.idle = append(pool.idle, idleConn{conn: conn, time: time.Now()})
+}
+
+func (pool *connPool) closeIdle() {
+	for _, ic := range pool.idle {
+		ic.conn.Close()
+	}
+	pool.idle = nil
+}
+
+//关闭账号的所有空闲连接，账号删除或模式变更时调用
+func ClosePool(accountId string) {
+	if v, ok := connPools.LoadAndDelete(accountId); ok {
+		pool := v.(*connPool)
+		pool.mu.Lock()
+		pool.closeIdle()
+		pool.mu.Unlock()
+	}
+}
+
+//远程文件信息，sftp、ftp的目录列表转换为os.FileInfo，和本地模式使用同样的方式生成文件节点
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi remoteFileInfo) Name() string       { return fi.name }
+func (fi remoteFileInfo) Size() int64        { return fi.size }
+func (fi remoteFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi remoteFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi remoteFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi remoteFileInfo) Sys() interface{}   { return nil }
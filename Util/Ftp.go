@@ -0,0 +1,535 @@
+package Util
+
+import (
+	"PanIndex/entity"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ftpTimeout = 10 * time.Second
+
+//一个ftp控制连接，数据连接均使用被动模式
+type ftpConn struct {
+	conn      net.Conn
+	text      *textproto.Conn
+	host      string
+	tlsConfig *tls.Config //为nil表示不加密
+	mlsd      bool        //服务器支持MLSD，目录列表格式固定
+}
+
+func (c *ftpConn) Close() error {
+	c.text.Cmd("QUIT")
+	return c.text.Close()
+}
+
+//服务器返回的错误，连接仍然可用
+func isFtpReply(err error) bool {
+	_, ok := err.(*textproto.Error)
+	return ok
+}
+
+//连接池的key，地址、账号及认证信息变化后重新连接
+func ftpKey(account entity.Account) string {
+	return strings.Join([]string{account.Api, account.User, account.Password, account.HostKey}, "\n")
+}
+
+//地址格式：ftp://host:port（不加密），ftpes://host:port（显式TLS），ftps://host:port（隐式TLS，默认端口990），也可以只写host:port
+func ftpAddr(account entity.Account) (string, string, error) {
+	addr := account.Api
+	if !strings.Contains(addr, "://") {
+		addr = "ftp://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "ftp" && u.Scheme != "ftpes" && u.Scheme != "ftps" {
+		return "", "", errors.New("不支持的地址：" + account.Api)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "21"
+		if u.Scheme == "ftps" {
+			port = "990"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u.Scheme, host, nil
+}
+
+//设置了证书指纹时只校验指纹（可以使用自签名证书），否则校验证书链
+func ftpTLSConfig(account entity.Account, host string) *tls.Config {
+	config := &tls.Config{
+		ServerName: host,
+		//数据连接需要复用控制连接的TLS会话
+		ClientSessionCache: tls.NewLRUClientSessionCache(4),
+	}
+	if account.HostKey != "" {
+		fingerprint := strings.ToLower(strings.ReplaceAll(account.HostKey, ":", ""))
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+			if len(rawCerts) > 0 {
+				sum := sha256.Sum256(rawCerts[0])
+				if hex.EncodeToString(sum[:]) == fingerprint {
+					return nil
+				}
+			}
+			return errors.New("证书指纹不一致")
+		}
+	}
+	return config
+}
+
+func ftpDial(account entity.Account) (io.Closer, error) {
+	scheme, addr, err := ftpAddr(account)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", addr, ftpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	c := &ftpConn{conn: conn, host: host}
+	if scheme != "ftp" {
+		c.tlsConfig = ftpTLSConfig(account, host)
+	}
+	if scheme == "ftps" {
+		c.conn = tls.Client(conn, c.tlsConfig)
+	}
+	c.text = textproto.NewConn(c.conn)
+	if err = c.login(scheme, account); err != nil {
+		c.text.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ftpConn) login(scheme string, account entity.Account) error {
+	c.conn.SetDeadline(time.Now().Add(ftpTimeout))
+	defer func() {
+		c.conn.SetDeadline(time.Time{})
+	}()
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		return err
+	}
+	if scheme == "ftpes" {
+		if _, _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			return err
+		}
+		c.conn = tls.Client(c.conn, c.tlsConfig)
+		c.text = textproto.NewConn(c.conn)
+	}
+	code, _, err := c.cmd(0, "USER %s", account.User)
+	if err != nil {
+		return err
+	}
+	if code == 331 {
+		_, _, err = c.cmd(230, "PASS %s", account.Password)
+	} else if code != 230 {
+		err = fmt.Errorf("ftp登录失败：%d", code)
+	}
+	if err != nil {
+		return err
+	}
+	if c.tlsConfig != nil {
+		//数据连接也需要加密
+		if _, _, err = c.cmd(200, "PBSZ 0"); err != nil {
+			return err
+		}
+		if _, _, err = c.cmd(200, "PROT P"); err != nil {
+			return err
+		}
+	}
+	if _, _, err = c.cmd(200, "TYPE I"); err != nil {
+		return err
+	}
+	if _, msg, err := c.cmd(211, "FEAT"); err == nil {
+		c.mlsd = strings.Contains(strings.ToUpper(msg), "MLST")
+		if strings.Contains(strings.ToUpper(msg), "UTF8") {
+			c.cmd(0, "OPTS UTF8 ON")
+		}
+	} else if !isFtpReply(err) {
+		return err
+	}
+	return nil
+}
+
+//发送命令并读取响应，expect为0时不校验响应码
+func (c *ftpConn) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.text.ReadResponse(expect)
+}
+
+//打开被动模式的数据连接，优先使用EPSV，地址始终使用控制连接的地址
+func (c *ftpConn) dataConn() (net.Conn, error) {
+	port := 0
+	_, msg, err := c.cmd(229, "EPSV")
+	if err == nil {
+		//Entering Extended Passive Mode (|||port|)
+		if start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)"); start >= 0 && end > start {
+			port, _ = strconv.Atoi(msg[start+4 : end])
+		}
+	} else if isFtpReply(err) {
+		if _, msg, err = c.cmd(227, "PASV"); err != nil {
+			return nil, err
+		}
+		//Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+		start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		if start >= 0 && end > start {
+			if fields := strings.Split(msg[start+1:end], ","); len(fields) == 6 {
+				p1, _ := strconv.Atoi(strings.TrimSpace(fields[4]))
+				p2, _ := strconv.Atoi(strings.TrimSpace(fields[5]))
+				port = p1*256 + p2
+			}
+		}
+	} else {
+		return nil, err
+	}
+	if port <= 0 {
+		return nil, errors.New("被动模式端口解析失败：" + msg)
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.host, strconv.Itoa(port)), ftpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.tlsConfig != nil {
+		conn = tls.Client(conn, c.tlsConfig)
+	}
+	return conn, nil
+}
+
+//执行需要数据连接的命令（LIST、RETR、STOR等），返回数据连接
+func (c *ftpConn) transfer(format string, args ...interface{}) (net.Conn, error) {
+	conn, err := c.dataConn()
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err = c.cmd(1, format, args...); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		//上传空文件时没有数据写入，需要主动握手，否则服务器会认为传输失败
+		if err = tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+//上传完成后先半关闭数据连接，等待服务器关闭，直接关闭时未读取的数据（如TLS会话票据）会导致连接被重置，服务器可能丢失最后的数据
+func closeWrite(conn net.Conn) {
+	switch c := conn.(type) {
+	case *tls.Conn:
+		c.CloseWrite()
+	case *net.TCPConn:
+		c.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(ftpTimeout))
+	io.Copy(ioutil.Discard, conn)
+}
+
+//关闭数据连接并读取传输结果
+func (c *ftpConn) finish(conn net.Conn) error {
+	conn.Close()
+	_, _, err := c.text.ReadResponse(2)
+	return err
+}
+
+func (c *ftpConn) readDir(dir string) ([]os.FileInfo, error) {
+	if _, _, err := c.cmd(250, "CWD %s", dir); err != nil {
+		return nil, err
+	}
+	command := "LIST -a"
+	if c.mlsd {
+		command = "MLSD"
+	}
+	conn, err := c.transfer(command)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(conn)
+	if e := c.finish(conn); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []os.FileInfo{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		var fi remoteFileInfo
+		var ok bool
+		if c.mlsd {
+			fi, ok = parseMlsd(line)
+		} else {
+			fi, ok = parseList(line)
+		}
+		if ok && fi.name != "." && fi.name != ".." {
+			list = append(list, fi)
+		}
+	}
+	return list, nil
+}
+
+//MLSD格式：type=file;size=123;modify=20210102030405; name
+func parseMlsd(line string) (remoteFileInfo, bool) {
+	fi := remoteFileInfo{}
+	i := strings.Index(line, " ")
+	if i < 0 {
+		return fi, false
+	}
+	fi.name = line[i+1:]
+	for _, fact := range strings.Split(line[:i], ";") {
+		kv := strings.SplitN(fact, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "type":
+			switch strings.ToLower(kv[1]) {
+			case "dir":
+				fi.mode = os.ModeDir
+			case "cdir", "pdir":
+				return fi, false
+			}
+		case "size":
+			fi.size, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "modify":
+			//UTC时间，可能带毫秒
+			if len(kv[1]) >= 14 {
+				fi.modTime, _ = time.Parse("20060102150405", kv[1][:14])
+			}
+		}
+	}
+	return fi, true
+}
+
+//LIST的unix格式：drwxr-xr-x 2 user group 4096 Jan  2 03:04 name
+//IIS等使用MS-DOS格式：01-02-21  03:04PM       <DIR>          name
+func parseList(line string) (remoteFileInfo, bool) {
+	fi := remoteFileInfo{}
+	fields := strings.Fields(line)
+	if len(fields) >= 4 && fields[0][0] >= '0' && fields[0][0] <= '9' {
+		return parseDosList(line, fields)
+	}
+	if len(fields) < 9 || len(fields[0]) != 10 {
+		return fi, false
+	}
+	//文件名可能包含空格，从第8个字段之后截取
+	rest := line
+	for i := 0; i < 8; i++ {
+		rest = strings.TrimLeft(rest, " ")
+		rest = rest[strings.Index(rest, " "):]
+	}
+	fi.name = strings.TrimLeft(rest, " ")
+	switch fields[0][0] {
+	case 'd':
+		fi.mode = os.ModeDir
+	case 'l':
+		//符号链接按目标是否为目录无法判断，名称去掉指向部分
+		if i := strings.Index(fi.name, " -> "); i >= 0 {
+			fi.name = fi.name[:i]
+		}
+	}
+	fi.size, _ = strconv.ParseInt(fields[4], 10, 64)
+	stamp := fields[5] + " " + fields[6] + " " + fields[7]
+	if strings.Contains(fields[7], ":") {
+		//当年的文件只显示时间，超过当前时间时为去年
+		now := time.Now()
+		fi.modTime, _ = time.ParseInLocation("Jan 2 15:04 2006", stamp+" "+strconv.Itoa(now.Year()), time.Local)
+		if fi.modTime.After(now.AddDate(0, 0, 1)) {
+			fi.modTime = fi.modTime.AddDate(-1, 0, 0)
+		}
+	} else {
+		fi.modTime, _ = time.ParseInLocation("Jan 2 2006", stamp, time.Local)
+	}
+	return fi, true
+}
+
+//MS-DOS格式的日期为月-日-年，时间一般为12小时制，目录的大小为<DIR>
+func parseDosList(line string, fields []string) (remoteFileInfo, bool) {
+	fi := remoteFileInfo{}
+	var err error
+	for _, layout := range []string{"01-02-06 03:04PM", "01-02-2006 03:04PM", "01-02-06 15:04", "01-02-2006 15:04"} {
+		if fi.modTime, err = time.ParseInLocation(layout, fields[0]+" "+fields[1], time.Local); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fi, false
+	}
+	if fields[2] == "<DIR>" {
+		fi.mode = os.ModeDir
+	} else if fi.size, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return fi, false
+	}
+	//文件名可能包含空格，从第3个字段之后截取
+	rest := line
+	for i := 0; i < 3; i++ {
+		rest = strings.TrimLeft(rest, " ")
+		rest = rest[strings.Index(rest, " "):]
+	}
+	fi.name = strings.TrimLeft(rest, " ")
+	return fi, true
+}
+
+//递归删除目录
+func (c *ftpConn) removeAll(p string, isDir bool) error {
+	if !isDir {
+		_, _, err := c.cmd(250, "DELE %s", p)
+		return err
+	}
+	list, err := c.readDir(p)
+	if err != nil {
+		return err
+	}
+	for _, fi := range list {
+		if err = c.removeAll(path.Join(p, fi.Name()), fi.IsDir()); err != nil {
+			return err
+		}
+	}
+	_, _, err = c.cmd(250, "RMD %s", p)
+	return err
+}
+
+//从连接池取出连接执行操作，服务器返回的错误不影响连接复用
+func withFtp(account entity.Account, fn func(c *ftpConn) error) error {
+	key := ftpKey(account)
+	conn, err := getConn(account.Id, key, func() (io.Closer, error) {
+		return ftpDial(account)
+	})
+	if err != nil {
+		return err
+	}
+	err = fn(conn.(*ftpConn))
+	putConn(account.Id, key, conn, err != nil && !isFtpReply(err))
+	return err
+}
+
+//校验连接及根目录
+func FtpCheck(account entity.Account) error {
+	return withFtp(account, func(c *ftpConn) error {
+		_, _, err := c.cmd(250, "CWD %s", account.RootId)
+		return err
+	})
+}
+
+//读取目录
+func FtpReadDir(account entity.Account, dir string) ([]os.FileInfo, error) {
+	var list []os.FileInfo
+	err := withFtp(account, func(c *ftpConn) (err error) {
+		list, err = c.readDir(dir)
+		return
+	})
+	return list, err
+}
+
+//从offset处开始读取文件，关闭后连接放回连接池
+func FtpOpen(account entity.Account, p string, offset int64) (io.ReadCloser, error) {
+	key := ftpKey(account)
+	conn, err := getConn(account.Id, key, func() (io.Closer, error) {
+		return ftpDial(account)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := conn.(*ftpConn)
+	if offset > 0 {
+		if _, _, err = c.cmd(350, "REST %d", offset); err != nil {
+			putConn(account.Id, key, conn, !isFtpReply(err))
+			return nil, err
+		}
+	}
+	data, err := c.transfer("RETR %s", p)
+	if err != nil {
+		putConn(account.Id, key, conn, !isFtpReply(err))
+		return nil, err
+	}
+	return &ftpReader{account: account, key: key, c: c, data: data}, nil
+}
+
+type ftpReader struct {
+	account entity.Account
+	key     string
+	c       *ftpConn
+	data    net.Conn
+	eof     bool
+}
+
+func (r *ftpReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+//未读取完时服务器会返回中断的错误，这种连接不再复用
+func (r *ftpReader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	if r.eof {
+		err := r.c.finish(r.data)
+		putConn(r.account.Id, r.key, r.c, err != nil)
+	} else {
+		r.data.Close()
+		r.c.text.Close()
+	}
+	r.c = nil
+	return nil
+}
+
+//上传文件到dir目录，同名文件会被覆盖
+func FtpUpload(account entity.Account, dir, name string, r io.Reader) error {
+	return withFtp(account, func(c *ftpConn) error {
+		conn, err := c.transfer("STOR %s", path.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(conn, r); err == nil {
+			closeWrite(conn)
+		}
+		if e := c.finish(conn); err == nil {
+			err = e
+		}
+		return err
+	})
+}
+
+//创建目录
+func FtpMkdir(account entity.Account, dir, name string) error {
+	return withFtp(account, func(c *ftpConn) error {
+		_, _, err := c.cmd(257, "MKD %s", path.Join(dir, name))
+		return err
+	})
+}
+
+//删除文件，目录会递归删除
+func FtpDelete(account entity.Account, p string, isDir bool) error {
+	return withFtp(account, func(c *ftpConn) error {
+		return c.removeAll(p, isDir)
+	})
+}
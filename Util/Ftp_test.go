@@ -0,0 +1,70 @@
+package Util
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseList(t *testing.T) {
+	local := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name string
+		line string
+		ok   bool
+		want remoteFileInfo
+	}{
+		{"unix目录", "drwxr-xr-x    2 user     group        4096 Mar 15  2020 docs", true,
+			remoteFileInfo{name: "docs", size: 4096, mode: os.ModeDir, modTime: local(2020, 3, 15, 0, 0)}},
+		{"unix文件名带空格", "-rw-r--r--   1 ftp ftp  123456 Dec 31  2019 my  file.txt", true,
+			remoteFileInfo{name: "my  file.txt", size: 123456, modTime: local(2019, 12, 31, 0, 0)}},
+		{"unix当年文件只有时间", "-rw-r--r--   1 ftp ftp  10 Jan  1 00:00 a.txt", true,
+			remoteFileInfo{name: "a.txt", size: 10, modTime: local(time.Now().Year(), 1, 1, 0, 0)}},
+		{"unix符号链接", "lrwxrwxrwx   1 ftp ftp  7 Mar 15  2020 link -> target", true,
+			remoteFileInfo{name: "link", size: 7, modTime: local(2020, 3, 15, 0, 0)}},
+		{"MS-DOS目录", "01-02-21  03:04PM       <DIR>          Program Files", true,
+			remoteFileInfo{name: "Program Files", mode: os.ModeDir, modTime: local(2021, 1, 2, 15, 4)}},
+		{"MS-DOS文件", "12-31-2020  11:59AM              1234 a b.txt", true,
+			remoteFileInfo{name: "a b.txt", size: 1234, modTime: local(2020, 12, 31, 11, 59)}},
+		{"MS-DOS 24小时制", "06-15-21  23:05                  42 c.txt", true,
+			remoteFileInfo{name: "c.txt", size: 42, modTime: local(2021, 6, 15, 23, 5)}},
+		{"MS-DOS大小错误", "06-15-21  23:05       abc c.txt", false, remoteFileInfo{}},
+		{"汇总行", "total 12", false, remoteFileInfo{}},
+		{"字段不足", "drwxr-xr-x 2 user group 4096 Mar 15", false, remoteFileInfo{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseList(tt.line)
+			if ok != tt.ok || (ok && (got.name != tt.want.name || got.size != tt.want.size || got.mode != tt.want.mode || !got.modTime.Equal(tt.want.modTime))) {
+				t.Errorf("parseList(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseMlsd(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		ok   bool
+		want remoteFileInfo
+	}{
+		{"文件", "type=file;size=123;modify=20210102030405; a b.txt", true,
+			remoteFileInfo{name: "a b.txt", size: 123, modTime: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		{"目录，事实名不区分大小写", "Type=dir;Modify=20210102030405.123;UNIX.mode=0755; docs", true,
+			remoteFileInfo{name: "docs", mode: os.ModeDir, modTime: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		{"当前目录", "type=cdir;modify=20210102030405; .", false, remoteFileInfo{}},
+		{"上级目录", "type=pdir;modify=20210102030405; ..", false, remoteFileInfo{}},
+		{"没有文件名", "type=file;size=1;", false, remoteFileInfo{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseMlsd(tt.line)
+			if ok != tt.ok || (ok && (got.name != tt.want.name || got.size != tt.want.size || got.mode != tt.want.mode || !got.modTime.Equal(tt.want.modTime))) {
+				t.Errorf("parseMlsd(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package Util
+
+import (
+	"PanIndex/entity"
+	"PanIndex/metrics"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eddieivan01/nic"
+	jsoniter "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//账号id -> entity.GoogleDrive
+var GoogleDrives sync.Map
+
+//上传分片大小，需要是256KB的整数倍
+const googleDriveChunkSize = 256 * 1024 * 40
+
+const googleDriveFolder = "application/vnd.google-apps.folder"
+
+//列表返回的字段
+const googleDriveFields = "nextPageToken,files(id,name,mimeType,size,createdTime,modifiedTime,parents)"
+
+//接口、上传接口及令牌接口地址，自定义接口地址时都在同一域名下
+func googleDriveEndpoints(account entity.Account) (string, string, string) {
+	if account.Api != "" {
+		base := strings.TrimSuffix(account.Api, "/")
+		return base + "/drive/v3", base + "/upload/drive/v3", base + "/token"
+	}
+	return "https://www.googleapis.com/drive/v3", "https://www.googleapis.com/upload/drive/v3", "https://oauth2.googleapis.com/token"
+}
+
+//接口错误信息，令牌接口为error、error_description，其他接口为error.code、error.message
+func googleDriveError(resp *nic.Response) error {
+	e := jsoniter.Get(resp.Bytes, "error")
+	if desc := jsoniter.Get(resp.Bytes, "error_description").ToString(); desc != "" {
+		return fmt.Errorf("%s: %s", e.ToString(), desc)
+	}
+	if msg := e.Get("message").ToString(); msg != "" {
+		return fmt.Errorf("%d: %s", e.Get("code").ToInt(), msg)
+	}
+	return errors.New(resp.Status)
+}
+
+func googleDriveSend(method, u string, option nic.H) (*nic.Response, error) {
+	session := nic.NewSession()
+	metrics.Hook(session, "googledrive")
+	resp, err := session.Request(method, u, option)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp, googleDriveError(resp)
+	}
+	return resp, nil
+}
+
+//使用刷新令牌换取访问令牌，User为应用的client_id，Password为client_secret
+func GoogleDriveRefreshToken(account entity.Account) (entity.GoogleDrive, error) {
+	api, upload, tokenUrl := googleDriveEndpoints(account)
+	refreshToken := account.RefreshToken
+	if gd, ok := GoogleDrives.Load(account.Id); ok && gd.(entity.GoogleDrive).RefreshToken != "" {
+		refreshToken = gd.(entity.GoogleDrive).RefreshToken
+	}
+	resp, err := googleDriveSend(http.MethodPost, tokenUrl, nic.H{Data: nic.KV{
+		"client_id":     account.User,
+		"client_secret": account.Password,
+		"grant_type":    "refresh_token",
+		"refresh_token": refreshToken,
+	}})
+	if err != nil {
+		return entity.GoogleDrive{}, fmt.Errorf("令牌刷新失败：%s", err.Error())
+	}
+	gd := entity.GoogleDrive{
+		AccessToken:  jsoniter.Get(resp.Bytes, "access_token").ToString(),
+		RefreshToken: jsoniter.Get(resp.Bytes, "refresh_token").ToString(),
+		Expires:      time.Now().Add(time.Duration(jsoniter.Get(resp.Bytes, "expires_in").ToInt64()) * time.Second),
+		Api:          api,
+		Upload:       upload,
+		DriveId:      account.SiteId,
+	}
+	if gd.AccessToken == "" {
+		return gd, errors.New("令牌刷新失败：未返回access_token")
+	}
+	if gd.RefreshToken == "" {
+		//Google刷新时一般不返回新的刷新令牌，继续使用原来的
+		gd.RefreshToken = refreshToken
+	}
+	//校验网盘是否可以访问
+	check := api + "/about?fields=user"
+	if gd.DriveId != "" {
+		check = api + "/drives/" + url.PathEscape(gd.DriveId)
+	}
+	if _, err = googleDriveSend(http.MethodGet, check, nic.H{Headers: nic.KV{"Authorization": "Bearer " + gd.AccessToken}}); err != nil {
+		return gd, err
+	}
+	GoogleDrives.Store(account.Id, gd)
+	return gd, nil
+}
+
+//访问令牌是否已过期（提前5分钟），未登录也视为过期
+func GoogleDriveExpired(accountId string) bool {
+	gd, ok := GoogleDrives.Load(accountId)
+	return !ok || time.Now().Add(5*time.Minute).After(gd.(entity.GoogleDrive).Expires)
+}
+
+//已登录的Google Drive账号
+func GoogleDriveAccountIds() []string {
+	ids := []string{}
+	GoogleDrives.Range(func(k, v interface{}) bool {
+		ids = append(ids, k.(string))
+		return true
+	})
+	return ids
+}
+
+func googleDrive(accountId string) (entity.GoogleDrive, error) {
+	v, ok := GoogleDrives.Load(accountId)
+	if !ok {
+		return entity.GoogleDrive{}, errors.New("Google Drive未登录")
+	}
+	return v.(entity.GoogleDrive), nil
+}
+
+//接口请求，p为相对于接口前缀的路径，所有请求都支持共享云端硬盘
+func googleDriveRequest(accountId, method, p string, option nic.H) (*nic.Response, error) {
+	gd, err := googleDrive(accountId)
+	if err != nil {
+		return nil, err
+	}
+	if option.Headers == nil {
+		option.Headers = nic.KV{}
+	}
+	option.Headers["Authorization"] = "Bearer " + gd.AccessToken
+	if option.Params == nil {
+		option.Params = nic.KV{}
+	}
+	option.Params["supportsAllDrives"] = "true"
+	if !strings.HasPrefix(p, "http") {
+		p = gd.Api + p
+	}
+	return googleDriveSend(method, p, option)
+}
+
+//根目录，共享云端硬盘的根目录id即云端硬盘id
+func GoogleDriveRootId(account entity.Account) string {
+	if (account.RootId == "" || account.RootId == "root") && account.SiteId != "" {
+		return account.SiteId
+	}
+	if account.RootId == "" {
+		return "root"
+	}
+	return account.RootId
+}
+
+//获取某一目录下的文件列表（单层），Google文档等在线文件无法直接下载，不显示
+func GoogleDriveGetFiles(accountId, fileId, p string) ([]entity.FileNode, error) {
+	gd, err := googleDrive(accountId)
+	if err != nil {
+		return nil, err
+	}
+	params := nic.KV{
+		"q":                         fmt.Sprintf("'%s' in parents and trashed = false", strings.ReplaceAll(fileId, "'", "\\'")),
+		"fields":                    googleDriveFields,
+		"pageSize":                  "1000",
+		"includeItemsFromAllDrives": "true",
+	}
+	if gd.DriveId != "" {
+		params["corpora"] = "drive"
+		params["driveId"] = gd.DriveId
+	}
+	list := []entity.FileNode{}
+	for {
+		resp, err := googleDriveRequest(accountId, http.MethodGet, "/files", nic.H{Params: params})
+		if err != nil {
+			return list, err
+		}
+		var files []map[string]interface{}
+		json.Unmarshal([]byte(jsoniter.Get(resp.Bytes, "files").ToString()), &files)
+		for _, item := range files {
+			mimeType, _ := item["mimeType"].(string)
+			if mimeType != googleDriveFolder && strings.HasPrefix(mimeType, "application/vnd.google-apps.") {
+				continue
+			}
+			list = append(list, googleDriveFileNode(accountId, fileId, p, item))
+		}
+		pageToken := jsoniter.Get(resp.Bytes, "nextPageToken").ToString()
+		if pageToken == "" {
+			break
+		}
+		params["pageToken"] = pageToken
+	}
+	return list, nil
+}
+
+func googleDriveFileNode(accountId, parentId, p string, item map[string]interface{}) entity.FileNode {
+	fn := entity.FileNode{}
+	fn.AccountId = accountId
+	fn.FileId, _ = item["id"].(string)
+	fn.FileName, _ = item["name"].(string)
+	if t, ok := item["createdTime"].(string); ok {
+		fn.CreateTime = UTCTimeFormat(t)
+	}
+	if t, ok := item["modifiedTime"].(string); ok {
+		fn.LastOpTime = UTCTimeFormat(t)
+	}
+	fn.ParentId = parentId
+	fn.Delete = 1
+	if item["mimeType"] == googleDriveFolder {
+		fn.IsFolder = true
+		fn.SizeFmt = "-"
+	} else {
+		//size为字符串
+		size, _ := item["size"].(string)
+		fmt.Sscan(size, &fn.FileSize)
+		fn.SizeFmt = FormatFileSize(fn.FileSize)
+		if i := strings.LastIndex(fn.FileName, "."); i >= 0 {
+			fn.FileType = strings.ToLower(fn.FileName[i+1:])
+		}
+		fn.MediaType = GetMediaType(fn.FileName)
+	}
+	if IsHideFile(fn.FileId) {
+		fn.Hide = 1
+	}
+	fn.ParentPath = p
+	if p == "/" {
+		fn.Path = p + fn.FileName
+	} else {
+		fn.Path = p + "/" + fn.FileName
+	}
+	return fn
+}
+
+//文件内容地址（alt=media），请求时需要带上GoogleDriveHeader
+func GoogleDriveGetDownloadUrl(accountId, fileId string) (string, error) {
+	gd, err := googleDrive(accountId)
+	if err != nil {
+		return "", err
+	}
+	return gd.Api + "/files/" + url.PathEscape(fileId) + "?alt=media&supportsAllDrives=true", nil
+}
+
+//下载文件内容需要的授权请求头
+func GoogleDriveHeader(accountId string) map[string]string {
+	gd, err := googleDrive(accountId)
+	if err != nil {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + gd.AccessToken}
+}
+
+//上传文件，使用可续传上传（resumable）分片上传，同名文件不会覆盖
+func GoogleDriveUpload(accountId, parentId, name string, size int64, r io.Reader) error {
+	gd, err := googleDrive(accountId)
+	if err != nil {
+		return err
+	}
+	t1 := time.Now()
+	resp, err := googleDriveRequest(accountId, http.MethodPost, gd.Upload+"/files", nic.H{
+		Params:  nic.KV{"uploadType": "resumable"},
+		Headers: nic.KV{"X-Upload-Content-Length": fmt.Sprintf("%d", size)},
+		JSON:    nic.KV{"name": name, "parents": []string{parentId}},
+	})
+	if err != nil {
+		return err
+	}
+	uploadUrl := resp.Header.Get("Location")
+	if uploadUrl == "" {
+		return errors.New("Google Drive上传会话创建失败")
+	}
+	//未完成时返回308，不能当作重定向处理
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	offset := int64(0)
+	for {
+		n := size - offset
+		if n > googleDriveChunkSize {
+			n = googleDriveChunkSize
+		}
+		req, err := http.NewRequest(http.MethodPut, uploadUrl, io.LimitReader(r, n))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = n
+		if size == 0 {
+			req.Header.Set("Content-Range", "bytes */0")
+		} else {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size))
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		offset += n
+		if res.StatusCode == http.StatusOK || res.StatusCode == http.StatusCreated {
+			break
+		}
+		if res.StatusCode != http.StatusPermanentRedirect || offset >= size {
+			return fmt.Errorf("分片%d上传失败：%s", offset/googleDriveChunkSize+1, res.Status)
+		}
+	}
+	log.Debugf("文件：%s，上传成功，耗时：%s", name, ShortDur(time.Now().Sub(t1)))
+	return nil
+}
+
+//创建目录，Google Drive允许同名目录，已存在时返回错误
+func GoogleDriveMkdir(accountId, parentId, name string) error {
+	list, err := GoogleDriveGetFiles(accountId, parentId, "/")
+	if err != nil {
+		return err
+	}
+	for _, fn := range list {
+		if fn.FileName == name {
+			return fmt.Errorf("%s已存在", name)
+		}
+	}
+	_, err = googleDriveRequest(accountId, http.MethodPost, "/files", nic.H{
+		JSON: nic.KV{"name": name, "mimeType": googleDriveFolder, "parents": []string{parentId}},
+	})
+	return err
+}
+
+//删除文件（夹），移入回收站
+func GoogleDriveDelete(accountId, fileId string) error {
+	_, err := googleDriveRequest(accountId, http.MethodPatch, "/files/"+url.PathEscape(fileId), nic.H{
+		JSON: nic.KV{"trashed": true},
+	})
+	return err
+}
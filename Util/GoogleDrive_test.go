@@ -0,0 +1,143 @@
+package Util
+
+import (
+	"PanIndex/entity"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGoogleDriveRootId(t *testing.T) {
+	tests := []struct {
+		name    string
+		account entity.Account
+		want    string
+	}{
+		{"我的云端硬盘", entity.Account{}, "root"},
+		{"共享云端硬盘", entity.Account{RootId: "root", SiteId: "sd1"}, "sd1"},
+		{"指定目录", entity.Account{RootId: "dir1", SiteId: "sd1"}, "dir1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GoogleDriveRootId(tt.account); got != tt.want {
+				t.Errorf("GoogleDriveRootId() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+//模拟Drive v3接口：共享云端硬盘、分页的文件列表及可续传上传
+func TestGoogleDrive(t *testing.T) {
+	var srv *httptest.Server
+	var uploaded, contentRange string
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/session" {
+			data, _ := ioutil.ReadAll(r.Body)
+			uploaded, contentRange = string(data), r.Header.Get("Content-Range")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if r.URL.Path == "/token" {
+			r.ParseForm()
+			if r.Form.Get("refresh_token") != "rt1" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"Bad Request"}`))
+				return
+			}
+			w.Write([]byte(`{"access_token":"at","expires_in":3599}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer at" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		switch r.Method + " " + r.URL.Path {
+		case "GET /drive/v3/drives/sd1":
+			w.Write([]byte(`{"id":"sd1"}`))
+		case "GET /drive/v3/files":
+			if q.Get("supportsAllDrives") != "true" || q.Get("corpora") != "drive" || q.Get("driveId") != "sd1" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if q.Get("q") != "'sd1' in parents and trashed = false" {
+				w.Write([]byte(`{"files":[]}`))
+			} else if q.Get("pageToken") == "" {
+				w.Write([]byte(`{"nextPageToken":"p2","files":[{"id":"d1","name":"docs","mimeType":"application/vnd.google-apps.folder","modifiedTime":"2021-01-02T03:04:05.000Z"},
+					{"id":"g1","name":"在线文档","mimeType":"application/vnd.google-apps.document"}]}`))
+			} else {
+				w.Write([]byte(`{"files":[{"id":"f1","name":"a.MP4","mimeType":"video/mp4","size":"1048576"}]}`))
+			}
+		case "POST /drive/v3/files":
+			w.Write([]byte(`{"id":"d2"}`))
+		case "POST /upload/drive/v3/files":
+			if q.Get("uploadType") != "resumable" || r.Header.Get("X-Upload-Content-Length") != "5" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Location", srv.URL+"/session")
+		case "PATCH /drive/v3/files/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"File not found: missing."}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	account := entity.Account{Id: "gd-test", Api: srv.URL + "/", User: "cid", Password: "secret", RefreshToken: "rt1", SiteId: "sd1"}
+	defer GoogleDrives.Delete(account.Id)
+
+	if _, err := GoogleDriveRefreshToken(entity.Account{Id: "gd-bad", Api: srv.URL, RefreshToken: "x"}); err == nil || !strings.Contains(err.Error(), "invalid_grant: Bad Request") {
+		t.Errorf("GoogleDriveRefreshToken(bad) = %v", err)
+	}
+	gd, err := GoogleDriveRefreshToken(account)
+	if err != nil {
+		t.Fatalf("GoogleDriveRefreshToken: %v", err)
+	}
+	if gd.RefreshToken != "rt1" || gd.Api != srv.URL+"/drive/v3" || GoogleDriveExpired(account.Id) {
+		t.Errorf("GoogleDriveRefreshToken = %+v", gd)
+	}
+
+	list, err := GoogleDriveGetFiles(account.Id, "sd1", "/")
+	if err != nil {
+		t.Fatalf("GoogleDriveGetFiles: %v", err)
+	}
+	got := []string{}
+	for _, fn := range list {
+		got = append(got, fn.Path)
+	}
+	if !reflect.DeepEqual(got, []string{"/docs", "/a.MP4"}) {
+		t.Fatalf("GoogleDriveGetFiles = %v", got)
+	}
+	if d := list[0]; !d.IsFolder || d.ParentId != "sd1" || d.LastOpTime != UTCTimeFormat("2021-01-02T03:04:05.000Z") {
+		t.Errorf("folder = %+v", d)
+	}
+	if f := list[1]; f.IsFolder || f.FileSize != 1048576 || f.FileType != "mp4" {
+		t.Errorf("file = %+v", f)
+	}
+
+	if u, _ := GoogleDriveGetDownloadUrl(account.Id, "f1"); u != srv.URL+"/drive/v3/files/f1?alt=media&supportsAllDrives=true" {
+		t.Errorf("GoogleDriveGetDownloadUrl = %s", u)
+	}
+	if h := GoogleDriveHeader(account.Id); h["Authorization"] != "Bearer at" {
+		t.Errorf("GoogleDriveHeader = %v", h)
+	}
+	if err := GoogleDriveMkdir(account.Id, "sd1", "docs"); err == nil {
+		t.Error("GoogleDriveMkdir(existing) = nil")
+	}
+	if err := GoogleDriveMkdir(account.Id, "sd1", "new"); err != nil {
+		t.Errorf("GoogleDriveMkdir: %v", err)
+	}
+	if err := GoogleDriveDelete(account.Id, "missing"); err == nil || err.Error() != "404: File not found: missing." {
+		t.Errorf("GoogleDriveDelete(missing) = %v", err)
+	}
+	if err := GoogleDriveUpload(account.Id, "sd1", "a.txt", 5, strings.NewReader("hello")); err != nil {
+		t.Fatalf("GoogleDriveUpload: %v", err)
+	}
+	if uploaded != "hello" || contentRange != "bytes 0-4/5" {
+		t.Errorf("uploaded %q with Content-Range %q", uploaded, contentRange)
+	}
+}
@@ -0,0 +1,299 @@
+package Util
+
+import (
+	"PanIndex/entity"
+	"PanIndex/metrics"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eddieivan01/nic"
+	jsoniter "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//账号id -> entity.OneDrive
+var OneDrives sync.Map
+
+//上传分片大小，需要是320KB的整数倍
+const oneDriveChunkSize = 320 * 1024 * 32
+
+//Graph接口地址及登录地址，自定义接口地址时登录地址为同一域名
+func oneDriveEndpoints(account entity.Account) (string, string) {
+	api, login := "https://graph.microsoft.com/v1.0", "https://login.microsoftonline.com"
+	if account.Region == "cn" {
+		//世纪互联
+		api, login = "https://microsoftgraph.chinacloudapi.cn/v1.0", "https://login.chinacloudapi.cn"
+	}
+	if account.Api != "" {
+		api = strings.TrimSuffix(account.Api, "/")
+		if u, err := url.Parse(api); err == nil {
+			login = u.Scheme + "://" + u.Host
+		}
+	}
+	return api, login
+}
+
+//OneDrive接口错误信息，令牌接口为error、error_description，其他接口为error.code、error.message
+func oneDriveError(resp *nic.Response) error {
+	e := jsoniter.Get(resp.Bytes, "error")
+	if desc := jsoniter.Get(resp.Bytes, "error_description").ToString(); desc != "" {
+		return fmt.Errorf("%s: %s", e.ToString(), desc)
+	}
+	if code := e.Get("code").ToString(); code != "" {
+		return fmt.Errorf("%s: %s", code, e.Get("message").ToString())
+	}
+	return errors.New(resp.Status)
+}
+
+func oneDriveSend(method, u string, option nic.H) (*nic.Response, error) {
+	session := nic.NewSession()
+	metrics.Hook(session, "onedrive")
+	resp, err := session.Request(method, u, option)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp, oneDriveError(resp)
+	}
+	return resp, nil
+}
+
+//使用刷新令牌换取访问令牌，User为应用的client_id，Password为client_secret（公共客户端可以为空）
+//返回新的刷新令牌，需要保存
+func OneDriveRefreshToken(account entity.Account) (entity.OneDrive, error) {
+	api, login := oneDriveEndpoints(account)
+	refreshToken := account.RefreshToken
+	if od, ok := OneDrives.Load(account.Id); ok && od.(entity.OneDrive).RefreshToken != "" {
+		//令牌刷新后数据库中的配置可能还未重新加载，使用最新的刷新令牌
+		refreshToken = od.(entity.OneDrive).RefreshToken
+	}
+	data := nic.KV{
+		"client_id":     account.User,
+		"grant_type":    "refresh_token",
+		"refresh_token": refreshToken,
+	}
+	if account.Password != "" {
+		data["client_secret"] = account.Password
+	}
+	resp, err := oneDriveSend(http.MethodPost, login+"/common/oauth2/v2.0/token", nic.H{Data: data})
+	if err != nil {
+		return entity.OneDrive{}, fmt.Errorf("令牌刷新失败：%s", err.Error())
+	}
+	od := entity.OneDrive{
+		AccessToken:  jsoniter.Get(resp.Bytes, "access_token").ToString(),
+		RefreshToken: jsoniter.Get(resp.Bytes, "refresh_token").ToString(),
+		Expires:      time.Now().Add(time.Duration(jsoniter.Get(resp.Bytes, "expires_in").ToInt64()) * time.Second),
+		Drive:        api + "/me/drive",
+	}
+	if od.AccessToken == "" {
+		return od, errors.New("令牌刷新失败：未返回access_token")
+	}
+	if od.RefreshToken == "" {
+		od.RefreshToken = refreshToken
+	}
+	auth := nic.KV{"Authorization": "Bearer " + od.AccessToken}
+	if account.SiteId != "" {
+		//SharePoint站点，“域名:/sites/站点名”需要先换取站点id
+		siteId := account.SiteId
+		if strings.Contains(siteId, ":/") {
+			resp, err = oneDriveSend(http.MethodGet, api+"/sites/"+strings.TrimSuffix(siteId, ":"), nic.H{Headers: auth})
+			if err != nil {
+				return od, fmt.Errorf("SharePoint站点获取失败：%s", err.Error())
+			}
+			siteId = jsoniter.Get(resp.Bytes, "id").ToString()
+		}
+		od.Drive = api + "/sites/" + siteId + "/drive"
+	}
+	//校验网盘是否可以访问
+	if _, err = oneDriveSend(http.MethodGet, od.Drive, nic.H{Headers: auth}); err != nil {
+		return od, err
+	}
+	OneDrives.Store(account.Id, od)
+	return od, nil
+}
+
+//访问令牌是否已过期（提前5分钟），未登录也视为过期
+func OneDriveExpired(accountId string) bool {
+	od, ok := OneDrives.Load(accountId)
+	return !ok || time.Now().Add(5*time.Minute).After(od.(entity.OneDrive).Expires)
+}
+
+//已登录的OneDrive账号
+func OneDriveAccountIds() []string {
+	ids := []string{}
+	OneDrives.Range(func(k, v interface{}) bool {
+		ids = append(ids, k.(string))
+		return true
+	})
+	return ids
+}
+
+//网盘接口请求，p为相对于网盘的路径（例如/items/root/children），或者完整地址（分页的nextLink）
+func oneDriveRequest(accountId, method, p string, option nic.H) ([]byte, error) {
+	v, ok := OneDrives.Load(accountId)
+	if !ok {
+		return nil, errors.New("OneDrive未登录")
+	}
+	od := v.(entity.OneDrive)
+	if !strings.HasPrefix(p, "http") {
+		p = od.Drive + p
+	}
+	if option.Headers == nil {
+		option.Headers = nic.KV{}
+	}
+	option.Headers["Authorization"] = "Bearer " + od.AccessToken
+	resp, err := oneDriveSend(method, p, option)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Bytes, nil
+}
+
+//文件（夹）的地址，id为根目录时可以是root
+func oneDriveItem(fileId string) string {
+	return "/items/" + url.PathEscape(fileId)
+}
+
+//获取某一目录下的文件列表（单层）
+func OneDriveGetFiles(accountId, fileId, p string) ([]entity.FileNode, error) {
+	list := []entity.FileNode{}
+	next := oneDriveItem(fileId) + "/children?$top=1000"
+	for next != "" {
+		data, err := oneDriveRequest(accountId, http.MethodGet, next, nic.H{})
+		if err != nil {
+			return list, err
+		}
+		var items []map[string]interface{}
+		json.Unmarshal([]byte(jsoniter.Get(data, "value").ToString()), &items)
+		for _, item := range items {
+			list = append(list, oneDriveFileNode(accountId, p, item))
+		}
+		next = jsoniter.Get(data, "@odata.nextLink").ToString()
+	}
+	return list, nil
+}
+
+func oneDriveFileNode(accountId, p string, item map[string]interface{}) entity.FileNode {
+	fn := entity.FileNode{}
+	fn.AccountId = accountId
+	fn.FileId, _ = item["id"].(string)
+	fn.FileName, _ = item["name"].(string)
+	if t, ok := item["createdDateTime"].(string); ok {
+		fn.CreateTime = UTCTimeFormat(t)
+	}
+	if t, ok := item["lastModifiedDateTime"].(string); ok {
+		fn.LastOpTime = UTCTimeFormat(t)
+	}
+	if parent, ok := item["parentReference"].(map[string]interface{}); ok {
+		fn.ParentId, _ = parent["id"].(string)
+	}
+	fn.Delete = 1
+	if _, ok := item["folder"]; ok {
+		fn.IsFolder = true
+		fn.SizeFmt = "-"
+	} else {
+		size, _ := item["size"].(float64)
+		fn.FileSize = int64(size)
+		fn.SizeFmt = FormatFileSize(fn.FileSize)
+		if i := strings.LastIndex(fn.FileName, "."); i >= 0 {
+			fn.FileType = strings.ToLower(fn.FileName[i+1:])
+		}
+		fn.MediaType = GetMediaType(fn.FileName)
+		fn.DownloadUrl, _ = item["@microsoft.graph.downloadUrl"].(string)
+	}
+	if IsHideFile(fn.FileId) {
+		fn.Hide = 1
+	}
+	fn.ParentPath = p
+	if p == "/" {
+		fn.Path = p + fn.FileName
+	} else {
+		fn.Path = p + "/" + fn.FileName
+	}
+	return fn
+}
+
+//获取文件下载地址，地址有效期较短，不能缓存
+func OneDriveGetDownloadUrl(accountId, fileId string) (string, error) {
+	data, err := oneDriveRequest(accountId, http.MethodGet, oneDriveItem(fileId), nic.H{})
+	if err != nil {
+		return "", err
+	}
+	downUrl := jsoniter.Get(data, "@microsoft.graph.downloadUrl").ToString()
+	if downUrl == "" {
+		return "", errors.New("OneDrive下载地址获取失败")
+	}
+	return downUrl, nil
+}
+
+//上传文件，使用上传会话分片上传，同名文件自动重命名
+func OneDriveUpload(accountId, parentId, name string, size int64, r io.Reader) error {
+	t1 := time.Now()
+	target := oneDriveItem(parentId) + ":/" + url.PathEscape(name) + ":"
+	if size == 0 {
+		//空文件不能使用上传会话
+		_, err := oneDriveRequest(accountId, http.MethodPut, target+"/content?@microsoft.graph.conflictBehavior=rename", nic.H{})
+		return err
+	}
+	data, err := oneDriveRequest(accountId, http.MethodPost, target+"/createUploadSession", nic.H{
+		JSON: nic.KV{"item": nic.KV{"@microsoft.graph.conflictBehavior": "rename"}},
+	})
+	if err != nil {
+		return err
+	}
+	uploadUrl := jsoniter.Get(data, "uploadUrl").ToString()
+	if uploadUrl == "" {
+		return errors.New("OneDrive上传会话创建失败")
+	}
+	for offset := int64(0); offset < size; offset += oneDriveChunkSize {
+		n := size - offset
+		if n > oneDriveChunkSize {
+			n = oneDriveChunkSize
+		}
+		//上传地址已包含授权信息，不能再带Authorization
+		req, err := http.NewRequest(http.MethodPut, uploadUrl, io.LimitReader(r, n))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = n
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size))
+		res, err := http.DefaultClient.Do(req)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode >= http.StatusBadRequest {
+				err = fmt.Errorf("分片%d上传失败：%s", offset/oneDriveChunkSize+1, res.Status)
+			}
+		}
+		if err != nil {
+			//取消上传会话
+			if req, e := http.NewRequest(http.MethodDelete, uploadUrl, nil); e == nil {
+				if res, e := http.DefaultClient.Do(req); e == nil {
+					res.Body.Close()
+				}
+			}
+			return err
+		}
+	}
+	log.Debugf("文件：%s，上传成功，耗时：%s", name, ShortDur(time.Now().Sub(t1)))
+	return nil
+}
+
+//创建目录
+func OneDriveMkdir(accountId, parentId, name string) error {
+	_, err := oneDriveRequest(accountId, http.MethodPost, oneDriveItem(parentId)+"/children", nic.H{
+		JSON: nic.KV{"name": name, "folder": nic.KV{}, "@microsoft.graph.conflictBehavior": "fail"},
+	})
+	return err
+}
+
+//删除文件（夹），移入回收站
+func OneDriveDelete(accountId, fileId string) error {
+	_, err := oneDriveRequest(accountId, http.MethodDelete, oneDriveItem(fileId), nic.H{})
+	return err
+}
@@ -0,0 +1,127 @@
+package Util
+
+import (
+	"PanIndex/entity"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOneDriveEndpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		account    entity.Account
+		api, login string
+	}{
+		{"国际版", entity.Account{}, "https://graph.microsoft.com/v1.0", "https://login.microsoftonline.com"},
+		{"世纪互联", entity.Account{Region: "cn"}, "https://microsoftgraph.chinacloudapi.cn/v1.0", "https://login.chinacloudapi.cn"},
+		{"自定义接口地址", entity.Account{Api: "http://127.0.0.1:8080/v1.0/"}, "http://127.0.0.1:8080/v1.0", "http://127.0.0.1:8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if api, login := oneDriveEndpoints(tt.account); api != tt.api || login != tt.login {
+				t.Errorf("oneDriveEndpoints() = %s, %s, want %s, %s", api, login, tt.api, tt.login)
+			}
+		})
+	}
+}
+
+//模拟Graph接口：SharePoint站点、分页的文件列表及上传会话
+func TestOneDrive(t *testing.T) {
+	var srv *httptest.Server
+	var uploaded, contentRange string
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upload" {
+			//上传地址不能带Authorization
+			if r.Header.Get("Authorization") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			uploaded, contentRange = string(data), r.Header.Get("Content-Range")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if r.URL.Path == "/common/oauth2/v2.0/token" {
+			r.ParseForm()
+			if r.Form.Get("refresh_token") != "rt1" || r.Form.Get("client_id") != "cid" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"bad token"}`))
+				return
+			}
+			w.Write([]byte(`{"access_token":"at","refresh_token":"rt2","expires_in":3600}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer at" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v1.0/sites/contoso.sharepoint.com:/sites/team":
+			w.Write([]byte(`{"id":"site1"}`))
+		case "GET /v1.0/sites/site1/drive":
+			w.Write([]byte(`{"id":"drive1"}`))
+		case "GET /v1.0/sites/site1/drive/items/root/children":
+			w.Write([]byte(`{"value":[{"id":"d1","name":"docs","folder":{"childCount":1},"parentReference":{"id":"root"},"lastModifiedDateTime":"2021-01-02T03:04:05Z"},
+				{"id":"f1","name":"a.TXT","size":5,"@microsoft.graph.downloadUrl":"https://dl/a"}],
+				"@odata.nextLink":"` + srv.URL + `/page2"}`))
+		case "GET /page2":
+			w.Write([]byte(`{"value":[{"id":"f2","name":"b.jpg","size":2048}]}`))
+		case "GET /v1.0/sites/site1/drive/items/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"itemNotFound","message":"The resource could not be found."}}`))
+		case "POST /v1.0/sites/site1/drive/items/root:/a b.txt:/createUploadSession":
+			w.Write([]byte(`{"uploadUrl":"` + srv.URL + `/upload"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	account := entity.Account{Id: "od-test", Api: srv.URL + "/v1.0", User: "cid", RefreshToken: "rt1", SiteId: "contoso.sharepoint.com:/sites/team"}
+	defer OneDrives.Delete(account.Id)
+
+	if _, err := OneDriveRefreshToken(entity.Account{Id: "od-bad", Api: account.Api, User: "cid", RefreshToken: "x"}); err == nil || !strings.Contains(err.Error(), "invalid_grant: bad token") {
+		t.Errorf("OneDriveRefreshToken(bad) = %v", err)
+	}
+	if !OneDriveExpired(account.Id) {
+		t.Error("OneDriveExpired before login = false")
+	}
+	od, err := OneDriveRefreshToken(account)
+	if err != nil {
+		t.Fatalf("OneDriveRefreshToken: %v", err)
+	}
+	if od.RefreshToken != "rt2" || od.Drive != srv.URL+"/v1.0/sites/site1/drive" || OneDriveExpired(account.Id) {
+		t.Errorf("OneDriveRefreshToken = %+v", od)
+	}
+
+	list, err := OneDriveGetFiles(account.Id, "root", "/")
+	if err != nil {
+		t.Fatalf("OneDriveGetFiles: %v", err)
+	}
+	got := []string{}
+	for _, fn := range list {
+		got = append(got, fn.Path)
+	}
+	if !reflect.DeepEqual(got, []string{"/docs", "/a.TXT", "/b.jpg"}) {
+		t.Fatalf("OneDriveGetFiles = %v", got)
+	}
+	if d := list[0]; !d.IsFolder || d.ParentId != "root" || d.LastOpTime != UTCTimeFormat("2021-01-02T03:04:05Z") {
+		t.Errorf("folder = %+v", d)
+	}
+	if f := list[1]; f.IsFolder || f.FileSize != 5 || f.FileType != "txt" || f.DownloadUrl != "https://dl/a" {
+		t.Errorf("file = %+v", f)
+	}
+
+	if _, err := OneDriveGetDownloadUrl(account.Id, "missing"); err == nil || err.Error() != "itemNotFound: The resource could not be found." {
+		t.Errorf("OneDriveGetDownloadUrl(missing) = %v", err)
+	}
+	if err := OneDriveUpload(account.Id, "root", "a b.txt", 5, strings.NewReader("hello")); err != nil {
+		t.Fatalf("OneDriveUpload: %v", err)
+	}
+	if uploaded != "hello" || contentRange != "bytes 0-4/5" {
+		t.Errorf("uploaded %q with Content-Range %q", uploaded, contentRange)
+	}
+}
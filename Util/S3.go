@@ -0,0 +1,387 @@
+package Util
+
+import (
+	"PanIndex/entity"
+	"PanIndex/metrics"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/eddieivan01/nic"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+//分片上传的分片大小，S3要求除最后一片外不小于5MB
+const s3PartSize = 10 * 1024 * 1024
+
+//预签名下载地址默认有效期（秒），S3最长为7天
+const (
+	s3DefaultExpire = 3600
+	s3MaxExpire     = 7 * 24 * 3600
+)
+
+const s3EmptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+//ListObjectsV2返回结果
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified string
+		Size         int64
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+//接口地址及区域，Api为空时使用AWS官方地址，区域默认us-east-1
+func s3Endpoint(account entity.Account) (string, string) {
+	region := account.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := strings.TrimSuffix(account.Api, "/")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	return endpoint, region
+}
+
+//对象key按S3的规则编码，保留/
+func s3EscapePath(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = s3Escape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+//除字母、数字及-._~外全部编码
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+//按参数名排序后编码的查询字符串
+func s3Query(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	q := make([]string, 0, len(keys))
+	for _, k := range keys {
+		q = append(q, s3Escape(k)+"="+s3Escape(params[k]))
+	}
+	return strings.Join(q, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+//AWS Signature Version 4签名，返回签名及凭证范围
+func s3Signature(account entity.Account, region string, t time.Time, canonicalRequest string) (string, string) {
+	day := t.Format("20060102")
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format("20060102T150405Z") + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+account.Password), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign)), scope
+}
+
+//发送签名请求，key为对象key（为空表示存储桶），使用路径方式访问存储桶以兼容MinIO等
+func s3Request(account entity.Account, method, key string, params map[string]string, body []byte) (*nic.Response, error) {
+	endpoint, region := s3Endpoint(account)
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	t := time.Now().UTC()
+	path := u.EscapedPath() + "/" + s3EscapePath(account.Bucket)
+	if key != "" {
+		path += "/" + s3EscapePath(key)
+	}
+	query := s3Query(params)
+	payloadHash := s3EmptyHash
+	if len(body) > 0 {
+		payloadHash = sha256Hex(body)
+	}
+	amzDate := t.Format("20060102T150405Z")
+	canonicalRequest := method + "\n" + path + "\n" + query + "\n" +
+		"host:" + u.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n\n" +
+		"host;x-amz-content-sha256;x-amz-date\n" + payloadHash
+	signature, scope := s3Signature(account, region, t, canonicalRequest)
+	headers := nic.KV{
+		"x-amz-date":           amzDate,
+		"x-amz-content-sha256": payloadHash,
+		"Authorization": "AWS4-HMAC-SHA256 Credential=" + account.User + "/" + scope +
+			", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=" + signature,
+	}
+	target := u.Scheme + "://" + u.Host + path
+	if query != "" {
+		target += "?" + query
+	}
+	session := nic.NewSession()
+	metrics.Hook(session, "s3")
+	resp, err := session.Request(method, target, nic.H{Headers: headers, Raw: string(body)})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		e := s3Error{}
+		if xml.Unmarshal(resp.Bytes, &e) == nil && e.Code != "" {
+			return resp, fmt.Errorf("%s: %s", e.Code, e.Message)
+		}
+		return resp, errors.New(resp.Status)
+	}
+	return resp, nil
+}
+
+//根目录对应的前缀，为空或/表示整个存储桶，其他以/结尾
+func S3RootPrefix(account entity.Account) string {
+	prefix := strings.Trim(account.RootId, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+//校验存储桶是否可以访问
+func S3Check(account entity.Account) error {
+	if account.Bucket == "" {
+		return errors.New("未设置存储桶")
+	}
+	_, err := s3Request(account, http.MethodGet, "", map[string]string{
+		"list-type": "2", "max-keys": "1", "prefix": S3RootPrefix(account),
+	}, nil)
+	return err
+}
+
+//列出前缀下的对象，delimiter为/时公共前缀作为目录
+func s3List(account entity.Account, prefix, delimiter string, fn func(result s3ListResult)) error {
+	params := map[string]string{"list-type": "2", "max-keys": "1000", "prefix": prefix}
+	if delimiter != "" {
+		params["delimiter"] = delimiter
+	}
+	for {
+		resp, err := s3Request(account, http.MethodGet, "", params, nil)
+		if err != nil {
+			return err
+		}
+		result := s3ListResult{}
+		if err = xml.Unmarshal(resp.Bytes, &result); err != nil {
+			return err
+		}
+		fn(result)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		params["continuation-token"] = result.NextContinuationToken
+	}
+}
+
+//获取某一目录下的文件列表（单层），fileId为以/结尾的前缀，目录没有修改时间
+func S3GetFiles(account entity.Account, prefix, p string) ([]entity.FileNode, error) {
+	list := []entity.FileNode{}
+	err := s3List(account, prefix, "/", func(result s3ListResult) {
+		for _, cp := range result.CommonPrefixes {
+			fn := s3FileNode(account, prefix, p, cp.Prefix)
+			fn.IsFolder = true
+			fn.SizeFmt = "-"
+			list = append(list, fn)
+		}
+		for _, c := range result.Contents {
+			if c.Key == prefix {
+				//目录占位对象
+				continue
+			}
+			fn := s3FileNode(account, prefix, p, c.Key)
+			fn.FileSize = c.Size
+			fn.SizeFmt = FormatFileSize(c.Size)
+			fn.CreateTime = UTCTimeFormat(c.LastModified)
+			fn.LastOpTime = fn.CreateTime
+			if i := strings.LastIndex(fn.FileName, "."); i >= 0 {
+				fn.FileType = strings.ToLower(fn.FileName[i+1:])
+			}
+			fn.MediaType = GetMediaType(fn.FileName)
+			list = append(list, fn)
+		}
+	})
+	return list, err
+}
+
+func s3FileNode(account entity.Account, prefix, p, key string) entity.FileNode {
+	fn := entity.FileNode{}
+	fn.AccountId = account.Id
+	fn.FileId = key
+	fn.FileName = strings.TrimSuffix(strings.TrimPrefix(key, prefix), "/")
+	fn.ParentId = prefix
+	fn.Delete = 1
+	if IsHideFile(fn.FileId) {
+		fn.Hide = 1
+	}
+	fn.ParentPath = p
+	if p == "/" {
+		fn.Path = p + fn.FileName
+	} else {
+		fn.Path = p + "/" + fn.FileName
+	}
+	return fn
+}
+
+//预签名的下载地址，有效期为账号设置的直链有效期
+func S3GetDownloadUrl(account entity.Account, key string) (string, error) {
+	return s3PresignUrl(account, key, time.Now().UTC())
+}
+
+//以t为签名时间生成预签名地址
+func s3PresignUrl(account entity.Account, key string, t time.Time) (string, error) {
+	endpoint, region := s3Endpoint(account)
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	expire := account.LinkExpire
+	if expire <= 0 {
+		expire = s3DefaultExpire
+	} else if expire > s3MaxExpire {
+		expire = s3MaxExpire
+	}
+	path := u.EscapedPath() + "/" + s3EscapePath(account.Bucket) + "/" + s3EscapePath(key)
+	params := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    account.User + "/" + t.Format("20060102") + "/" + region + "/s3/aws4_request",
+		"X-Amz-Date":          t.Format("20060102T150405Z"),
+		"X-Amz-Expires":       fmt.Sprintf("%d", expire),
+		"X-Amz-SignedHeaders": "host",
+	}
+	query := s3Query(params)
+	signature, _ := s3Signature(account, region, t, "GET\n"+path+"\n"+query+"\nhost:"+u.Host+"\n\nhost\nUNSIGNED-PAYLOAD")
+	return u.Scheme + "://" + u.Host + path + "?" + query + "&X-Amz-Signature=" + signature, nil
+}
+
+//上传文件，超过一个分片时使用分片上传，同名文件会被覆盖
+func S3Upload(account entity.Account, prefix, name string, size int64, r io.Reader) error {
+	t1 := time.Now()
+	key := prefix + name
+	if size <= s3PartSize {
+		data, err := ioutil.ReadAll(io.LimitReader(r, size))
+		if err != nil {
+			return err
+		}
+		if _, err = s3Request(account, http.MethodPut, key, nil, data); err != nil {
+			return err
+		}
+		log.Debugf("文件：%s，上传成功，耗时：%s", name, ShortDur(time.Now().Sub(t1)))
+		return nil
+	}
+	resp, err := s3Request(account, http.MethodPost, key, map[string]string{"uploads": ""}, nil)
+	if err != nil {
+		return err
+	}
+	upload := struct{ UploadId string }{}
+	xml.Unmarshal(resp.Bytes, &upload)
+	if upload.UploadId == "" {
+		return errors.New("S3分片上传创建失败")
+	}
+	complete := bytes.NewBufferString("<CompleteMultipartUpload>")
+	buf := make([]byte, s3PartSize)
+	for part, offset := 1, int64(0); offset < size; part, offset = part+1, offset+s3PartSize {
+		n := size - offset
+		if n > s3PartSize {
+			n = s3PartSize
+		}
+		if _, err = io.ReadFull(r, buf[:n]); err == nil {
+			resp, err = s3Request(account, http.MethodPut, key, map[string]string{
+				"partNumber": fmt.Sprintf("%d", part), "uploadId": upload.UploadId,
+			}, buf[:n])
+		}
+		if err != nil {
+			//取消分片上传，删除已上传的分片
+			s3Request(account, http.MethodDelete, key, map[string]string{"uploadId": upload.UploadId}, nil)
+			return fmt.Errorf("分片%d上传失败：%s", part, err.Error())
+		}
+		fmt.Fprintf(complete, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", part, resp.Header.Get("ETag"))
+	}
+	complete.WriteString("</CompleteMultipartUpload>")
+	resp, err = s3Request(account, http.MethodPost, key, map[string]string{"uploadId": upload.UploadId}, complete.Bytes())
+	if err == nil {
+		//完成分片上传时出错也可能返回200，错误信息在响应中
+		e := s3Error{}
+		if xml.Unmarshal(resp.Bytes, &e) == nil && e.Code != "" {
+			err = fmt.Errorf("%s: %s", e.Code, e.Message)
+		}
+	}
+	if err != nil {
+		s3Request(account, http.MethodDelete, key, map[string]string{"uploadId": upload.UploadId}, nil)
+		return err
+	}
+	log.Debugf("文件：%s，上传成功，耗时：%s", name, ShortDur(time.Now().Sub(t1)))
+	return nil
+}
+
+//创建目录，写入以/结尾的空对象作为占位
+func S3Mkdir(account entity.Account, prefix, name string) error {
+	key := prefix + name + "/"
+	exists := false
+	err := s3List(account, key, "", func(result s3ListResult) {
+		exists = exists || len(result.Contents) > 0
+	})
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%s已存在", name)
+	}
+	_, err = s3Request(account, http.MethodPut, key, nil, nil)
+	return err
+}
+
+//删除文件，目录会删除该前缀下的所有对象
+func S3Delete(account entity.Account, key string) error {
+	if !strings.HasSuffix(key, "/") {
+		_, err := s3Request(account, http.MethodDelete, key, nil, nil)
+		return err
+	}
+	keys := []string{}
+	err := s3List(account, key, "", func(result s3ListResult) {
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, err = s3Request(account, http.MethodDelete, k, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
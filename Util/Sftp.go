@@ -0,0 +1,605 @@
+package Util
+
+import (
+	"PanIndex/entity"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//sftp协议版本3（draft-ietf-secsh-filexfer-02）使用的报文类型
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpStat     = 17
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+	sftpStatusOk = 0
+	sftpEOF      = 1
+)
+
+//每次读写的数据大小，以及同时发出的读写请求数
+const (
+	sftpChunkSize = 32 * 1024
+	sftpWindow    = 16
+)
+
+//sftp服务器返回的错误状态，连接仍然可用
+type sftpStatusError struct {
+	code uint32
+	msg  string
+}
+
+func (e *sftpStatusError) Error() string {
+	return fmt.Sprintf("sftp: %s (%d)", e.msg, e.code)
+}
+
+//一个ssh连接及其上的sftp会话，同一时间只由一个操作使用
+type sftpConn struct {
+	client  *ssh.Client
+	session *ssh.Session
+	w       io.WriteCloser
+	r       *bufio.Reader
+	id      uint32
+}
+
+func (c *sftpConn) Close() error {
+	c.session.Close()
+	return c.client.Close()
+}
+
+//连接池的key，地址、账号及认证信息变化后重新连接
+func sftpKey(account entity.Account) string {
+	return strings.Join([]string{account.Api, account.User, account.Password, account.PrivateKey, account.HostKey}, "\n")
+}
+
+//地址格式为host:port或sftp://host:port，默认端口22
+func sftpAddr(account entity.Account) string {
+	addr := strings.TrimSuffix(strings.TrimPrefix(account.Api, "sftp://"), "/")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	return addr
+}
+
+//校验主机公钥，未设置指纹时接受任意公钥并在日志中输出指纹
+func sftpHostKey(account entity.Account) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if account.HostKey == "" {
+			log.Warningf("[%s]未设置主机指纹，当前主机指纹为：%s", account.Name, fingerprint)
+			return nil
+		}
+		if fingerprint != account.HostKey {
+			return fmt.Errorf("主机指纹不一致：%s", fingerprint)
+		}
+		return nil
+	}
+}
+
+func sftpDial(account entity.Account) (io.Closer, error) {
+	auths := []ssh.AuthMethod{}
+	if account.PrivateKey != "" {
+		//私钥有密码时，密码即私钥的密码
+		signer, err := ssh.ParsePrivateKey([]byte(account.PrivateKey))
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(account.PrivateKey), []byte(account.Password))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("私钥解析失败：%s", err.Error())
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	} else {
+		auths = append(auths, ssh.Password(account.Password), ssh.KeyboardInteractive(
+			func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = account.Password
+				}
+				return answers, nil
+			}))
+	}
+	client, err := ssh.Dial("tcp", sftpAddr(account), &ssh.ClientConfig{
+		User:            account.User,
+		Auth:            auths,
+		HostKeyCallback: sftpHostKey(account),
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	c := &sftpConn{client: client, session: session}
+	if c.w, err = session.StdinPipe(); err == nil {
+		var r io.Reader
+		if r, err = session.StdoutPipe(); err == nil {
+			c.r = bufio.NewReaderSize(r, 64*1024)
+			err = session.RequestSubsystem("sftp")
+		}
+	}
+	if err == nil {
+		err = c.init()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *sftpConn) init() error {
+	if err := c.writePacket(sftpInit, uint32(3)); err != nil {
+		return err
+	}
+	typ, _, err := c.readPacket()
+	if err == nil && typ != sftpVersion {
+		err = fmt.Errorf("sftp初始化失败：%d", typ)
+	}
+	return err
+}
+
+//写入一个报文，字段支持uint32、uint64、string及[]byte（按string编码）
+func (c *sftpConn) writePacket(typ byte, fields ...interface{}) error {
+	buf := []byte{0, 0, 0, 0, typ}
+	for _, f := range fields {
+		switch v := f.(type) {
+		case uint32:
+			buf = appendUint32(buf, v)
+		case uint64:
+			buf = appendUint32(buf, uint32(v>>32))
+			buf = appendUint32(buf, uint32(v))
+		case string:
+			buf = appendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+		case []byte:
+			buf = appendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+		}
+	}
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+	_, err := c.w.Write(buf)
+	return err
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+//读取一个报文，返回类型及类型之后的内容
+func (c *sftpConn) readPacket() (byte, []byte, error) {
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(c.r, head); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(head)
+	if n < 1 || n > 1024*1024 {
+		return 0, nil, fmt.Errorf("sftp报文长度错误：%d", n)
+	}
+	body := make([]byte, n-1)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return head[4], body, nil
+}
+
+//发送请求，字段前自动加上请求id，返回请求id
+func (c *sftpConn) send(typ byte, fields ...interface{}) (uint32, error) {
+	c.id++
+	return c.id, c.writePacket(typ, append([]interface{}{c.id}, fields...)...)
+}
+
+//读取响应，返回请求id及内容，STATUS为错误时返回sftpStatusError
+func (c *sftpConn) recv() (uint32, byte, *sftpBuf, error) {
+	typ, body, err := c.readPacket()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	b := &sftpBuf{data: body}
+	id := b.uint32()
+	if typ == sftpStatus {
+		code := b.uint32()
+		if code != sftpStatusOk {
+			return id, typ, b, &sftpStatusError{code: code, msg: b.string()}
+		}
+	}
+	if b.err != nil {
+		return id, typ, b, b.err
+	}
+	return id, typ, b, nil
+}
+
+//发送请求并等待响应
+func (c *sftpConn) call(typ byte, fields ...interface{}) (byte, *sftpBuf, error) {
+	id, err := c.send(typ, fields...)
+	if err != nil {
+		return 0, nil, err
+	}
+	rid, rtyp, b, err := c.recv()
+	if err == nil && rid != id {
+		err = fmt.Errorf("sftp响应id错误：%d", rid)
+	}
+	return rtyp, b, err
+}
+
+//打开文件或目录，返回句柄
+func (c *sftpConn) handle(typ byte, fields ...interface{}) (string, error) {
+	rtyp, b, err := c.call(typ, fields...)
+	if err != nil {
+		return "", err
+	}
+	if rtyp != sftpHandle {
+		return "", fmt.Errorf("sftp响应类型错误：%d", rtyp)
+	}
+	return b.string(), b.err
+}
+
+func (c *sftpConn) closeHandle(h string) error {
+	_, _, err := c.call(sftpClose, h)
+	return err
+}
+
+//仅包含大小、权限及修改时间的文件属性
+func (c *sftpConn) stat(p string) (os.FileInfo, error) {
+	rtyp, b, err := c.call(sftpStat, p)
+	if err != nil {
+		return nil, err
+	}
+	if rtyp != sftpAttrs {
+		return nil, fmt.Errorf("sftp响应类型错误：%d", rtyp)
+	}
+	fi := b.attrs(path.Base(p))
+	return fi, b.err
+}
+
+func (c *sftpConn) readDir(dir string) ([]os.FileInfo, error) {
+	h, err := c.handle(sftpOpendir, dir)
+	if err != nil {
+		return nil, err
+	}
+	list := []os.FileInfo{}
+	links := []int{}
+	for {
+		rtyp, b, err := c.call(sftpReaddir, h)
+		if e, ok := err.(*sftpStatusError); ok && e.code == sftpEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if rtyp != sftpName {
+			return nil, fmt.Errorf("sftp响应类型错误：%d", rtyp)
+		}
+		for i, count := 0, int(b.uint32()); i < count && b.err == nil; i++ {
+			name := b.string()
+			b.string() //longname
+			fi := b.attrs(name)
+			if name == "." || name == ".." {
+				continue
+			}
+			if fi.mode&os.ModeSymlink != 0 {
+				links = append(links, len(list))
+			}
+			list = append(list, fi)
+		}
+		if b.err != nil {
+			return nil, b.err
+		}
+	}
+	if err = c.closeHandle(h); err != nil {
+		return nil, err
+	}
+	//符号链接读取实际指向的文件属性，失败时按普通文件显示
+	for _, i := range links {
+		if fi, err := c.stat(path.Join(dir, list[i].Name())); err == nil {
+			list[i] = fi.(remoteFileInfo)
+		} else if _, ok := err.(*sftpStatusError); !ok {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+//递归删除目录
+func (c *sftpConn) removeAll(p string, isDir bool) error {
+	if !isDir {
+		_, _, err := c.call(sftpRemove, p)
+		return err
+	}
+	list, err := c.readDir(p)
+	if err != nil {
+		return err
+	}
+	for _, fi := range list {
+		if err = c.removeAll(path.Join(p, fi.Name()), fi.IsDir()); err != nil {
+			return err
+		}
+	}
+	_, _, err = c.call(sftpRmdir, p)
+	return err
+}
+
+//响应内容的读取
+type sftpBuf struct {
+	data []byte
+	err  error
+}
+
+func (b *sftpBuf) uint32() uint32 {
+	if len(b.data) < 4 {
+		b.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint32(b.data)
+	b.data = b.data[4:]
+	return v
+}
+
+func (b *sftpBuf) uint64() uint64 {
+	return uint64(b.uint32())<<32 | uint64(b.uint32())
+}
+
+func (b *sftpBuf) string() string {
+	n := int(b.uint32())
+	if len(b.data) < n {
+		b.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	s := string(b.data[:n])
+	b.data = b.data[n:]
+	return s
+}
+
+//文件属性，只保留大小、类型及修改时间
+func (b *sftpBuf) attrs(name string) remoteFileInfo {
+	fi := remoteFileInfo{name: name}
+	flags := b.uint32()
+	if flags&0x1 != 0 {
+		fi.size = int64(b.uint64())
+	}
+	if flags&0x2 != 0 {
+		b.uint32()
+		b.uint32()
+	}
+	if flags&0x4 != 0 {
+		perm := b.uint32()
+		switch perm & 0170000 {
+		case 0040000:
+			fi.mode = os.ModeDir
+		case 0120000:
+			fi.mode = os.ModeSymlink
+		}
+		fi.mode |= os.FileMode(perm & 0777)
+	}
+	if flags&0x8 != 0 {
+		b.uint32()
+		fi.modTime = time.Unix(int64(b.uint32()), 0)
+	}
+	if flags&0x80000000 != 0 {
+		for i := b.uint32(); i > 0 && b.err == nil; i-- {
+			b.string()
+			b.string()
+		}
+	}
+	return fi
+}
+
+//从连接池取出连接执行操作，服务器返回的错误不影响连接复用
+func withSftp(account entity.Account, fn func(c *sftpConn) error) error {
+	key := sftpKey(account)
+	conn, err := getConn(account.Id, key, func() (io.Closer, error) {
+		return sftpDial(account)
+	})
+	if err != nil {
+		return err
+	}
+	err = fn(conn.(*sftpConn))
+	_, isStatus := err.(*sftpStatusError)
+	putConn(account.Id, key, conn, err != nil && !isStatus)
+	return err
+}
+
+//校验连接及根目录
+func SftpCheck(account entity.Account) error {
+	return withSftp(account, func(c *sftpConn) error {
+		fi, err := c.stat(account.RootId)
+		if err == nil && !fi.IsDir() {
+			err = errors.New(account.RootId + "不是目录")
+		}
+		return err
+	})
+}
+
+//读取目录
+func SftpReadDir(account entity.Account, dir string) ([]os.FileInfo, error) {
+	var list []os.FileInfo
+	err := withSftp(account, func(c *sftpConn) (err error) {
+		list, err = c.readDir(dir)
+		return
+	})
+	return list, err
+}
+
+//从offset处开始读取文件，关闭后连接放回连接池
+func SftpOpen(account entity.Account, p string, offset int64) (io.ReadCloser, error) {
+	key := sftpKey(account)
+	conn, err := getConn(account.Id, key, func() (io.Closer, error) {
+		return sftpDial(account)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := conn.(*sftpConn)
+	h, err := c.handle(sftpOpen, p, uint32(0x1), uint32(0))
+	if err != nil {
+		_, isStatus := err.(*sftpStatusError)
+		putConn(account.Id, key, conn, !isStatus)
+		return nil, err
+	}
+	return &sftpReader{account: account, key: key, c: c, handle: h, offset: offset, next: offset}, nil
+}
+
+//顺序读取文件，同时发出多个读请求以减少往返等待
+type sftpReader struct {
+	account entity.Account
+	key     string
+	c       *sftpConn
+	handle  string
+	offset  int64    //下一个返回给调用方的位置
+	next    int64    //下一个读请求的位置
+	pending []uint32 //已发出的读请求，按位置排列
+	buf     []byte
+	err     error
+}
+
+func (r *sftpReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		for len(r.pending) < sftpWindow {
+			id, err := r.c.send(sftpRead, r.handle, uint64(r.next), uint32(sftpChunkSize))
+			if err != nil {
+				r.err = err
+				return 0, err
+			}
+			r.pending = append(r.pending, id)
+			r.next += sftpChunkSize
+		}
+		id, typ, b, err := r.c.recv()
+		if err == nil && (id != r.pending[0] || typ != sftpData) {
+			err = fmt.Errorf("sftp响应错误：%d", typ)
+		}
+		r.pending = r.pending[1:]
+		if e, ok := err.(*sftpStatusError); ok && e.code == sftpEOF {
+			r.err = io.EOF
+			continue
+		} else if err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.buf = []byte(b.string())
+		r.offset += int64(len(r.buf))
+		if len(r.buf) < sftpChunkSize && len(r.pending) > 0 {
+			//读取的数据不足时，丢弃之后的请求，从实际位置重新读取
+			if err = r.drain(); err != nil {
+				r.err = err
+			}
+			r.next = r.offset
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+//读取并丢弃已发出请求的响应
+func (r *sftpReader) drain() error {
+	for len(r.pending) > 0 {
+		r.pending = r.pending[1:]
+		if _, _, _, err := r.c.recv(); err != nil {
+			if _, ok := err.(*sftpStatusError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *sftpReader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	broken := r.err != nil && r.err != io.EOF
+	if _, ok := r.err.(*sftpStatusError); ok {
+		broken = false
+	}
+	if !broken {
+		broken = r.drain() != nil || r.c.closeHandle(r.handle) != nil
+	}
+	putConn(r.account.Id, r.key, r.c, broken)
+	r.c = nil
+	return nil
+}
+
+//上传文件到dir目录，同名文件会被覆盖
+func SftpUpload(account entity.Account, dir, name string, r io.Reader) error {
+	return withSftp(account, func(c *sftpConn) error {
+		//写入、创建、清空
+		h, err := c.handle(sftpOpen, path.Join(dir, name), uint32(0x2|0x8|0x10), uint32(0))
+		if err != nil {
+			return err
+		}
+		buf := make([]byte, sftpChunkSize)
+		offset, pending := uint64(0), 0
+		var werr error
+		for {
+			n, rerr := io.ReadFull(r, buf)
+			if n > 0 {
+				if _, err = c.send(sftpWrite, h, offset, buf[:n]); err != nil {
+					return err
+				}
+				offset += uint64(n)
+				pending++
+			}
+			//超过窗口或读取结束时等待写入结果
+			for pending > 0 && (pending >= sftpWindow || rerr != nil) {
+				if _, _, _, err = c.recv(); err != nil {
+					if _, ok := err.(*sftpStatusError); !ok {
+						return err
+					}
+					werr = err
+				}
+				pending--
+			}
+			if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+				break
+			} else if rerr != nil {
+				werr = rerr
+				break
+			}
+		}
+		if err = c.closeHandle(h); werr == nil {
+			werr = err
+		}
+		return werr
+	})
+}
+
+//创建目录
+func SftpMkdir(account entity.Account, dir, name string) error {
+	return withSftp(account, func(c *sftpConn) error {
+		_, _, err := c.call(sftpMkdir, path.Join(dir, name), uint32(0))
+		return err
+	})
+}
+
+//删除文件，目录会递归删除
+func SftpDelete(account entity.Account, p string, isDir bool) error {
+	return withSftp(account, func(c *sftpConn) error {
+		return c.removeAll(p, isDir)
+	})
+}
@@ -0,0 +1,111 @@
+package Util
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+//读写同一个缓冲区的连接，写入的报文可以再读出
+func testSftpConn(data []byte) (*sftpConn, *bytes.Buffer) {
+	buf := bytes.NewBuffer(data)
+	return &sftpConn{w: nopWriteCloser{buf}, r: bufio.NewReader(buf)}, buf
+}
+
+func TestSftpWritePacket(t *testing.T) {
+	c, buf := testSftpConn(nil)
+	if _, err := c.send(sftpRead, "h1", uint64(1)<<32|2, uint32(3), []byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0, 0, 0, 29, sftpRead,
+		0, 0, 0, 1, //请求id
+		0, 0, 0, 2, 'h', '1',
+		0, 0, 0, 1, 0, 0, 0, 2,
+		0, 0, 0, 3,
+		0, 0, 0, 2, 'a', 'b',
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("writePacket() = %v, want %v", buf.Bytes(), want)
+	}
+	if _, err := c.send(sftpClose, "h1"); err != nil || c.id != 2 {
+		t.Errorf("第2个请求的id = %d, %v, want 2", c.id, err)
+	}
+}
+
+func TestSftpRecv(t *testing.T) {
+	packet := func(typ byte, fields ...interface{}) []byte {
+		c, buf := testSftpConn(nil)
+		c.writePacket(typ, fields...)
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		wantId  uint32
+		wantTyp byte
+		wantErr error
+	}{
+		{"句柄", packet(sftpHandle, uint32(7), "h"), 7, sftpHandle, nil},
+		{"成功状态", packet(sftpStatus, uint32(8), uint32(sftpStatusOk), "ok", ""), 8, sftpStatus, nil},
+		{"错误状态", packet(sftpStatus, uint32(9), uint32(2), "No such file", ""), 9, sftpStatus, &sftpStatusError{code: 2, msg: "No such file"}},
+		{"内容不完整", []byte{0, 0, 0, 3, sftpHandle, 0, 0}, 0, sftpHandle, io.ErrUnexpectedEOF},
+		{"报文被截断", []byte{0, 0, 0, 3, sftpHandle}, 0, 0, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testSftpConn(tt.data)
+			id, typ, _, err := c.recv()
+			if id != tt.wantId || typ != tt.wantTyp || !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("recv() = %d, %d, %v, want %d, %d, %v", id, typ, err, tt.wantId, tt.wantTyp, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSftpReadPacketLength(t *testing.T) {
+	for _, data := range [][]byte{{0, 0, 0, 0, 0}, {0, 0x10, 0, 1, 0}} {
+		c, _ := testSftpConn(data)
+		if _, _, err := c.readPacket(); err == nil {
+			t.Errorf("readPacket(%v)应返回长度错误", data)
+		}
+	}
+}
+
+func TestSftpAttrs(t *testing.T) {
+	encode := func(fields ...interface{}) *sftpBuf {
+		c, buf := testSftpConn(nil)
+		c.writePacket(0, fields...)
+		data, _ := ioutil.ReadAll(buf)
+		return &sftpBuf{data: data[5:]}
+	}
+	tests := []struct {
+		name string
+		buf  *sftpBuf
+		want remoteFileInfo
+	}{
+		{"文件", encode(uint32(0x1|0x2|0x4|0x8), uint64(1234), uint32(1000), uint32(1000), uint32(0100644), uint32(1), uint32(1609459200)),
+			remoteFileInfo{name: "a", size: 1234, mode: 0644, modTime: time.Unix(1609459200, 0)}},
+		{"目录", encode(uint32(0x4), uint32(040755)), remoteFileInfo{name: "a", mode: os.ModeDir | 0755}},
+		{"符号链接", encode(uint32(0x4), uint32(0120777)), remoteFileInfo{name: "a", mode: os.ModeSymlink | 0777}},
+		{"扩展属性", encode(uint32(0x1|0x80000000), uint64(5), uint32(1), "k", "v"), remoteFileInfo{name: "a", size: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.buf.attrs("a")
+			if got.name != tt.want.name || got.size != tt.want.size || got.mode != tt.want.mode || !got.modTime.Equal(tt.want.modTime) ||
+				tt.buf.err != nil || len(tt.buf.data) != 0 {
+				t.Errorf("attrs() = %+v, err=%v, 剩余%d字节, want %+v", got, tt.buf.err, len(tt.buf.data), tt.want)
+			}
+		})
+	}
+}
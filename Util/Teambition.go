@@ -3,14 +3,13 @@ package Util
 import (
 	"PanIndex/config"
 	"PanIndex/entity"
-	"PanIndex/model"
-	"bytes"
+	"PanIndex/metrics"
 	"encoding/json"
 	"fmt"
 	"github.com/eddieivan01/nic"
 	jsoniter "github.com/json-iterator/go"
-	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
@@ -32,6 +31,7 @@ var TeambitionSessions = map[string]entity.Teambition{}
 func TeambitionLogin(accountId, user, password string) string {
 	Teambition := TeambitionSessions[accountId]
 	TeambitionSession := Teambition.TeambitionSession
+	metrics.Hook(&TeambitionSession, "teambition")
 	defer func() {
 		if p := recover(); p != nil {
 			log.Errorln(p)
@@ -107,6 +107,7 @@ func TeambitionLogin(accountId, user, password string) string {
 func TeambitionUSLogin(accountId, user, password string) string {
 	Teambition := TeambitionSessions[accountId]
 	TeambitionSession := Teambition.TeambitionSession
+	metrics.Hook(&TeambitionSession, "teambition-us")
 	defer func() {
 		if p := recover(); p != nil {
 			log.Errorln(p)
@@ -181,18 +182,17 @@ func ProjectIdCheck(server, accountId, rootId string) string {
 	return Teambition.GloablRootId
 }
 
-//获取个人文件列表
-func TeambitionGetFiles(accountId, rootId, fileId, p string) {
+//获取个人文件列表（单层）
+func TeambitionGetFiles(accountId, fileId, p string) (list []entity.FileNode, err error) {
 	Teambition := TeambitionSessions[accountId]
 	TeambitionSession := Teambition.TeambitionSession
-	if rootId == "" {
+	if fileId == "" {
 		//如果没有设置rootId,这里使用全局的rootId
-		rootId = Teambition.GloablRootId
 		fileId = Teambition.GloablRootId
 	}
 	defer func() {
-		if p := recover(); p != nil {
-			log.Warningln(p)
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
 		}
 	}()
 	limit := 100
@@ -201,7 +201,7 @@ func TeambitionGetFiles(accountId, rootId, fileId, p string) {
 		url := fmt.Sprintf("https://pan.teambition.com/pan/api/nodes?orgId=%s&from=%s&limit=%d&orderBy=updated_at&orderDirection=DESC&driveId=%s&parentId=%s", Teambition.GloablOrgId, nextMarker, limit, Teambition.GloablDriveId, fileId)
 		resp, err := TeambitionSession.Get(url, nil)
 		if err != nil {
-			panic(err.Error())
+			return list, err
 		}
 		byteFiles := []byte(resp.Text)
 		d := jsoniter.Get(byteFiles, "data")
@@ -271,24 +271,22 @@ func TeambitionGetFiles(accountId, rootId, fileId, p string) {
 			} else {
 				fn.Path = p + "/" + fn.FileName
 			}
-			if fn.IsFolder == true {
-				TeambitionGetFiles(accountId, rootId, fn.FileId, fn.Path)
-			}
-			fn.Id = uuid.NewV4().String()
-			model.SqliteDb.Create(fn)
+			list = append(list, fn)
 		}
 		if nextMarker == "" {
 			break
 		}
 	}
+	return list, nil
 }
 
-func TeambitionGetProjectFiles(server, accountId, rootId, p string) {
+//获取项目文件列表（单层）
+func TeambitionGetProjectFiles(server, accountId, rootId, p string) (list []entity.FileNode, err error) {
 	Teambition := TeambitionSessions[accountId]
 	TeambitionSession := Teambition.TeambitionSession
 	defer func() {
-		if p := recover(); p != nil {
-			log.Warningln(p)
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
 		}
 	}()
 	limit := 100
@@ -300,13 +298,13 @@ func TeambitionGetProjectFiles(server, accountId, rootId, p string) {
 		url := fmt.Sprintf("https://%s.teambition.com/api/collections?_parentId=%s&_projectId=%s&order=updatedDesc&count=%d&page=%d", server, rootId, Teambition.GloablProjectId, limit, pageNum)
 		resp, err := TeambitionSession.Get(url, nil)
 		if err != nil {
-			panic(err.Error())
+			return list, err
 		}
 		json.Unmarshal(resp.Bytes, &m)
 		url = fmt.Sprintf("https://%s.teambition.com/api/works?_parentId=%s&_projectId=%s&order=updatedDesc&count=%d&page=%d", server, rootId, Teambition.GloablProjectId, limit, pageNum)
 		resp, err = TeambitionSession.Get(url, nil)
 		if err != nil {
-			panic(err.Error())
+			return list, err
 		}
 		json.Unmarshal(resp.Bytes, &n)
 		m = append(m, n...)
@@ -373,14 +371,13 @@ func TeambitionGetProjectFiles(server, accountId, rootId, p string) {
 			} else {
 				fn.Path = p + "/" + fn.FileName
 			}
-			TeambitionGetProjectFiles(server, accountId, fn.FileId, fn.Path)
 			if fn.FileName != "" {
-				fn.Id = uuid.NewV4().String()
-				model.SqliteDb.Create(fn)
+				list = append(list, fn)
 			}
 		}
 		pageNum++
 	}
+	return list, nil
 }
 
 func GetTeambitionDownUrl(accountId, nodeId string) string {
@@ -426,140 +423,163 @@ func GetTeambitionProDownUrl(server, accountId, nodeId string) string {
 	return rs.Header.Get("Location")
 }
 
-func TeambitionUpload(accountId, parentId string, files []*multipart.FileHeader) bool {
+func TeambitionUpload(accountId, parentId, name string, size int64, r io.Reader) error {
 	Teambition := TeambitionSessions[accountId]
-	TeambitionSession := Teambition.TeambitionSession
-	for _, file := range files {
-		t1 := time.Now()
-		log.Debugf("开始上传文件：%s，大小：%d", file.Filename, file.Size)
-		fs := []nic.KV{nic.KV{
-			"driveId":     Teambition.GloablDriveId,
-			"chunkCount":  1,
-			"name":        file.Filename,
-			"ccpParentId": parentId,
-			"contentType": "",
-			"size":        file.Size,
-			"type":        "file",
-		}}
-		resp, _ := TeambitionSession.Post("https://pan.teambition.com/pan/api/nodes/file", nic.H{
-			JSON: nic.KV{
-				"orgId":         Teambition.GloablOrgId,
-				"spaceId":       Teambition.GloablSpaceId,
-				"parentId":      parentId,
-				"checkNameMode": "autoRename",
-				"infos":         fs,
-			},
-		})
-		nodeId := jsoniter.Get(resp.Bytes, 0).Get("nodeId").ToString()
-		uploadId := jsoniter.Get(resp.Bytes, 0).Get("uploadId").ToString()
-		resp, _ = TeambitionSession.Post(fmt.Sprintf("https://pan.teambition.com/pan/api/nodes/%s/uploadUrl", nodeId), nic.H{
-			JSON: nic.KV{
-				"orgId":           Teambition.GloablOrgId,
-				"driveId":         Teambition.GloablDriveId,
-				"uploadId":        uploadId,
-				"startPartNumber": 1,
-				"endPartNumber":   1,
-			},
-		})
-		fileId := jsoniter.Get(resp.Bytes, "fileId").ToString()
-		partInfoListString := jsoniter.Get(resp.Bytes, "partInfoList").ToString()
-		partInfoList := []entity.PartInfo{}
-		jsoniter.UnmarshalFromString(partInfoListString, &partInfoList)
-		log.Debugf("文件分片数：%d", len(partInfoList))
-		for _, partInfo := range partInfoList {
-			fileContent, _ := file.Open()
-			byteContent, _ := ioutil.ReadAll(fileContent)
-			client := &http.Client{}
-			req, err := http.NewRequest(http.MethodPut, partInfo.UploadUrl, bytes.NewBuffer(byteContent))
-			if err != nil {
-				log.Error("上传失败")
-				return false
-			}
-			client.Do(req)
-		}
-		resp, _ = TeambitionSession.Post("https://pan.teambition.com/pan/api/nodes/complete", nic.H{
-			JSON: nic.KV{
-				"orgId":     Teambition.GloablOrgId,
-				"driveId":   Teambition.GloablDriveId,
-				"uploadId":  uploadId,
-				"nodeId":    nodeId,
-				"ccpFileId": fileId,
-			},
-		})
-		log.Debugf("上传接口返回：%s", resp.Text)
-		log.Debugf("文件：%s，上传成功，耗时：%s", file.Filename, ShortDur(time.Now().Sub(t1)))
+	TeambitionSession := &Teambition.TeambitionSession
+	t1 := time.Now()
+	log.Debugf("开始上传文件：%s，大小：%d", name, size)
+	ps, count := SplitParts(size)
+	fs := []nic.KV{nic.KV{
+		"driveId":     Teambition.GloablDriveId,
+		"chunkCount":  count,
+		"name":        name,
+		"ccpParentId": parentId,
+		"contentType": "",
+		"size":        size,
+		"type":        "file",
+	}}
+	resp, err := TeambitionSession.Post("https://pan.teambition.com/pan/api/nodes/file", nic.H{
+		JSON: nic.KV{
+			"orgId":         Teambition.GloablOrgId,
+			"spaceId":       Teambition.GloablSpaceId,
+			"parentId":      parentId,
+			"checkNameMode": "autoRename",
+			"infos":         fs,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	nodeId := jsoniter.Get(resp.Bytes, 0).Get("nodeId").ToString()
+	uploadId := jsoniter.Get(resp.Bytes, 0).Get("uploadId").ToString()
+	resp, err = TeambitionSession.Post(fmt.Sprintf("https://pan.teambition.com/pan/api/nodes/%s/uploadUrl", nodeId), nic.H{
+		JSON: nic.KV{
+			"orgId":           Teambition.GloablOrgId,
+			"driveId":         Teambition.GloablDriveId,
+			"uploadId":        uploadId,
+			"startPartNumber": 1,
+			"endPartNumber":   count,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	fileId := jsoniter.Get(resp.Bytes, "fileId").ToString()
+	partInfoListString := jsoniter.Get(resp.Bytes, "partInfoList").ToString()
+	partInfoList := []entity.PartInfo{}
+	jsoniter.UnmarshalFromString(partInfoListString, &partInfoList)
+	log.Debugf("文件分片数：%d", len(partInfoList))
+	uploadUrls := []string{}
+	for _, partInfo := range partInfoList {
+		uploadUrls = append(uploadUrls, partInfo.UploadUrl)
+	}
+	if err = PutParts(uploadUrls, size, ps, r); err != nil {
+		return err
+	}
+	resp, err = TeambitionSession.Post("https://pan.teambition.com/pan/api/nodes/complete", nic.H{
+		JSON: nic.KV{
+			"orgId":     Teambition.GloablOrgId,
+			"driveId":   Teambition.GloablDriveId,
+			"uploadId":  uploadId,
+			"nodeId":    nodeId,
+			"ccpFileId": fileId,
+		},
+	})
+	if err != nil {
+		return err
 	}
-	return true
+	log.Debugf("上传接口返回：%s", resp.Text)
+	log.Debugf("文件：%s，上传成功，耗时：%s", name, ShortDur(time.Now().Sub(t1)))
+	return nil
 }
 
-func TeambitionProUpload(server, accountId, parentId string, files []*multipart.FileHeader) bool {
+func TeambitionProUpload(server, accountId, parentId, name string, size int64, r io.Reader) error {
 	Teambition := TeambitionSessions[accountId]
-	TeambitionSession := Teambition.TeambitionSession
+	TeambitionSession := &Teambition.TeambitionSession
 	prefix := ""
 	if server == "us" {
 		prefix = "us"
 	} else {
 		prefix = "www"
 	}
-	for _, file := range files {
-		t1 := time.Now()
-		log.Debugf("开始上传文件：%s，大小：%d", file.Filename, file.Size)
-		resp, _ := TeambitionSession.Get(fmt.Sprintf("https://%s.teambition.com/projects", prefix), nil)
-		//0.准备文件
-		fileContent, _ := file.Open()
-		byteContent, _ := ioutil.ReadAll(fileContent)
-		//1.获取jwt
-		jwt := GetCurBetweenStr(resp.Text, "strikerAuth&quot;:&quot;", "&quot;,&quot;phoneForLogin")
-		//2.上传文件
-		if server == "us" {
-			prefix = "us-"
-		} else {
-			prefix = ""
+	t1 := time.Now()
+	log.Debugf("开始上传文件：%s，大小：%d", name, size)
+	resp, err := TeambitionSession.Get(fmt.Sprintf("https://%s.teambition.com/projects", prefix), nil)
+	if err != nil {
+		return err
+	}
+	//1.获取jwt
+	jwt := GetCurBetweenStr(resp.Text, "strikerAuth&quot;:&quot;", "&quot;,&quot;phoneForLogin")
+	//2.上传文件，边读边写，避免大文件整个读入内存
+	if server == "us" {
+		prefix = "us-"
+	} else {
+		prefix = ""
+	}
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		part, err := writer.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, r)
 		}
-		resp, _ = nic.Post(fmt.Sprintf("https://%stcs.teambition.net/upload", prefix), nic.H{
-			Files: nic.KV{
-				"file": nic.File(
-					file.Filename, byteContent),
-			},
-			Headers: nic.KV{
-				"Authorization": jwt,
-			},
-		})
-		fmt.Println(resp.Text)
-		fileKey := jsoniter.Get(resp.Bytes, "fileKey").ToString()
-		fileName := jsoniter.Get(resp.Bytes, "fileName").ToString()
-		fileType := jsoniter.Get(resp.Bytes, "fileType").ToString()
-		fileSize := jsoniter.Get(resp.Bytes, "fileSize").ToInt64()
-		fileCategory := jsoniter.Get(resp.Bytes, "fileCategory").ToString()
-		//imageWidth := jsoniter.Get(resp.Bytes, "imageWidth").ToString()
-		//imageHeight := jsoniter.Get(resp.Bytes, "imageHeight").ToString()
-		//3.完成上传
-		if server == "us" {
-			prefix = "us"
-		} else {
-			prefix = "www"
+		if err == nil {
+			err = writer.Close()
 		}
-		resp, _ = TeambitionSession.Post(fmt.Sprintf("https://%s.teambition.com/api/works", prefix), nic.H{
-			JSON: nic.KV{
-				"works": []nic.KV{nic.KV{
-					"fileKey":      fileKey,
-					"fileName":     fileName,
-					"fileType":     fileType,
-					"fileSize":     fileSize,
-					"fileCategory": fileCategory,
-					/*"imageWidth":   imageWidth,
-					"imageHeight":  imageHeight,*/
-					"source":    "tcs",
-					"visible":   "members",
-					"_parentId": parentId,
-				}},
+		pw.CloseWithError(err)
+	}()
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://%stcs.teambition.net/upload", prefix), pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", jwt)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	log.Debugf("上传接口返回：%s", string(body))
+	fileKey := jsoniter.Get(body, "fileKey").ToString()
+	fileName := jsoniter.Get(body, "fileName").ToString()
+	fileType := jsoniter.Get(body, "fileType").ToString()
+	fileSize := jsoniter.Get(body, "fileSize").ToInt64()
+	fileCategory := jsoniter.Get(body, "fileCategory").ToString()
+	//imageWidth := jsoniter.Get(resp.Bytes, "imageWidth").ToString()
+	//imageHeight := jsoniter.Get(resp.Bytes, "imageHeight").ToString()
+	//3.完成上传
+	if server == "us" {
+		prefix = "us"
+	} else {
+		prefix = "www"
+	}
+	resp, err = TeambitionSession.Post(fmt.Sprintf("https://%s.teambition.com/api/works", prefix), nic.H{
+		JSON: nic.KV{
+			"works": []nic.KV{nic.KV{
+				"fileKey":      fileKey,
+				"fileName":     fileName,
+				"fileType":     fileType,
+				"fileSize":     fileSize,
+				"fileCategory": fileCategory,
+				/*"imageWidth":   imageWidth,
+				"imageHeight":  imageHeight,*/
+				"source":    "tcs",
+				"visible":   "members",
 				"_parentId": parentId,
-			},
-		})
-		log.Debugf("上传接口返回：%s", resp.Text)
-		log.Debugf("文件：%s，上传成功，耗时：%s", file.Filename, ShortDur(time.Now().Sub(t1)))
+			}},
+			"_parentId": parentId,
+		},
+	})
+	if err != nil {
+		return err
 	}
-	return true
+	log.Debugf("上传接口返回：%s", resp.Text)
+	log.Debugf("文件：%s，上传成功，耗时：%s", name, ShortDur(time.Now().Sub(t1)))
+	return nil
 }
 
 func UTCTimeFormat(timeStr string) string {
@@ -0,0 +1,397 @@
+package Util
+
+import (
+	"PanIndex/entity"
+	"PanIndex/metrics"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//账号id -> *webDavDigest，服务器要求Digest认证时保存最近一次的质询
+var WebDavDigests sync.Map
+
+//PROPFIND只请求需要的属性
+const webDavPropfindBody = `<?xml version="1.0" encoding="utf-8"?><D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/></D:prop></D:propfind>`
+
+type webDavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+//Digest认证的质询参数，nc为使用同一nonce的请求计数
+type webDavDigest struct {
+	mu        sync.Mutex
+	key       string
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	nc        int
+}
+
+//认证信息变化后需要重新质询
+func webDavKey(account entity.Account) string {
+	return strings.Join([]string{account.Api, account.User, account.Password}, "\n")
+}
+
+//路径按段编码后拼接到接口地址
+func webDavUrl(account entity.Account, p string) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.TrimSuffix(account.Api, "/") + "/" + strings.Join(parts, "/")
+}
+
+//解析WWW-Authenticate中的Digest质询，没有时返回nil
+func parseDigestChallenge(headers []string) map[string]string {
+	for _, h := range headers {
+		if len(h) < 7 || !strings.EqualFold(h[:7], "Digest ") {
+			continue
+		}
+		params := map[string]string{}
+		s := h[7:]
+		for s != "" {
+			s = strings.TrimLeft(s, " ,")
+			eq := strings.Index(s, "=")
+			if eq < 0 {
+				break
+			}
+			k := strings.ToLower(strings.TrimSpace(s[:eq]))
+			s = s[eq+1:]
+			v := ""
+			if strings.HasPrefix(s, `"`) {
+				end := strings.Index(s[1:], `"`)
+				if end < 0 {
+					end = len(s) - 1
+				}
+				v, s = s[1:end+1], s[end+1:]
+				if s != "" {
+					s = s[1:]
+				}
+			} else if end := strings.Index(s, ","); end >= 0 {
+				v, s = strings.TrimSpace(s[:end]), s[end:]
+			} else {
+				v, s = strings.TrimSpace(s), ""
+			}
+			params[k] = v
+		}
+		return params
+	}
+	return nil
+}
+
+//生成Digest认证的Authorization请求头（RFC 7616，支持MD5、SHA-256及qop=auth）
+func (d *webDavDigest) authorization(account entity.Account, method, uri string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return d.authorize(account, method, uri, hex.EncodeToString(b))
+}
+
+//使用指定的cnonce生成Authorization请求头
+func (d *webDavDigest) authorize(account entity.Account, method, uri, cnonce string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var newHash func() hash.Hash = md5.New
+	if strings.HasPrefix(strings.ToUpper(d.algorithm), "SHA-256") {
+		newHash = sha256.New
+	}
+	h := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	ha1 := h(account.User + ":" + d.realm + ":" + account.Password)
+	if strings.HasSuffix(strings.ToLower(d.algorithm), "-sess") {
+		ha1 = h(ha1 + ":" + d.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+	auth := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, account.User, d.realm, d.nonce, uri)
+	qop := ""
+	for _, q := range strings.Split(d.qop, ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		d.nc++
+		nc := fmt.Sprintf("%08x", d.nc)
+		auth += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s", response="%s"`, qop, nc, cnonce, h(ha1+":"+d.nonce+":"+nc+":"+cnonce+":"+qop+":"+ha2))
+	} else {
+		auth += fmt.Sprintf(`, response="%s"`, h(ha1+":"+d.nonce+":"+ha2))
+	}
+	if d.algorithm != "" {
+		auth += ", algorithm=" + d.algorithm
+	}
+	if d.opaque != "" {
+		auth += fmt.Sprintf(`, opaque="%s"`, d.opaque)
+	}
+	return auth
+}
+
+//设置认证请求头，服务器要求过Digest认证时使用Digest，否则使用Basic
+func webDavAuthorize(account entity.Account, req *http.Request) {
+	if account.User == "" && account.Password == "" {
+		return
+	}
+	if v, ok := WebDavDigests.Load(account.Id); ok {
+		if d := v.(*webDavDigest); d.key == webDavKey(account) {
+			req.Header.Set("Authorization", d.authorization(account, req.Method, req.URL.RequestURI()))
+			return
+		}
+	}
+	req.SetBasicAuth(account.User, account.Password)
+}
+
+//发送请求，返回401且服务器要求Digest认证时保存质询后重试一次，body为nil或可以重放的请求体
+func webDavDo(account entity.Account, method, p string, headers map[string]string, body []byte) (*http.Response, error) {
+	var resp *http.Response
+	for retry := 0; retry < 2; retry++ {
+		req, err := http.NewRequest(method, webDavUrl(account, p), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		webDavAuthorize(account, req)
+		t := time.Now()
+		if resp, err = http.DefaultClient.Do(req); err != nil {
+			return nil, err
+		}
+		metrics.UpstreamDuration.Observe(time.Since(t).Seconds(), "webdav", strconv.Itoa(resp.StatusCode))
+		if resp.StatusCode != http.StatusUnauthorized {
+			break
+		}
+		challenge := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+		if challenge == nil {
+			break
+		}
+		WebDavDigests.Store(account.Id, &webDavDigest{
+			key:       webDavKey(account),
+			realm:     challenge["realm"],
+			nonce:     challenge["nonce"],
+			opaque:    challenge["opaque"],
+			algorithm: challenge["algorithm"],
+			qop:       challenge["qop"],
+		})
+		if retry == 0 {
+			resp.Body.Close()
+		}
+	}
+	return resp, nil
+}
+
+//响应状态不是期望的状态时返回错误，并关闭响应
+func webDavCheckStatus(resp *http.Response, method, p string, expect ...int) error {
+	for _, code := range expect {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	resp.Body.Close()
+	return fmt.Errorf("webdav: %s %s >> %s", method, p, resp.Status)
+}
+
+//链接中的路径转换为相对接口地址的路径，href可能是完整地址或绝对路径
+func webDavHrefPath(account entity.Account, href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	base, _ := url.Parse(account.Api)
+	p := strings.TrimPrefix(u.Path, strings.TrimSuffix(base.Path, "/"))
+	return path.Clean("/" + p)
+}
+
+//PROPFIND读取路径（Depth为0）或目录下的文件（Depth为1）的属性
+func webDavPropfind(account entity.Account, p, depth string) (map[string]remoteFileInfo, error) {
+	resp, err := webDavDo(account, "PROPFIND", p, map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	}, []byte(webDavPropfindBody))
+	if err != nil {
+		return nil, err
+	}
+	if err = webDavCheckStatus(resp, "PROPFIND", p, http.StatusMultiStatus); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ms := webDavMultistatus{}
+	if err = xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+	infos := map[string]remoteFileInfo{}
+	for _, r := range ms.Responses {
+		rp := webDavHrefPath(account, r.Href)
+		if rp == "" {
+			continue
+		}
+		fi := remoteFileInfo{name: path.Base(rp), mode: 0644}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+			if ps.Prop.ResourceType.Collection != nil {
+				fi.mode = os.ModeDir | 0755
+			}
+			if ps.Prop.ContentLength != "" {
+				fi.size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			}
+			if ps.Prop.LastModified != "" {
+				fi.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+			}
+		}
+		infos[rp] = fi
+	}
+	return infos, nil
+}
+
+//校验根目录是否存在，同时完成Digest认证的质询
+func WebDavCheck(account entity.Account) error {
+	if account.Api == "" {
+		return errors.New("请填写WebDAV地址")
+	}
+	infos, err := webDavPropfind(account, account.RootId, "0")
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if fi.IsDir() {
+			return nil
+		}
+	}
+	return errors.New("根目录不存在或不是目录：" + account.RootId)
+}
+
+//读取目录下的文件，不包括目录本身
+func WebDavReadDir(account entity.Account, dir string) ([]os.FileInfo, error) {
+	infos, err := webDavPropfind(account, dir, "1")
+	if err != nil {
+		return nil, err
+	}
+	self := path.Clean("/" + dir)
+	if fi, ok := infos[self]; ok && !fi.IsDir() {
+		return nil, errors.New("不是目录：" + dir)
+	}
+	fileInfos := []os.FileInfo{}
+	for p, fi := range infos {
+		if p != self {
+			fileInfos = append(fileInfos, fi)
+		}
+	}
+	return fileInfos, nil
+}
+
+//从offset处开始读取文件，服务器不支持Range时跳过前面的内容
+func WebDavOpen(account entity.Account, p string, offset int64) (io.ReadCloser, error) {
+	headers := map[string]string{}
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+	}
+	resp, err := webDavDo(account, http.MethodGet, p, headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = webDavCheckStatus(resp, http.MethodGet, p, http.StatusOK, http.StatusPartialContent); err != nil {
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode == http.StatusOK {
+		if _, err = io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return resp.Body, nil
+}
+
+//上传文件到dir目录，同名文件会被覆盖
+func WebDavUpload(account entity.Account, dir, name string, size int64, r io.Reader) error {
+	if _, ok := WebDavDigests.Load(account.Id); ok {
+		//上传内容无法重放，先刷新Digest质询，避免nonce过期导致上传失败
+		if resp, err := webDavDo(account, http.MethodOptions, dir, nil, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+	p := path.Join(dir, name)
+	req, err := http.NewRequest(http.MethodPut, webDavUrl(account, p), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	webDavAuthorize(account, req)
+	t := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	metrics.UpstreamDuration.Observe(time.Since(t).Seconds(), "webdav", strconv.Itoa(resp.StatusCode))
+	if err = webDavCheckStatus(resp, http.MethodPut, p, http.StatusOK, http.StatusCreated, http.StatusNoContent); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+//创建目录，已存在时返回错误
+func WebDavMkdir(account entity.Account, dir, name string) error {
+	p := path.Join(dir, name)
+	resp, err := webDavDo(account, "MKCOL", p, nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		return errors.New("目录已存在：" + name)
+	}
+	if err = webDavCheckStatus(resp, "MKCOL", p, http.StatusCreated); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+//删除文件或目录，目录会连同其下的文件一起删除
+func WebDavDelete(account entity.Account, p string) error {
+	resp, err := webDavDo(account, http.MethodDelete, p, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err = webDavCheckStatus(resp, http.MethodDelete, p, http.StatusOK, http.StatusNoContent); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
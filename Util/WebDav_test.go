@@ -0,0 +1,135 @@
+package Util
+
+import (
+	"PanIndex/entity"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDigestChallenge(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    map[string]string
+	}{
+		{"RFC 7616示例", []string{`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`},
+			map[string]string{"realm": "http-auth@example.org", "qop": "auth, auth-int", "algorithm": "SHA-256", "nonce": "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", "opaque": "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"}},
+		{"跳过Basic质询", []string{`Basic realm="dav"`, `digest Realm="dav",nonce="abc",stale=FALSE`},
+			map[string]string{"realm": "dav", "nonce": "abc", "stale": "FALSE"}},
+		{"引号中的逗号和等号", []string{`Digest realm="a,b=c", nonce=""`}, map[string]string{"realm": "a,b=c", "nonce": ""}},
+		{"引号未闭合", []string{`Digest realm="dav`}, map[string]string{"realm": "dav"}},
+		{"只有Basic", []string{`Basic realm="dav"`}, nil},
+		{"没有质询", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseDigestChallenge(tt.headers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDigestChallenge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+//RFC 2617及RFC 7616中的示例
+func TestWebDavDigestAuthorize(t *testing.T) {
+	rfc2617 := entity.Account{User: "Mufasa", Password: "Circle Of Life"}
+	rfc7616 := entity.Account{User: "Mufasa", Password: "Circle of Life"}
+	tests := []struct {
+		name    string
+		account entity.Account
+		digest  *webDavDigest
+		cnonce  string
+		want    []string
+	}{
+		{"RFC 2617", rfc2617,
+			&webDavDigest{realm: "testrealm@host.com", nonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093", qop: "auth,auth-int", opaque: "5ccc069c403ebaf9f0171e9517f40e41"}, "0a4f113b",
+			[]string{`qop=auth, nc=00000001, cnonce="0a4f113b", response="6629fae49393a05397450978507c4ef1"`, `opaque="5ccc069c403ebaf9f0171e9517f40e41"`}},
+		{"RFC 7616 MD5", rfc7616,
+			&webDavDigest{realm: "http-auth@example.org", nonce: "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", qop: "auth, auth-int", algorithm: "MD5"}, "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			[]string{`response="8ca523f5e9506fed4657c9700eebdbec", algorithm=MD5`}},
+		{"RFC 7616 SHA-256", rfc7616,
+			&webDavDigest{realm: "http-auth@example.org", nonce: "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", qop: "auth, auth-int", algorithm: "SHA-256"}, "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			[]string{`response="753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1", algorithm=SHA-256`}},
+		{"没有qop", rfc2617,
+			&webDavDigest{realm: "testrealm@host.com", nonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093"}, "0a4f113b",
+			[]string{`uri="/dir/index.html", response="670fd8c2df070c60b045671b8b24ff02"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.digest.authorize(tt.account, "GET", "/dir/index.html", tt.cnonce)
+			for _, want := range tt.want {
+				if !strings.HasPrefix(got, `Digest username="Mufasa"`) || !strings.Contains(got, want) {
+					t.Errorf("authorize() = %s, want %s", got, want)
+				}
+			}
+		})
+	}
+	//同一nonce的请求计数递增
+	d := &webDavDigest{realm: "testrealm@host.com", nonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093", qop: "auth"}
+	d.authorize(rfc2617, "GET", "/dir/index.html", "0a4f113b")
+	if got := d.authorize(rfc2617, "GET", "/dir/index.html", "0a4f113b"); !strings.Contains(got, `nc=00000002, cnonce="0a4f113b", response="15b6bb427e3fecd23a43cb702ce447d5"`) {
+		t.Errorf("第2次authorize() = %s", got)
+	}
+}
+
+func TestWebDavPropfind(t *testing.T) {
+	const body = `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:">
+	<d:response>
+		<d:href>/remote.php/dav/files/u/dir/</d:href>
+		<d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype><d:getlastmodified>Sat, 02 Jan 2021 03:04:05 GMT</d:getlastmodified></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>
+		<d:propstat><d:prop><d:getcontentlength/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>
+	</d:response>
+	<d:response>
+		<d:href>http://example.com/remote.php/dav/files/u/dir/a%20b.txt</d:href>
+		<d:propstat><d:prop><d:resourcetype/><d:getcontentlength>12</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>
+	</d:response>
+	<d:response>
+		<d:href>/remote.php/dav/files/u/dir/%E7%9B%AE%E5%BD%95/</d:href>
+		<d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>
+		<d:propstat><d:prop><d:getcontentlength>99</d:getcontentlength></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>
+	</d:response>
+</d:multistatus>`
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+strings.SplitN(auth, " ", 2)[0])
+		//只接受Digest认证
+		if !strings.HasPrefix(auth, "Digest ") || !strings.Contains(auth, `realm="dav", nonce="n1"`) {
+			w.Header().Add("WWW-Authenticate", `Basic realm="dav"`)
+			w.Header().Add("WWW-Authenticate", `Digest realm="dav", nonce="n1", qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Depth") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	account := entity.Account{Id: "webdav-propfind-test", Api: srv.URL + "/remote.php/dav/files/u/", User: "u", Password: "p"}
+	defer WebDavDigests.Delete(account.Id)
+	infos, err := webDavPropfind(account, "/dir", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]remoteFileInfo{
+		"/dir":         {name: "dir", mode: os.ModeDir | 0755, modTime: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)},
+		"/dir/a b.txt": {name: "a b.txt", size: 12, mode: 0644},
+		"/dir/目录":      {name: "目录", mode: os.ModeDir | 0755},
+	}
+	if !reflect.DeepEqual(infos, want) {
+		t.Errorf("webDavPropfind() = %+v, want %+v", infos, want)
+	}
+	wantRequests := []string{"PROPFIND /remote.php/dav/files/u/dir Basic", "PROPFIND /remote.php/dav/files/u/dir Digest"}
+	if !reflect.DeepEqual(requests, wantRequests) {
+		t.Errorf("请求为%v, want %v", requests, wantRequests)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"PanIndex/config"
+	"PanIndex/drive"
+	"PanIndex/entity"
+	"PanIndex/metrics"
+	"PanIndex/service"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+//json接口，路径为/api/v1之后的部分
+func apiV1(c *gin.Context, p string) {
+	if p == "/accounts" {
+		apiAccounts(c)
+	} else if p == "/list" {
+		apiList(c)
+	} else if p == "/file" {
+		apiFile(c)
+	} else if p == "/search" {
+		searchApi(c)
+	} else if p == "/download-url" {
+		apiDownloadUrl(c)
+	} else if p == "/share" && c.Request.Method == http.MethodPost {
+		apiShareCreate(c)
+	} else if p == "/shares" {
+		apiShares(c)
+	} else if p == "/share/revoke" && c.Request.Method == http.MethodPost {
+		apiShareRevoke(c)
+	} else {
+		apiError(c, http.StatusNotFound, "接口不存在")
+	}
+}
+
+//携带正确token时视为管理员，否则为当前登录用户
+func apiUser(c *gin.Context) entity.User {
+	if token := c.Query("token"); token != "" && token == config.GloablConfig.ApiToken {
+		return entity.User{Name: "token", Role: "admin"}
+	}
+	return currentUser(c)
+}
+
+func apiError(c *gin.Context, code int, msg string) {
+	c.JSON(code, gin.H{"status": -1, "msg": msg})
+}
+
+//无权限时，未登录用户返回401，已登录用户返回403
+func apiForbidden(c *gin.Context, user entity.User) {
+	if user.Id == "" && user.Role != "admin" {
+		apiError(c, http.StatusUnauthorized, "请登录后访问")
+	} else {
+		apiError(c, http.StatusForbidden, "没有访问权限")
+	}
+}
+
+//目录加密时，未提供密码返回401，密码错误返回403
+func apiPwdRequired(c *gin.Context, pwd string) {
+	if pwd == "" {
+		apiError(c, http.StatusUnauthorized, "目录需要访问密码")
+	} else {
+		apiError(c, http.StatusForbidden, "访问密码错误")
+	}
+}
+
+//根据参数account（id或名称）获取账号，未指定时为第一个账号
+func apiAccount(c *gin.Context) (entity.Account, bool) {
+	accounts := config.GloablConfig.Accounts
+	if len(accounts) == 0 {
+		apiError(c, http.StatusNotFound, "未绑定任何账号")
+		return entity.Account{}, false
+	}
+	key := c.Query("account")
+	if key == "" {
+		return accounts[0], true
+	}
+	if account, ok := findAccount(key); ok {
+		return account, true
+	}
+	apiError(c, http.StatusNotFound, "账号不存在")
+	return entity.Account{}, false
+}
+
+func findAccount(key string) (entity.Account, bool) {
+	for _, account := range config.GloablConfig.Accounts {
+		if account.Id == key || account.Name == key {
+			return account, true
+		}
+	}
+	return entity.Account{}, false
+}
+
+//根据参数path查找文件（夹），并校验浏览权限及上级目录密码
+func apiFileNode(c *gin.Context, account entity.Account, user entity.User) (entity.FileNode, bool) {
+	p := path.Clean("/" + c.Query("path"))
+	if !service.HasPerm(user, account.Id, p, service.PermRead) {
+		apiForbidden(c, user)
+		return entity.FileNode{}, false
+	}
+	pwd := c.Query("pwd")
+	fileNode, found, pwdFileId := service.FindFile(account, p, pwd)
+	if pwdFileId != "" {
+		apiPwdRequired(c, pwd)
+		return entity.FileNode{}, false
+	}
+	if !found {
+		apiError(c, http.StatusNotFound, "文件不存在")
+		return entity.FileNode{}, false
+	}
+	return fileNode, true
+}
+
+func apiAccounts(c *gin.Context) {
+	user := apiUser(c)
+	list := []gin.H{}
+	for i, account := range config.GloablConfig.Accounts {
+		if !service.HasPerm(user, account.Id, "/", service.PermRead) {
+			continue
+		}
+		list = append(list, gin.H{
+			"id": account.Id, "name": account.Name, "mode": account.Mode, "index": i,
+			"default": account.Default == 1, "url": service.PageUrl(account.Id, "/"),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 0, "list": list})
+}
+
+//列出目录，参数path为文件时只返回该文件
+func apiList(c *gin.Context) {
+	account, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+	user := apiUser(c)
+	fileNode, ok := apiFileNode(c, account, user)
+	if !ok {
+		return
+	}
+	params := listParams(c)
+	list, total := []entity.FileNode{fileNode}, 1
+	if fileNode.IsFolder {
+		pwd := c.Query("pwd")
+		fs, n, _, pwdFileId := service.ListFilesPage(account, fileNode.Path, pwd, params)
+		if pwdFileId != "" {
+			apiPwdRequired(c, pwd)
+			return
+		}
+		list, total = service.FilterReadable(user, fs), n
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": 0, "accountId": account.Id, "path": fileNode.Path, "isFile": !fileNode.IsFolder,
+		"total": total, "page": params.Page, "size": params.Size, "totalPage": service.GetTotalPage(total, params.Size),
+		"list": list,
+	})
+}
+
+func apiFile(c *gin.Context) {
+	account, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+	fileNode, ok := apiFileNode(c, account, apiUser(c))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 0, "file": fileNode})
+}
+
+//获取下载地址，本地及代理下载模式返回本站地址，其他模式返回网盘直链
+//开启下载地址签名时一律返回带签名的本站地址，由下载时校验签名后再跳转到直链
+func apiDownloadUrl(c *gin.Context) {
+	account, ok := apiAccount(c)
+	if !ok {
+		return
+	}
+	fileNode, ok := apiFileNode(c, account, apiUser(c))
+	if !ok {
+		return
+	}
+	if fileNode.IsFolder {
+		apiError(c, http.StatusBadRequest, "目录不支持下载")
+		return
+	}
+	if account.Mode == "native" || drive.ProxyDownload(account) || config.GloablConfig.SignUrl == 1 {
+		downUrl := siteUrl(c) + (&url.URL{Path: service.PageUrl(account.Id, fileNode.Path)}).String() + service.SignQuery(account.Id, fileNode.Path)
+		c.JSON(http.StatusOK, gin.H{"status": 0, "url": downUrl, "proxy": true})
+		return
+	}
+	downUrl := service.GetDownlaodUrl(account, fileNode)
+	if downUrl == "" {
+		apiError(c, http.StatusBadGateway, "下载地址获取失败")
+		return
+	}
+	metrics.DownloadRedirects.Inc(account.Name)
+	auditDownload(c, "api", account, fileNode.Path, urlHost(downUrl), "")
+	c.JSON(http.StatusOK, gin.H{"status": 0, "url": downUrl, "proxy": false})
+}
+
+//创建分享的参数，account为账号id或名称，pwd为加密目录的密码，password为分享的提取码
+type shareRequest struct {
+	Account      string `json:"account"`
+	Path         string `json:"path"`
+	Pwd          string `json:"pwd"`
+	Password     string `json:"password"`
+	ExpireHours  int    `json:"expire_hours"`
+	MaxDownloads int    `json:"max_downloads"`
+}
+
+//创建分享，需要登录或携带token
+func apiShareCreate(c *gin.Context) {
+	user := apiUser(c)
+	if user.Id == "" && user.Role != "admin" {
+		apiForbidden(c, user)
+		return
+	}
+	req := shareRequest{}
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	account, ok := findAccount(req.Account)
+	if !ok {
+		apiError(c, http.StatusNotFound, "账号不存在")
+		return
+	}
+	share, msg := service.CreateShare(user, account.Id, req.Path, req.Pwd, req.Password, req.ExpireHours, req.MaxDownloads)
+	if msg != "" {
+		apiError(c, http.StatusBadRequest, msg)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 0, "share": share, "url": "/s/" + share.Token})
+}
+
+//有效的分享，管理员返回全部，其他用户返回自己创建的
+func apiShares(c *gin.Context) {
+	user := apiUser(c)
+	if user.Id == "" && user.Role != "admin" {
+		apiForbidden(c, user)
+		return
+	}
+	userId := user.Id
+	if user.Role == "admin" {
+		userId = ""
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 0, "list": service.ActiveShares(userId)})
+}
+
+func apiShareRevoke(c *gin.Context) {
+	user := apiUser(c)
+	if user.Id == "" && user.Role != "admin" {
+		apiForbidden(c, user)
+		return
+	}
+	userId := user.Id
+	if user.Role == "admin" {
+		userId = ""
+	}
+	if msg := service.RevokeShare(c.Query("id"), userId); msg != "" {
+		apiError(c, http.StatusNotFound, msg)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 0})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"PanIndex/config"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApiV1Auth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataPath := t.TempDir()
+	model.InitDb("", "", dataPath, false)
+	root := filepath.Join(dataPath, "root")
+	for _, dir := range []string{"secret", "locked"} {
+		os.MkdirAll(filepath.Join(root, dir), os.ModePerm)
+		ioutil.WriteFile(filepath.Join(root, dir, "f.txt"), []byte("hello"), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	model.SqliteDb.Create(&entity.User{Id: "viewer", Name: "viewer", Password: string(hash), Role: "viewer"})
+	old := config.GloablConfig
+	defer func() { config.GloablConfig = old }()
+	config.GloablConfig.ApiToken = "tok"
+	config.GloablConfig.HideFileId = ""
+	config.GloablConfig.PwdDirId = filepath.Join(root, "locked") + ":pw"
+	config.GloablConfig.Accounts = []entity.Account{{Id: "api-test", Name: "api", Mode: "native", RootId: root}}
+	//只有管理员可以浏览/secret
+	config.GloablConfig.Acls = []entity.Acl{{Id: "1", AccountId: "api-test", Path: "/secret"}}
+	tests := []struct {
+		name string
+		url  string
+		auth bool
+		want int
+	}{
+		{"未登录可以浏览", "/api/v1/list?path=/", false, http.StatusOK},
+		{"未登录无权限返回401", "/api/v1/list?path=/secret", false, http.StatusUnauthorized},
+		{"登录后无权限返回403", "/api/v1/list?path=/secret", true, http.StatusForbidden},
+		{"文件同样校验权限", "/api/v1/file?path=/secret/f.txt", true, http.StatusForbidden},
+		{"下载地址同样校验权限", "/api/v1/download-url?path=/secret/f.txt", false, http.StatusUnauthorized},
+		{"token视为管理员", "/api/v1/list?path=/secret&token=tok", false, http.StatusOK},
+		{"token错误时为未登录", "/api/v1/list?path=/secret&token=bad", false, http.StatusUnauthorized},
+		{"加密目录未提供密码返回401", "/api/v1/list?path=/locked", true, http.StatusUnauthorized},
+		{"加密目录密码错误返回403", "/api/v1/list?path=/locked&pwd=bad", true, http.StatusForbidden},
+		{"加密目录密码正确", "/api/v1/list?path=/locked&pwd=pw", false, http.StatusOK},
+		{"文件不存在", "/api/v1/list?path=/missing", false, http.StatusNotFound},
+		{"账号不存在", "/api/v1/list?account=missing", false, http.StatusNotFound},
+		{"接口不存在", "/api/v1/unknown", false, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.auth {
+				c.Request.SetBasicAuth("viewer", "pw")
+			}
+			apiV1(c, strings.TrimPrefix(c.Request.URL.Path, "/api/v1"))
+			result := struct{ Status int }{}
+			json.Unmarshal(w.Body.Bytes(), &result)
+			if w.Code != tt.want || (w.Code != http.StatusOK) != (result.Status == -1) {
+				t.Errorf("GET %s = %d %s, want %d", tt.url, w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
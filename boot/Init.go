@@ -38,6 +38,8 @@ func Start(host, port string, debug bool, dataPath string) {
 	//从环境变量写入到config
 	service.EnvToConfig()
 	service.GetConfig()
+	//上次运行时未完成的上传任务标记为失败，可以重新提交或过期清理
+	service.ResetUploads()
 	//定时任务初始化
 	jobs.Run()
 	//刷新cookie和目录缓存
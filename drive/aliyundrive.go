@@ -0,0 +1,63 @@
+package drive
+
+import (
+	"PanIndex/Util"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"errors"
+	"io"
+)
+
+//阿里云盘
+type AliDrive struct{}
+
+func init() {
+	Register("aliyundrive", AliDrive{})
+}
+
+//刷新令牌，新的refresh_token需要保存，否则下次无法刷新
+func (AliDrive) Login(account entity.Account) (string, error) {
+	refreshToken, err := Util.AliRefreshToken(account)
+	if err != nil {
+		return "", err
+	}
+	model.SqliteDb.Table("account").Where("id=?", account.Id).Update("refresh_token", refreshToken)
+	return refreshToken, nil
+}
+
+func (AliDrive) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	return Util.AliGetFiles(account.Id, fileId, path)
+}
+
+func (d AliDrive) Walk(account entity.Account, fileId, path string) error {
+	return Crawl(d, account, fileId, path)
+}
+
+func (AliDrive) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	downUrl := Util.AliGetDownloadUrl(account.Id, fileNode.FileId)
+	if downUrl == "" {
+		return "", errors.New("阿里云盘下载地址获取失败")
+	}
+	return downUrl, nil
+}
+
+//下载地址校验Referer，不带时返回403
+func (AliDrive) DownloadHeader(account entity.Account) map[string]string {
+	return map[string]string{"Referer": "https://www.aliyundrive.com/"}
+}
+
+func (AliDrive) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	return Util.AliUpload(account.Id, parentId, name, size, r)
+}
+
+func (AliDrive) Mkdir(account entity.Account, parentId, name string) error {
+	return Util.AliMkdir(account.Id, parentId, name)
+}
+
+func (AliDrive) Delete(account entity.Account, fileNode entity.FileNode) error {
+	return Util.AliDelete(account.Id, fileNode.FileId)
+}
+
+func (AliDrive) Capabilities() Capabilities {
+	return Capabilities{Cached: true, FolderDownload: true, Upload: true, Mkdir: true, Delete: true}
+}
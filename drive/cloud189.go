@@ -0,0 +1,51 @@
+package drive
+
+import (
+	"PanIndex/Util"
+	"PanIndex/entity"
+	"errors"
+	"io"
+)
+
+//天翼云网盘
+type Cloud189 struct{}
+
+func init() {
+	Register("cloud189", Cloud189{})
+}
+
+func (Cloud189) Login(account entity.Account) (string, error) {
+	return Util.Cloud189Login(account.Id, account.User, account.Password)
+}
+
+func (Cloud189) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	return Util.Cloud189GetFiles(account.Id, fileId, path)
+}
+
+func (d Cloud189) Walk(account entity.Account, fileId, path string) error {
+	return Crawl(d, account, fileId, path)
+}
+
+func (Cloud189) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	downUrl := Util.GetDownlaodUrl(account.Id, fileNode.FileIdDigest)
+	if downUrl == "" {
+		return "", errors.New("天翼云网盘下载地址获取失败")
+	}
+	return downUrl, nil
+}
+
+func (Cloud189) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	return Util.Cloud189UploadFile(account.Id, parentId, name, size, r)
+}
+
+func (Cloud189) Mkdir(account entity.Account, parentId, name string) error {
+	return Util.Cloud189Mkdir(account.Id, parentId, name)
+}
+
+func (Cloud189) Delete(account entity.Account, fileNode entity.FileNode) error {
+	return ErrNotSupported
+}
+
+func (Cloud189) Capabilities() Capabilities {
+	return Capabilities{Cached: true, FolderDownload: true, Upload: true, Mkdir: true}
+}
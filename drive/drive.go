@@ -0,0 +1,182 @@
+package drive
+
+import (
+	"PanIndex/entity"
+	"PanIndex/model"
+	"errors"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"sort"
+	"sync"
+)
+
+var ErrNotSupported = errors.New("当前网盘模式不支持该操作")
+
+//网盘能力声明，页面及接口根据此处判断是否展示对应功能
+type Capabilities struct {
+	Cached         bool //目录是否缓存到file_node，false表示实时读取（如本地模式）
+	FolderDownload bool //是否支持文件夹打包下载
+	Upload         bool //是否支持上传
+	Mkdir          bool //是否支持创建目录
+	Delete         bool //是否支持删除
+	ProxyOnly      bool //下载地址需要授权请求头，不能跳转直链，只能由服务端代理
+	Incremental    bool //目录内容变化时会更新各级上级目录的修改时间，可以使用增量缓存
+}
+
+//网盘接口，每种网盘模式实现一次，并通过Register注册
+type Drive interface {
+	//登录或刷新令牌，返回cookie(token)
+	Login(account entity.Account) (string, error)
+	//列出目录下的文件（单层），实时模式fileId为完整路径
+	List(account entity.Account, fileId, path string) ([]entity.FileNode, error)
+	//递归遍历目录并写入file_node（delete=1），由同步任务调用
+	Walk(account entity.Account, fileId, path string) error
+	//获取文件下载地址
+	DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error)
+	//上传单个文件到指定目录
+	Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error
+	//在指定目录下创建目录
+	Mkdir(account entity.Account, parentId, name string) error
+	//删除文件或目录
+	Delete(account entity.Account, fileNode entity.FileNode) error
+	Capabilities() Capabilities
+}
+
+//代理下载时需要附加请求头的网盘实现该接口，例如阿里云盘会校验Referer
+type DownloadHeaderer interface {
+	DownloadHeader(account entity.Account) map[string]string
+}
+
+//没有下载地址，只能通过连接读取文件内容的网盘实现该接口（如sftp、ftp），由服务端代理下载
+type Opener interface {
+	//从offset处开始读取文件
+	Open(account entity.Account, fileNode entity.FileNode, offset int64) (io.ReadCloser, error)
+}
+
+var (
+	drivesMu sync.RWMutex
+	drives   = map[string]Drive{}
+)
+
+//注册网盘模式，重复注册会覆盖
+func Register(mode string, d Drive) {
+	drivesMu.Lock()
+	defer drivesMu.Unlock()
+	drives[mode] = d
+}
+
+//根据网盘模式获取实现，未注册返回nil
+func Get(mode string) Drive {
+	drivesMu.RLock()
+	defer drivesMu.RUnlock()
+	return drives[mode]
+}
+
+//已注册的网盘模式
+func Modes() []string {
+	drivesMu.RLock()
+	defer drivesMu.RUnlock()
+	modes := []string{}
+	for mode := range drives {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	return modes
+}
+
+//能力与账号配置有关的网盘实现该接口（如webdav可以选择实时读取目录）
+type AccountCapabilities interface {
+	AccountCapabilities(account entity.Account) Capabilities
+}
+
+//获取账号对应网盘的能力，未知模式返回空能力
+func CapabilitiesOf(account entity.Account) Capabilities {
+	d := Get(account.Mode)
+	if d == nil {
+		return Capabilities{}
+	}
+	if ac, ok := d.(AccountCapabilities); ok {
+		return ac.AccountCapabilities(account)
+	}
+	return d.Capabilities()
+}
+
+//是否由服务端代理下载，账号设置为代理或网盘只能代理时为true
+func ProxyDownload(account entity.Account) bool {
+	return account.DownProxy == 1 || CapabilitiesOf(account).ProxyOnly
+}
+
+//账号选择增量缓存且网盘支持时使用增量遍历，否则全量遍历
+func IncrementalSync(account entity.Account) bool {
+	return account.SyncMode == 1 && CapabilitiesOf(account).Incremental
+}
+
+//通用的递归遍历，使用List逐层读取并写入file_node
+//子目录读取失败只记录日志，不影响其他目录
+func Crawl(d Drive, account entity.Account, fileId, path string) error {
+	list, err := d.List(account, fileId, path)
+	if err != nil {
+		return err
+	}
+	for _, fn := range list {
+		if fn.IsFolder {
+			if err := Crawl(d, account, fn.FileId, fn.Path); err != nil {
+				log.Warningf("[目录缓存][%s]%s >> %s", account.Name, fn.Path, err.Error())
+			}
+		}
+		fn.Id = uuid.NewV4().String()
+		fn.Delete = 1
+		model.SqliteDb.Create(fn)
+	}
+	return nil
+}
+
+//需要换取实际根目录ID的网盘实现该接口（如teambition项目）
+type RootResolver interface {
+	RootFileId(account entity.Account) string
+}
+
+//增量缓存统计
+type SyncStat struct {
+	Listed  int //重新读取的目录数
+	Skipped int //未变化而跳过的目录数
+}
+
+//增量遍历，目录的修改时间与缓存一致时跳过该目录，保留其下已有的缓存
+//跳过的缓存标记为delete=1，和新写入的数据一起在同步结束时生效
+//依赖网盘在目录内容变化时更新各级上级目录的修改时间，只有声明了Incremental的网盘使用
+func CrawlIncremental(d Drive, account entity.Account) (SyncStat, error) {
+	stat := SyncStat{}
+	rootId := account.RootId
+	if rr, ok := d.(RootResolver); ok {
+		rootId = rr.RootFileId(account)
+	}
+	err := crawlIncremental(d, account, rootId, "/", &stat)
+	return stat, err
+}
+
+func crawlIncremental(d Drive, account entity.Account, fileId, path string, stat *SyncStat) error {
+	list, err := d.List(account, fileId, path)
+	if err != nil {
+		return err
+	}
+	stat.Listed++
+	for _, fn := range list {
+		if fn.IsFolder {
+			old := entity.FileNode{}
+			model.SqliteDb.Raw("select * from file_node where account_id=? and file_id=? and is_folder=1 and `delete`=0 limit 1", account.Id, fn.FileId).Find(&old)
+			if old.Id != "" && old.Path == fn.Path && fn.LastOpTime != "" && old.LastOpTime == fn.LastOpTime {
+				prefix := fn.Path + "/"
+				model.SqliteDb.Exec("update file_node set `delete`=1 where account_id=? and `delete`=0 and substr(path, 1, ?)=?", account.Id, len([]rune(prefix)), prefix)
+				stat.Skipped++
+			} else if err := crawlIncremental(d, account, fn.FileId, fn.Path, stat); err != nil {
+				log.Warningf("[目录缓存][%s]%s >> %s", account.Name, fn.Path, err.Error())
+			}
+		}
+		fn.Id = uuid.NewV4().String()
+		fn.Delete = 1
+		model.SqliteDb.Create(fn)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package drive
+
+import (
+	"PanIndex/config"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+//内存中的目录树，key为目录fileId
+type memDrive struct {
+	tree   map[string][]entity.FileNode
+	errs   map[string]error //读取失败的目录
+	listed []string
+}
+
+func (d *memDrive) Login(account entity.Account) (string, error) { return "", nil }
+
+func (d *memDrive) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	d.listed = append(d.listed, path)
+	if err := d.errs[fileId]; err != nil {
+		return nil, err
+	}
+	list := []entity.FileNode{}
+	for _, fn := range d.tree[fileId] {
+		fn.AccountId = account.Id
+		fn.ParentId = fileId
+		fn.ParentPath = path
+		fn.Path = path + fn.FileName
+		if path != "/" {
+			fn.Path = path + "/" + fn.FileName
+		}
+		list = append(list, fn)
+	}
+	return list, nil
+}
+
+func (d *memDrive) Walk(account entity.Account, fileId, path string) error {
+	return Crawl(d, account, fileId, path)
+}
+
+func (d *memDrive) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	return "", nil
+}
+
+func (d *memDrive) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	return ErrNotSupported
+}
+
+func (d *memDrive) Mkdir(account entity.Account, parentId, name string) error {
+	return ErrNotSupported
+}
+
+func (d *memDrive) Delete(account entity.Account, fileNode entity.FileNode) error {
+	return ErrNotSupported
+}
+
+func (d *memDrive) Capabilities() Capabilities {
+	return Capabilities{Cached: true}
+}
+
+func dir(id, name, t string) entity.FileNode {
+	return entity.FileNode{FileId: id, FileName: name, IsFolder: true, LastOpTime: t}
+}
+
+func file(id, name string) entity.FileNode {
+	return entity.FileNode{FileId: id, FileName: name}
+}
+
+//与同步任务一样，删除旧数据并使新数据生效
+func commitSync(accountId string) {
+	model.SqliteDb.Where("account_id=? and `delete`=0", accountId).Delete(entity.FileNode{})
+	model.SqliteDb.Table("file_node").Where("account_id=?", accountId).Update("delete", 0)
+}
+
+func cachedPaths(accountId string) []string {
+	paths := []string{}
+	model.SqliteDb.Raw("select path from file_node where account_id=? and `delete`=0", accountId).Scan(&paths)
+	sort.Strings(paths)
+	return paths
+}
+
+func TestRegistry(t *testing.T) {
+	for _, mode := range []string{"native", "cloud189", "aliyundrive", "teambition"} {
+		if Get(mode) == nil {
+			t.Errorf("Get(%s) = nil", mode)
+		}
+	}
+	if Get("unknown") != nil {
+		t.Error("Get(unknown) != nil")
+	}
+	if caps := CapabilitiesOf(entity.Account{Mode: "unknown"}); caps != (Capabilities{}) {
+		t.Errorf("CapabilitiesOf(unknown) = %+v", caps)
+	}
+	d := &memDrive{}
+	Register("registry-test", d)
+	if Get("registry-test") != d || !CapabilitiesOf(entity.Account{Mode: "registry-test"}).Cached {
+		t.Error("registered drive not found")
+	}
+	modes := Modes()
+	if !sort.StringsAreSorted(modes) {
+		t.Errorf("Modes() = %v, want sorted", modes)
+	}
+	found := false
+	for _, mode := range modes {
+		found = found || mode == "registry-test"
+	}
+	if !found {
+		t.Errorf("Modes() = %v, want registry-test", modes)
+	}
+}
+
+func TestCrawl(t *testing.T) {
+	model.InitDb("", "", t.TempDir(), false)
+	account := entity.Account{Id: "crawl", RootId: "root"}
+	d := &memDrive{
+		tree: map[string][]entity.FileNode{
+			"root": {dir("a", "A", ""), dir("b", "B", ""), file("f", "f.txt")},
+			"a":    {dir("c", "C", ""), file("a1", "a1.txt")},
+			"c":    {file("c1", "c1.txt")},
+			"b":    {file("b1", "b1.txt")},
+		},
+		//子目录读取失败时保留目录本身，继续读取其他目录
+		errs: map[string]error{"b": errors.New("timeout")},
+	}
+	if err := Crawl(d, account, "root", "/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := cachedPaths(account.Id); len(got) != 0 {
+		t.Errorf("缓存在同步完成前生效：%v", got)
+	}
+	commitSync(account.Id)
+	want := []string{"/A", "/A/C", "/A/C/c1.txt", "/A/a1.txt", "/B", "/f.txt"}
+	if got := cachedPaths(account.Id); !reflect.DeepEqual(got, want) {
+		t.Errorf("缓存为%v, want %v", got, want)
+	}
+	d.errs = map[string]error{"root": errors.New("timeout")}
+	if err := Crawl(d, account, "root", "/"); err == nil {
+		t.Error("根目录读取失败时应返回错误")
+	}
+}
+
+func TestNativeList(t *testing.T) {
+	old := config.GloablConfig.HideFileId
+	defer func() { config.GloablConfig.HideFileId = old }()
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "dir"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(root, "dir", "a.txt"), []byte("abc"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "b.txt"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(root, ".hidden"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(root, "secret.txt"), nil, 0644)
+	config.GloablConfig.HideFileId = filepath.Join(root, "secret.txt")
+	account := entity.Account{Id: "native", Mode: "native", RootId: root}
+
+	list, err := Get("native").List(account, "", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, fn := range list {
+		got = append(got, fn.Path)
+	}
+	//目录在前，隐藏文件不显示
+	if !reflect.DeepEqual(got, []string{"/dir", "/b.txt"}) {
+		t.Fatalf("List = %v", got)
+	}
+	list, err = Get("native").List(account, list[0].FileId, "/dir")
+	if err != nil || len(list) != 1 || list[0].Path != "/dir/a.txt" || list[0].FileSize != 3 || list[0].ParentPath != "/dir" {
+		t.Errorf("List(/dir) = %+v, %v", list, err)
+	}
+	if _, err := Get("native").List(account, "", "/missing"); err == nil {
+		t.Error("List(/missing) = nil error")
+	}
+}
+
+func TestCrawlIncremental(t *testing.T) {
+	model.InitDb("", "", t.TempDir(), false)
+	base := func() map[string][]entity.FileNode {
+		return map[string][]entity.FileNode{
+			"root": {dir("a", "A", "t1"), dir("b", "B", "t1"), file("f", "f.txt")},
+			"a":    {file("a1", "a1.txt")},
+			"b":    {dir("c", "C", "t1"), file("b1", "b1.txt")},
+			"c":    {file("c1", "c1.txt")},
+		}
+	}
+	basePaths := []string{"/A", "/A/a1.txt", "/B", "/B/C", "/B/C/c1.txt", "/B/b1.txt", "/f.txt"}
+	tests := []struct {
+		name        string
+		change      func(tree map[string][]entity.FileNode)
+		wantListed  []string
+		wantSkipped int
+		wantPaths   []string
+	}{
+		{
+			name:        "没有变化时跳过所有子目录",
+			change:      func(tree map[string][]entity.FileNode) {},
+			wantListed:  []string{"/"},
+			wantSkipped: 2,
+			wantPaths:   basePaths,
+		},
+		{
+			name: "修改时间变化的目录重新读取，其下未变化的目录仍然跳过",
+			change: func(tree map[string][]entity.FileNode) {
+				tree["root"][1].LastOpTime = "t2"
+				tree["b"] = append(tree["b"], file("b2", "b2.txt"))
+			},
+			wantListed:  []string{"/", "/B"},
+			wantSkipped: 2,
+			wantPaths:   []string{"/A", "/A/a1.txt", "/B", "/B/C", "/B/C/c1.txt", "/B/b1.txt", "/B/b2.txt", "/f.txt"},
+		},
+		{
+			name: "没有修改时间的目录总是读取",
+			change: func(tree map[string][]entity.FileNode) {
+				tree["root"][0].LastOpTime = ""
+			},
+			wantListed:  []string{"/", "/A"},
+			wantSkipped: 1,
+			wantPaths:   basePaths,
+		},
+		{
+			name: "目录改名后重新读取",
+			change: func(tree map[string][]entity.FileNode) {
+				tree["root"][0].FileName = "A2"
+			},
+			wantListed:  []string{"/", "/A2"},
+			wantSkipped: 1,
+			wantPaths:   []string{"/A2", "/A2/a1.txt", "/B", "/B/C", "/B/C/c1.txt", "/B/b1.txt", "/f.txt"},
+		},
+		{
+			name: "删除的目录及其下的文件从缓存中移除",
+			change: func(tree map[string][]entity.FileNode) {
+				tree["root"] = tree["root"][1:]
+			},
+			wantListed:  []string{"/"},
+			wantSkipped: 1,
+			wantPaths:   []string{"/B", "/B/C", "/B/C/c1.txt", "/B/b1.txt", "/f.txt"},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			account := entity.Account{Id: "incr" + strconv.Itoa(i), RootId: "root"}
+			d := &memDrive{tree: base()}
+			stat, err := CrawlIncremental(d, account)
+			commitSync(account.Id)
+			if err != nil || stat.Skipped != 0 || len(d.listed) != 4 {
+				t.Fatalf("首次同步：stat=%+v, listed=%v, err=%v", stat, d.listed, err)
+			}
+			if got := cachedPaths(account.Id); !reflect.DeepEqual(got, basePaths) {
+				t.Fatalf("首次同步缓存为%v", got)
+			}
+			tt.change(d.tree)
+			d.listed = nil
+			stat, err = CrawlIncremental(d, account)
+			commitSync(account.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d.listed, tt.wantListed) {
+				t.Errorf("读取的目录为%v, want %v", d.listed, tt.wantListed)
+			}
+			if stat.Listed != len(tt.wantListed) || stat.Skipped != tt.wantSkipped {
+				t.Errorf("stat=%+v, want Listed=%d Skipped=%d", stat, len(tt.wantListed), tt.wantSkipped)
+			}
+			if got := cachedPaths(account.Id); !reflect.DeepEqual(got, tt.wantPaths) {
+				t.Errorf("缓存为%v, want %v", got, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestIncrementalSync(t *testing.T) {
+	Register("incr-test", &memDrive{})
+	tests := []struct {
+		name    string
+		account entity.Account
+		want    bool
+	}{
+		{"webdav选择增量", entity.Account{Mode: "webdav", SyncMode: 1}, true},
+		{"webdav选择全量", entity.Account{Mode: "webdav", SyncMode: 0}, false},
+		{"webdav实时读取", entity.Account{Mode: "webdav", SyncMode: 2}, false},
+		{"上级目录修改时间不可靠的网盘固定全量", entity.Account{Mode: "cloud189", SyncMode: 1}, false},
+		{"阿里云盘固定全量", entity.Account{Mode: "aliyundrive", SyncMode: 1}, false},
+		{"未声明增量的网盘", entity.Account{Mode: "incr-test", SyncMode: 1}, false},
+		{"未知模式", entity.Account{Mode: "unknown", SyncMode: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IncrementalSync(tt.account); got != tt.want {
+				t.Errorf("IncrementalSync(%s, %d) = %v, want %v", tt.account.Mode, tt.account.SyncMode, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package drive
+
+import (
+	"PanIndex/Util"
+	"PanIndex/entity"
+	"io"
+	"path"
+	"path/filepath"
+)
+
+//FTP及FTPS，和本地模式一样实时读取目录，fileId为服务器上的完整路径，根目录ID为服务器上的目录
+type Ftp struct{}
+
+func init() {
+	Register("ftp", Ftp{})
+}
+
+//建立连接并校验根目录，连接放入连接池复用
+func (Ftp) Login(account entity.Account) (string, error) {
+	return "", Util.FtpCheck(account)
+}
+
+func (Ftp) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	if fileId == "" {
+		fileId = filepath.Join(account.RootId, path)
+	}
+	fileInfos, err := Util.FtpReadDir(account, filepath.ToSlash(fileId))
+	if err != nil {
+		return []entity.FileNode{}, err
+	}
+	return liveFileNodes(account, fileId, path, fileInfos), nil
+}
+
+//实时读取，缓存的目录只用于搜索
+func (d Ftp) Walk(account entity.Account, fileId, path string) error {
+	return Crawl(d, account, fileId, path)
+}
+
+//没有下载地址，文件内容通过Open读取
+func (Ftp) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	return "", nil
+}
+
+func (Ftp) Open(account entity.Account, fileNode entity.FileNode, offset int64) (io.ReadCloser, error) {
+	return Util.FtpOpen(account, filepath.ToSlash(fileNode.FileId), offset)
+}
+
+func (Ftp) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	return Util.FtpUpload(account, filepath.ToSlash(parentId), path.Base(name), r)
+}
+
+func (Ftp) Mkdir(account entity.Account, parentId, name string) error {
+	return Util.FtpMkdir(account, filepath.ToSlash(parentId), path.Base(name))
+}
+
+func (Ftp) Delete(account entity.Account, fileNode entity.FileNode) error {
+	return Util.FtpDelete(account, filepath.ToSlash(fileNode.FileId), fileNode.IsFolder)
+}
+
+func (Ftp) Capabilities() Capabilities {
+	return Capabilities{FolderDownload: true, Upload: true, Mkdir: true, Delete: true, ProxyOnly: true}
+}
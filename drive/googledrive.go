@@ -0,0 +1,105 @@
+package drive
+
+import (
+	"PanIndex/Util"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"io"
+	"sync"
+)
+
+//Google Drive，支持共享云端硬盘
+type GoogleDrive struct{}
+
+func init() {
+	Register("googledrive", GoogleDrive{})
+}
+
+//同一账号同时只刷新一次令牌
+var googleDriveLoginMu sync.Mutex
+
+//刷新令牌，访问令牌保存到账号中
+func (GoogleDrive) Login(account entity.Account) (string, error) {
+	gd, err := Util.GoogleDriveRefreshToken(account)
+	if err != nil {
+		return "", err
+	}
+	model.SqliteDb.Table("account").Where("id=?", account.Id).Updates(map[string]interface{}{
+		"refresh_token": gd.RefreshToken, "access_token": gd.AccessToken,
+	})
+	return gd.RefreshToken, nil
+}
+
+//访问令牌有效期为1小时，过期前自动刷新
+func (d GoogleDrive) login(account entity.Account) error {
+	googleDriveLoginMu.Lock()
+	defer googleDriveLoginMu.Unlock()
+	if !Util.GoogleDriveExpired(account.Id) {
+		return nil
+	}
+	_, err := d.Login(account)
+	return err
+}
+
+//根目录id为空或root时，共享云端硬盘使用云端硬盘id
+func (GoogleDrive) RootFileId(account entity.Account) string {
+	return Util.GoogleDriveRootId(account)
+}
+
+func (d GoogleDrive) fileId(account entity.Account, fileId string) string {
+	if fileId == "" || fileId == account.RootId {
+		return d.RootFileId(account)
+	}
+	return fileId
+}
+
+func (d GoogleDrive) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	if err := d.login(account); err != nil {
+		return nil, err
+	}
+	return Util.GoogleDriveGetFiles(account.Id, d.fileId(account, fileId), path)
+}
+
+func (d GoogleDrive) Walk(account entity.Account, fileId, path string) error {
+	return Crawl(d, account, fileId, path)
+}
+
+//文件内容地址需要授权请求头，只能由服务端代理下载
+func (d GoogleDrive) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	if err := d.login(account); err != nil {
+		return "", err
+	}
+	return Util.GoogleDriveGetDownloadUrl(account.Id, fileNode.FileId)
+}
+
+func (d GoogleDrive) DownloadHeader(account entity.Account) map[string]string {
+	if err := d.login(account); err != nil {
+		return nil
+	}
+	return Util.GoogleDriveHeader(account.Id)
+}
+
+func (d GoogleDrive) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	if err := d.login(account); err != nil {
+		return err
+	}
+	return Util.GoogleDriveUpload(account.Id, d.fileId(account, parentId), name, size, r)
+}
+
+func (d GoogleDrive) Mkdir(account entity.Account, parentId, name string) error {
+	if err := d.login(account); err != nil {
+		return err
+	}
+	return Util.GoogleDriveMkdir(account.Id, d.fileId(account, parentId), name)
+}
+
+func (d GoogleDrive) Delete(account entity.Account, fileNode entity.FileNode) error {
+	if err := d.login(account); err != nil {
+		return err
+	}
+	return Util.GoogleDriveDelete(account.Id, fileNode.FileId)
+}
+
+func (GoogleDrive) Capabilities() Capabilities {
+	return Capabilities{Cached: true, FolderDownload: true, Upload: true, Mkdir: true, Delete: true, ProxyOnly: true}
+}
@@ -0,0 +1,132 @@
+package drive
+
+import (
+	"PanIndex/Util"
+	"PanIndex/config"
+	"PanIndex/entity"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//本地模式，实时读取服务器目录，fileId即文件的绝对路径
+type Native struct{}
+
+func init() {
+	Register("native", Native{})
+}
+
+func (Native) Login(account entity.Account) (string, error) {
+	return "", nil
+}
+
+func (Native) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	if fileId == "" {
+		fileId = filepath.Join(account.RootId, path)
+	}
+	// 读取该文件夹下所有文件
+	fileInfos, err := ioutil.ReadDir(fileId)
+	if err != nil {
+		return []entity.FileNode{}, err
+	}
+	return liveFileNodes(account, fileId, path, fileInfos), nil
+}
+
+//实时读取的目录转换为文件节点，过滤隐藏文件
+func liveFileNodes(account entity.Account, fileId, path string, fileInfos []os.FileInfo) []entity.FileNode {
+	list := []entity.FileNode{}
+	//默认按照目录，时间倒序排列
+	sort.Slice(fileInfos, func(i, j int) bool {
+		d1 := 0
+		if fileInfos[i].IsDir() {
+			d1 = 1
+		}
+		d2 := 0
+		if fileInfos[j].IsDir() {
+			d2 = 1
+		}
+		if d1 > d2 {
+			return true
+		} else if d1 == d2 {
+			return fileInfos[i].ModTime().After(fileInfos[j].ModTime())
+		} else {
+			return false
+		}
+	})
+	for _, fileInfo := range fileInfos {
+		id := filepath.Join(fileId, fileInfo.Name())
+		// 当前文件是隐藏文件(以.开头)则不显示
+		if Util.IsHiddenFile(fileInfo.Name()) {
+			continue
+		}
+		//指定隐藏的文件或目录过滤
+		if config.GloablConfig.HideFileId != "" {
+			listSTring := strings.Split(config.GloablConfig.HideFileId, ",")
+			sort.Strings(listSTring)
+			i := sort.SearchStrings(listSTring, id)
+			if i < len(listSTring) && listSTring[i] == id {
+				continue
+			}
+		}
+		list = append(list, nativeFileNode(account, id, path, fileInfo))
+	}
+	return list
+}
+
+func nativeFileNode(account entity.Account, fileId, parentPath string, fileInfo os.FileInfo) entity.FileNode {
+	fileType := Util.GetMimeType(fileInfo)
+	sizeFmt := "-"
+	if !fileInfo.IsDir() {
+		sizeFmt = Util.FormatFileSize(fileInfo.Size())
+	}
+	return entity.FileNode{
+		AccountId:  account.Id,
+		FileId:     fileId,
+		IsFolder:   fileInfo.IsDir(),
+		FileName:   fileInfo.Name(),
+		FileSize:   fileInfo.Size(),
+		SizeFmt:    sizeFmt,
+		FileType:   strings.TrimLeft(filepath.Ext(fileInfo.Name()), "."),
+		Path:       filepath.ToSlash(filepath.Join(parentPath, fileInfo.Name())),
+		ParentId:   filepath.Dir(fileId),
+		ParentPath: parentPath,
+		MediaType:  fileType,
+		LastOpTime: time.Unix(fileInfo.ModTime().Unix(), 0).Format("2006-01-02 15:04:05"),
+	}
+}
+
+//本地模式页面实时读取，缓存的目录只用于搜索
+func (d Native) Walk(account entity.Account, fileId, path string) error {
+	return Crawl(d, account, fileId, path)
+}
+
+//本地文件直接由服务端输出，没有下载地址
+func (Native) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	return "", nil
+}
+
+func (Native) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	f, err := os.Create(filepath.Join(parentId, filepath.Base(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (Native) Mkdir(account entity.Account, parentId, name string) error {
+	return os.Mkdir(filepath.Join(parentId, filepath.Base(name)), os.ModePerm)
+}
+
+func (Native) Delete(account entity.Account, fileNode entity.FileNode) error {
+	return os.RemoveAll(fileNode.FileId)
+}
+
+func (Native) Capabilities() Capabilities {
+	return Capabilities{FolderDownload: true, Upload: true, Mkdir: true, Delete: true}
+}
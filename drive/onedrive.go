@@ -0,0 +1,85 @@
+package drive
+
+import (
+	"PanIndex/Util"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"io"
+	"sync"
+)
+
+//OneDrive及SharePoint（Microsoft Graph）
+type OneDriveDrive struct{}
+
+func init() {
+	Register("onedrive", OneDriveDrive{})
+}
+
+//同一账号同时只刷新一次令牌
+var oneDriveLoginMu sync.Mutex
+
+//刷新令牌，新的刷新令牌及访问令牌保存到账号中
+func (OneDriveDrive) Login(account entity.Account) (string, error) {
+	od, err := Util.OneDriveRefreshToken(account)
+	if err != nil {
+		return "", err
+	}
+	model.SqliteDb.Table("account").Where("id=?", account.Id).Updates(map[string]interface{}{
+		"refresh_token": od.RefreshToken, "access_token": od.AccessToken,
+	})
+	return od.RefreshToken, nil
+}
+
+//访问令牌有效期为1小时左右，过期前自动刷新
+func (d OneDriveDrive) login(account entity.Account) error {
+	oneDriveLoginMu.Lock()
+	defer oneDriveLoginMu.Unlock()
+	if !Util.OneDriveExpired(account.Id) {
+		return nil
+	}
+	_, err := d.Login(account)
+	return err
+}
+
+func (d OneDriveDrive) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	if err := d.login(account); err != nil {
+		return nil, err
+	}
+	return Util.OneDriveGetFiles(account.Id, fileId, path)
+}
+
+func (d OneDriveDrive) Walk(account entity.Account, fileId, path string) error {
+	return Crawl(d, account, fileId, path)
+}
+
+func (d OneDriveDrive) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	if err := d.login(account); err != nil {
+		return "", err
+	}
+	return Util.OneDriveGetDownloadUrl(account.Id, fileNode.FileId)
+}
+
+func (d OneDriveDrive) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	if err := d.login(account); err != nil {
+		return err
+	}
+	return Util.OneDriveUpload(account.Id, parentId, name, size, r)
+}
+
+func (d OneDriveDrive) Mkdir(account entity.Account, parentId, name string) error {
+	if err := d.login(account); err != nil {
+		return err
+	}
+	return Util.OneDriveMkdir(account.Id, parentId, name)
+}
+
+func (d OneDriveDrive) Delete(account entity.Account, fileNode entity.FileNode) error {
+	if err := d.login(account); err != nil {
+		return err
+	}
+	return Util.OneDriveDelete(account.Id, fileNode.FileId)
+}
+
+func (OneDriveDrive) Capabilities() Capabilities {
+	return Capabilities{Cached: true, FolderDownload: true, Upload: true, Mkdir: true, Delete: true}
+}
@@ -0,0 +1,67 @@
+package drive
+
+import (
+	"PanIndex/Util"
+	"PanIndex/entity"
+	"io"
+)
+
+//S3兼容的对象存储（AWS S3、MinIO、Cloudflare R2等），fileId为对象key，目录以/结尾
+type S3 struct{}
+
+func init() {
+	Register("s3", S3{})
+}
+
+//使用访问密钥签名，无需登录，这里只校验存储桶是否可以访问
+func (S3) Login(account entity.Account) (string, error) {
+	return "", Util.S3Check(account)
+}
+
+//根目录ID为存储桶内的前缀
+func (S3) RootFileId(account entity.Account) string {
+	return Util.S3RootPrefix(account)
+}
+
+func (d S3) prefix(account entity.Account, fileId string) string {
+	if fileId == "" || fileId == account.RootId {
+		return d.RootFileId(account)
+	}
+	return fileId
+}
+
+func (d S3) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	list, err := Util.S3GetFiles(account, d.prefix(account, fileId), path)
+	if fileId == "" || fileId == account.RootId {
+		//根目录下的文件parent_id为根目录ID，和其他网盘一致，整个存储桶时前缀为空
+		for i := range list {
+			list[i].ParentId = account.RootId
+		}
+	}
+	return list, err
+}
+
+func (d S3) Walk(account entity.Account, fileId, path string) error {
+	return Crawl(d, account, fileId, path)
+}
+
+func (S3) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	return Util.S3GetDownloadUrl(account, fileNode.FileId)
+}
+
+func (d S3) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	return Util.S3Upload(account, d.prefix(account, parentId), name, size, r)
+}
+
+func (d S3) Mkdir(account entity.Account, parentId, name string) error {
+	return Util.S3Mkdir(account, d.prefix(account, parentId), name)
+}
+
+//目录的key以/结尾，会删除其下所有对象
+func (S3) Delete(account entity.Account, fileNode entity.FileNode) error {
+	return Util.S3Delete(account, fileNode.FileId)
+}
+
+func (S3) Capabilities() Capabilities {
+	return Capabilities{Cached: true, FolderDownload: true, Upload: true, Mkdir: true, Delete: true}
+}
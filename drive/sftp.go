@@ -0,0 +1,62 @@
+package drive
+
+import (
+	"PanIndex/Util"
+	"PanIndex/entity"
+	"io"
+	"path"
+	"path/filepath"
+)
+
+//SFTP，和本地模式一样实时读取目录，fileId为服务器上的完整路径，根目录ID为服务器上的目录
+type Sftp struct{}
+
+func init() {
+	Register("sftp", Sftp{})
+}
+
+//建立连接并校验根目录，连接放入连接池复用
+func (Sftp) Login(account entity.Account) (string, error) {
+	return "", Util.SftpCheck(account)
+}
+
+func (Sftp) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	if fileId == "" {
+		fileId = filepath.Join(account.RootId, path)
+	}
+	fileInfos, err := Util.SftpReadDir(account, filepath.ToSlash(fileId))
+	if err != nil {
+		return []entity.FileNode{}, err
+	}
+	return liveFileNodes(account, fileId, path, fileInfos), nil
+}
+
+//实时读取，缓存的目录只用于搜索
+func (d Sftp) Walk(account entity.Account, fileId, path string) error {
+	return Crawl(d, account, fileId, path)
+}
+
+//没有下载地址，文件内容通过Open读取
+func (Sftp) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	return "", nil
+}
+
+func (Sftp) Open(account entity.Account, fileNode entity.FileNode, offset int64) (io.ReadCloser, error) {
+	return Util.SftpOpen(account, filepath.ToSlash(fileNode.FileId), offset)
+}
+
+func (Sftp) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	return Util.SftpUpload(account, filepath.ToSlash(parentId), path.Base(name), r)
+}
+
+func (Sftp) Mkdir(account entity.Account, parentId, name string) error {
+	return Util.SftpMkdir(account, filepath.ToSlash(parentId), path.Base(name))
+}
+
+func (Sftp) Delete(account entity.Account, fileNode entity.FileNode) error {
+	return Util.SftpDelete(account, filepath.ToSlash(fileNode.FileId), fileNode.IsFolder)
+}
+
+func (Sftp) Capabilities() Capabilities {
+	return Capabilities{FolderDownload: true, Upload: true, Mkdir: true, Delete: true, ProxyOnly: true}
+}
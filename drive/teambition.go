@@ -0,0 +1,96 @@
+package drive
+
+import (
+	"PanIndex/Util"
+	"PanIndex/entity"
+	"errors"
+	"io"
+)
+
+//teambition盘，server为www（国内版）或us（国际版）
+type Teambition struct {
+	server string
+}
+
+func init() {
+	Register("teambition", Teambition{server: "www"})
+	Register("teambition-us", Teambition{server: "us"})
+}
+
+func (t Teambition) isProject(account entity.Account) bool {
+	return Util.TeambitionSessions[account.Id].IsPorject
+}
+
+func (t Teambition) Login(account entity.Account) (string, error) {
+	cookie := ""
+	if t.server == "us" {
+		cookie = Util.TeambitionUSLogin(account.Id, account.User, account.Password)
+	} else {
+		cookie = Util.TeambitionLogin(account.Id, account.User, account.Password)
+	}
+	Util.ProjectIdCheck(t.server, account.Id, account.RootId)
+	if cookie == "" {
+		return "", errors.New("登录失败，请检查用户名,密码是否正确")
+	}
+	return cookie, nil
+}
+
+func (t Teambition) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	if t.isProject(account) {
+		return Util.TeambitionGetProjectFiles(t.server, account.Id, fileId, path)
+	} else if t.server == "www" {
+		return Util.TeambitionGetFiles(account.Id, fileId, path)
+	}
+	//国际版暂时没有个人文件
+	return []entity.FileNode{}, nil
+}
+
+//项目文件需要先换取项目的根目录ID
+func (t Teambition) RootFileId(account entity.Account) string {
+	rootId := Util.ProjectIdCheck(t.server, account.Id, account.RootId)
+	if t.isProject(account) {
+		return rootId
+	}
+	return account.RootId
+}
+
+func (t Teambition) Walk(account entity.Account, fileId, path string) error {
+	if fileId == account.RootId {
+		fileId = t.RootFileId(account)
+	}
+	return Crawl(t, account, fileId, path)
+}
+
+func (t Teambition) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	downUrl := ""
+	if t.isProject(account) {
+		downUrl = Util.GetTeambitionProDownUrl(t.server, account.Id, fileNode.FileId)
+	} else if t.server == "www" {
+		downUrl = Util.GetTeambitionDownUrl(account.Id, fileNode.FileId)
+	}
+	if downUrl == "" {
+		return "", errors.New("Teambition盘下载地址获取失败")
+	}
+	return downUrl, nil
+}
+
+func (t Teambition) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	if t.isProject(account) {
+		return Util.TeambitionProUpload(t.server, account.Id, parentId, name, size, r)
+	} else if t.server == "www" {
+		return Util.TeambitionUpload(account.Id, parentId, name, size, r)
+	}
+	return ErrNotSupported
+}
+
+func (t Teambition) Mkdir(account entity.Account, parentId, name string) error {
+	return ErrNotSupported
+}
+
+func (t Teambition) Delete(account entity.Account, fileNode entity.FileNode) error {
+	return ErrNotSupported
+}
+
+func (t Teambition) Capabilities() Capabilities {
+	return Capabilities{Cached: true, FolderDownload: true, Upload: true}
+}
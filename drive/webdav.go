@@ -0,0 +1,101 @@
+package drive
+
+import (
+	"PanIndex/Util"
+	"PanIndex/entity"
+	"github.com/bluele/gcache"
+	"io"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+//缓存方式为实时读取（sync_mode为2）时，目录列表在内存中保留的时间
+const webDavListTTL = 30 * time.Second
+
+//实时读取的目录列表，key为账号id及目录
+var webDavLists = gcache.New(1000).LRU().Build()
+
+//WebDAV服务器（Nextcloud、Alist、坚果云等），fileId为相对接口地址的路径，根目录ID为目录路径
+//可以缓存到file_node，也可以和本地模式一样实时读取目录
+type WebDav struct{}
+
+func init() {
+	Register("webdav", WebDav{})
+}
+
+//校验根目录，服务器要求Digest认证时同时完成质询
+func (WebDav) Login(account entity.Account) (string, error) {
+	return "", Util.WebDavCheck(account)
+}
+
+func webDavLive(account entity.Account) bool {
+	return account.SyncMode == 2
+}
+
+func (WebDav) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	if fileId == "" {
+		fileId = filepath.Join(account.RootId, path)
+	}
+	key := account.Id + "\n" + fileId
+	if webDavLive(account) {
+		if v, err := webDavLists.Get(key); err == nil {
+			return append([]entity.FileNode{}, v.([]entity.FileNode)...), nil
+		}
+	}
+	fileInfos, err := Util.WebDavReadDir(account, filepath.ToSlash(fileId))
+	if err != nil {
+		return []entity.FileNode{}, err
+	}
+	list := liveFileNodes(account, fileId, path, fileInfos)
+	if webDavLive(account) {
+		webDavLists.SetWithExpire(key, append([]entity.FileNode{}, list...), webDavListTTL)
+	}
+	return list, nil
+}
+
+func (d WebDav) Walk(account entity.Account, fileId, path string) error {
+	return Crawl(d, account, fileId, path)
+}
+
+//修改后清除目录列表的缓存
+func webDavChanged(account entity.Account, fileIds ...string) {
+	for _, fileId := range fileIds {
+		webDavLists.Remove(account.Id + "\n" + fileId)
+	}
+}
+
+//下载需要认证，文件内容通过Open读取
+func (WebDav) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	return "", nil
+}
+
+func (WebDav) Open(account entity.Account, fileNode entity.FileNode, offset int64) (io.ReadCloser, error) {
+	return Util.WebDavOpen(account, filepath.ToSlash(fileNode.FileId), offset)
+}
+
+func (WebDav) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	defer webDavChanged(account, parentId)
+	return Util.WebDavUpload(account, filepath.ToSlash(parentId), path.Base(name), size, r)
+}
+
+func (WebDav) Mkdir(account entity.Account, parentId, name string) error {
+	defer webDavChanged(account, parentId)
+	return Util.WebDavMkdir(account, filepath.ToSlash(parentId), path.Base(name))
+}
+
+func (WebDav) Delete(account entity.Account, fileNode entity.FileNode) error {
+	defer webDavChanged(account, filepath.Dir(fileNode.FileId), fileNode.FileId)
+	return Util.WebDavDelete(account, filepath.ToSlash(fileNode.FileId))
+}
+
+func (WebDav) Capabilities() Capabilities {
+	return Capabilities{Cached: true, FolderDownload: true, Upload: true, Mkdir: true, Delete: true, ProxyOnly: true, Incremental: true}
+}
+
+//实时读取时不缓存到file_node
+func (d WebDav) AccountCapabilities(account entity.Account) Capabilities {
+	c := d.Capabilities()
+	c.Cached = !webDavLive(account)
+	return c
+}
@@ -48,8 +48,30 @@ type Config struct {
 	RefreshCookie     string    `json:"refresh_cookie" gorm:"default:'0 0 8 1/1 * ?'"`
 	UpdateFolderCache string    `json:"update_folder_cache"`
 	HerokuKeepAlive   string    `json:"heroku_keep_alive"`
-	FaviconUrl        string    `json:"favicon_url"` //网站图标
-	Footer            string    `json:"footer"`      //网站底部信息
+	FaviconUrl        string    `json:"favicon_url"`                       //网站图标
+	Footer            string    `json:"footer"`                            //网站底部信息
+	WebdavMode        string    `json:"webdav_mode" gorm:"default:'1'"`    //WebDAV：0关闭，1只读，2读写
+	ZipMaxFiles       int       `json:"zip_max_files" gorm:"default:1000"` //打包下载最多文件数，0不限制
+	ZipMaxSize        int64     `json:"zip_max_size" gorm:"default:4096"`  //打包下载最大总大小（MB），0不限制
+	SignUrl           int       `json:"sign_url"`                          //下载地址签名：0关闭，1开启（代替防盗链）
+	SignKey           string    `json:"sign_key"`                          //签名密钥，首次启动时随机生成
+	SignExpire        int       `json:"sign_expire" gorm:"default:24"`     //签名有效期（小时）
+	Aria2Rpc          string    `json:"aria2_rpc"`                         //aria2 JSON-RPC地址，例如http://127.0.0.1:6800/jsonrpc
+	Aria2Secret       string    `json:"aria2_secret"`                      //aria2 RPC密钥（--rpc-secret）
+	Aria2Dir          string    `json:"aria2_dir"`                         //aria2下载目录，为空时使用aria2的默认目录
+	NotifyEvents      string    `json:"notify_events"`                     //需要通知的事件，逗号分隔，为空时通知登录、刷新、缓存及上传失败
+	NotifyRetry       int       `json:"notify_retry" gorm:"default:3"`     //通知发送失败的重试次数
+	NotifyWebhook     string    `json:"notify_webhook"`                    //通用webhook地址，POST事件JSON
+	NotifyTgToken     string    `json:"notify_tg_token"`                   //Telegram机器人token
+	NotifyTgChat      string    `json:"notify_tg_chat"`                    //Telegram chat_id
+	NotifyServerChan  string    `json:"notify_server_chan"`                //Server酱SendKey
+	NotifySmtpHost    string    `json:"notify_smtp_host"`                  //SMTP服务器，host:port
+	NotifySmtpUser    string    `json:"notify_smtp_user"`                  //SMTP用户名，同时作为发件人
+	NotifySmtpPass    string    `json:"notify_smtp_pass"`                  //SMTP密码
+	NotifyMailTo      string    `json:"notify_mail_to"`                    //收件人，多个逗号分隔
+	Users             []User    `json:"-" gorm:"-"`
+	Acls              []Acl     `json:"-" gorm:"-"`
+	Shares            []Share   `json:"-" gorm:"-"`
 }
 type Account struct {
 	Id           string `json:"id"`            //网盘空间id
@@ -60,12 +82,96 @@ type Account struct {
 	RefreshToken string `json:"refresh_token"` //刷新token
 	AccessToken  string `json:"access_token"`  //授权token
 	RootId       string `json:"root_id"`       //目录id
+	DownProxy    int    `json:"down_proxy"`    //下载方式：0直链跳转，1服务端代理
+	SyncMode     int    `json:"sync_mode"`     //目录缓存方式：0全量，1增量，2实时读取（webdav）
 	Default      int    `json:"default"`       //是否默认
 	FilesCount   int    `json:"files_count"`   //文件总数
 	Status       int    `json:"status"`        //状态：-1，缓存中 1，未缓存，2缓存成功，3缓存失败
 	CookieStatus int    `json:"cookie_status"` //cookie状态：-1刷新中， 1未刷新，2正常，3失效
 	TimeSpan     string `json:"time_span"`
 	LastOpTime   string `json:"last_op_time"` //最近一次更新时间
+	ListedDirs   int    `json:"listed_dirs"`  //最近一次缓存重新读取的目录数
+	SkippedDirs  int    `json:"skipped_dirs"` //最近一次缓存跳过的目录数（增量）
+	Api          string `json:"api"`          //接口地址，为空时使用官方地址，可以指向本地的模拟服务
+	Region       string `json:"region"`       //区域，onedrive：global国际版，cn世纪互联
+	SiteId       string `json:"site_id"`      //onedrive：SharePoint站点id或“域名:/sites/站点名”；googledrive：共享云端硬盘id；为空表示个人网盘
+	Bucket       string `json:"bucket"`       //s3：存储桶名称
+	LinkExpire   int    `json:"link_expire"`  //s3：预签名下载地址有效期（秒），0表示默认1小时
+	PrivateKey   string `json:"private_key"`  //sftp：私钥（PEM格式），为空时使用密码登录，私钥有密码时密码为私钥的密码
+	HostKey      string `json:"host_key"`     //sftp：主机公钥指纹（SHA256:...），ftps：证书的SHA256指纹，为空时不校验
+}
+type User struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`     //用户名
+	Password string `json:"-"`        //bcrypt加密后的密码
+	Role     string `json:"role"`     //角色：admin管理员，uploader上传者，viewer访客
+	Disabled int    `json:"disabled"` //是否禁用
+}
+
+//访问控制规则，匹配账号下的路径前缀，路径最长的规则优先，同一路径下指定用户优先于角色
+type Acl struct {
+	Id        string `json:"id"`
+	AccountId string `json:"account_id"` //网盘空间id
+	Path      string `json:"path"`       //路径前缀，/表示整个网盘
+	UserId    string `json:"user_id"`    //指定用户，为空时按角色匹配
+	Role      string `json:"role"`       //适用角色：guest（未登录）、viewer、uploader，为空表示所有
+	Read      int    `json:"read"`       //浏览、下载
+	Upload    int    `json:"upload"`     //上传、新建目录
+	Delete    int    `json:"delete"`     //删除
+}
+
+//分片上传任务，已接收的内容保存在数据目录uploads下，全部接收后再上传到网盘
+type UploadTask struct {
+	Id         string `json:"id"`
+	AccountId  string `json:"account_id"`  //网盘空间id
+	Path       string `json:"path"`        //上传目录
+	Name       string `json:"name"`        //文件名
+	Size       int64  `json:"size"`        //文件大小
+	Uploaded   int64  `json:"uploaded"`    //已接收字节数
+	Status     int    `json:"status"`      //状态：0接收中，1上传到网盘中，2上传成功，3上传失败
+	Msg        string `json:"msg"`         //失败原因
+	UserId     string `json:"-"`           //发起上传的用户，断点续传时按用户匹配
+	UpdateTime string `json:"update_time"` //最近一次接收分片的时间
+}
+
+//分享链接，通过/s/token访问，只能浏览和下载path及其下的文件
+type Share struct {
+	Id           string `json:"id"`
+	Token        string `json:"token" gorm:"uniqueIndex"`
+	AccountId    string `json:"account_id"`            //网盘空间id
+	Path         string `json:"path"`                  //分享的文件（夹）路径
+	IsFolder     bool   `json:"is_folder"`             //是否为目录
+	Password     string `json:"-"`                     //bcrypt加密后的提取码，为空表示无需提取码
+	HasPassword  bool   `json:"has_password" gorm:"-"` //是否设置了提取码
+	ExpireTime   string `json:"expire_time"`           //过期时间，为空表示永久有效
+	MaxDownloads int    `json:"max_downloads"`         //最多下载次数，0不限制
+	Downloads    int    `json:"downloads"`             //已下载次数
+	Views        int    `json:"views"`                 //访问次数
+	UserId       string `json:"user_id"`               //创建者
+	CreateTime   string `json:"create_time"`           //创建时间
+	Revoked      int    `json:"revoked"`               //是否已撤销
+}
+
+//账号事件，记录每次登录、令牌刷新及缓存刷新的结果
+type AccountEvent struct {
+	Id         int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	AccountId  string `json:"account_id" gorm:"index"` //网盘空间id
+	Type       string `json:"type"`                    //事件类型：login登录，refresh令牌刷新，sync缓存刷新
+	Success    bool   `json:"success"`                 //是否成功
+	Msg        string `json:"msg"`                     //失败原因或结果说明
+	Duration   int64  `json:"duration"`                //耗时（毫秒）
+	FilesCount int    `json:"files_count"`             //缓存刷新后的文件数
+	CreateTime string `json:"create_time"`             //事件时间
+}
+
+//下载统计，按账号、路径及日期累计，不关联file_node的id，刷新缓存后不会丢失
+type DownloadStat struct {
+	Id        int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	AccountId string `json:"account_id" gorm:"uniqueIndex:idx_download_stat"` //网盘空间id
+	Path      string `json:"path" gorm:"uniqueIndex:idx_download_stat"`       //文件路径
+	Day       string `json:"day" gorm:"uniqueIndex:idx_download_stat"`        //日期，格式2006-01-02
+	Count     int    `json:"count"`                                           //当天下载次数
+	LastTime  string `json:"last_time"`                                       //当天最后一次下载的时间
 }
 type Damagou struct {
 	Username string `json:"username"`
@@ -80,6 +186,24 @@ type Teambition struct {
 	GloablProjectId   string
 	IsPorject         bool
 }
+
+//OneDrive登录状态，Drive为网盘接口的前缀，例如https://graph.microsoft.com/v1.0/me/drive
+type OneDrive struct {
+	AccessToken  string
+	RefreshToken string
+	Expires      time.Time
+	Drive        string
+}
+
+//Google Drive登录状态，Api、Upload为接口及上传接口的前缀，DriveId为共享云端硬盘id
+type GoogleDrive struct {
+	AccessToken  string
+	RefreshToken string
+	Expires      time.Time
+	Api          string
+	Upload       string
+	DriveId      string
+}
 type Ali struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
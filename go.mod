@@ -14,8 +14,8 @@ require (
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.8.0
 	github.com/unrolled/secure v1.0.9
-	golang.org/x/crypto v0.0.0-20191205180655-e7c4368fe9dd // indirect
-	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e // indirect
+	golang.org/x/crypto v0.0.0-20191205180655-e7c4368fe9dd
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
 	gorm.io/driver/sqlite v1.1.4
 	gorm.io/gorm v1.21.8
 )
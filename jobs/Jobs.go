@@ -3,8 +3,13 @@ package jobs
 import (
 	"PanIndex/Util"
 	"PanIndex/config"
+	"PanIndex/drive"
 	"PanIndex/entity"
+	"PanIndex/metrics"
 	"PanIndex/model"
+	"PanIndex/notify"
+	"errors"
+	"fmt"
 	"github.com/bluele/gcache"
 	"github.com/eddieivan01/nic"
 	"github.com/robfig/cron"
@@ -49,13 +54,43 @@ func Run() {
 			}
 		})
 	}
+	//本地模式每小时重建一次搜索索引，sftp、ftp及实时读取的webdav遍历远程目录代价较大，不建立索引
+	c.AddFunc("0 30 0/1 * * ?", func() {
+		for _, account := range config.GloablConfig.Accounts {
+			if account.Mode == "native" {
+				SyncOneAccount(account)
+			}
+		}
+	})
 	//阿里云盘 accesstoken过期时间为2小时，这里采取固定cron刷新的方式
 	c.AddFunc("0 0 0/1 * * ?", func() {
 		for k, v := range Util.Alis {
 			account := entity.Account{}
+			model.SqliteDb.Table("account").Where("id=?", k).Take(&account)
 			account.Id = k
 			account.RefreshToken = v.RefreshToken
-			Util.AliRefreshToken(account)
+			t := time.Now()
+			_, err := Util.AliRefreshToken(account)
+			if err != nil {
+				log.Warningln("[令牌刷新][" + k + "] >> " + err.Error())
+				model.SqliteDb.Table("account").Where("id=?", account.Id).Update("cookie_status", 3)
+			}
+			addEvent(account, "refresh", t, err, "令牌刷新成功", 0)
+		}
+		//OneDrive、Google Drive访问令牌有效期约1小时，使用中过期时也会自动刷新
+		for _, id := range append(Util.OneDriveAccountIds(), Util.GoogleDriveAccountIds()...) {
+			account := entity.Account{}
+			model.SqliteDb.Table("account").Where("id=?", id).Take(&account)
+			if account.Mode != "onedrive" && account.Mode != "googledrive" {
+				continue
+			}
+			t := time.Now()
+			_, err := drive.Get(account.Mode).Login(account)
+			if err != nil {
+				log.Warningln("[令牌刷新][" + account.Name + "] >> " + err.Error())
+				model.SqliteDb.Table("account").Where("id=?", account.Id).Update("cookie_status", 3)
+			}
+			addEvent(account, "refresh", t, err, "令牌刷新成功", 0)
 		}
 	})
 	c.Start()
@@ -64,6 +99,10 @@ func StartInit() {
 	for _, account := range config.GloablConfig.Accounts {
 		AccountLogin(account)
 		//SyncOneAccount(account)
+		if account.Mode == "native" {
+			//本地模式也需要建立搜索索引
+			go SyncOneAccount(account)
+		}
 	}
 }
 
@@ -73,73 +112,58 @@ func SyncInit(account entity.Account) {
 }
 
 func AccountLogin(account entity.Account) {
-	cookie := ""
-	msg := ""
-	model.SqliteDb.Table("account").Where("id=?", account.Id).Update("cookie_status", -1)
-	if account.Mode == "cloud189" {
-		msg = "[网盘模式][" + account.Name + "] >> 天翼云网盘"
-		cookie = Util.Cloud189Login(account.Id, account.User, account.Password)
-	} else if account.Mode == "teambition" {
-		cookie = Util.TeambitionLogin(account.Id, account.User, account.Password)
-		Util.ProjectIdCheck("www", account.Id, account.RootId)
-		if Util.TeambitionSessions[account.Id].IsPorject {
-			msg = "[" + account.Name + "] >> teambition网盘-项目"
-		} else {
-			msg = "[" + account.Name + "] >> teambition网盘-个人"
-		}
-	} else if account.Mode == "teambition-us" {
-		cookie = Util.TeambitionUSLogin(account.Id, account.User, account.Password)
-		Util.ProjectIdCheck("us", account.Id, account.RootId)
-		if Util.TeambitionSessions[account.Id].IsPorject {
-			msg = "[" + account.Name + "] >> teambition国际盘-项目"
-		}
-	} else if account.Mode == "aliyundrive" {
-		cookie = Util.AliRefreshToken(account)
-		msg = "[" + account.Name + "] >> 阿里云盘"
-		model.SqliteDb.Table("account").Where("id=?", account.Id).Update("refresh_token", cookie)
-	} else if account.Mode == "native" {
-		msg = "[" + account.Name + "] >> 本地模式"
+	t := time.Now()
+	msg := "[" + account.Name + "] >> " + account.Mode
+	d := drive.Get(account.Mode)
+	if d == nil {
+		log.Warningln(msg + " >> 不支持的网盘模式")
+		addEvent(account, "login", t, errors.New("不支持的网盘模式"), "", 0)
+		return
 	}
-	if cookie != "" {
+	model.SqliteDb.Table("account").Where("id=?", account.Id).Update("cookie_status", -1)
+	_, err := d.Login(account)
+	addEvent(account, "login", t, err, "登录成功", 0)
+	if err == nil {
 		log.Infoln(msg + " >> cookie更新 >> 登录成功")
 		model.SqliteDb.Table("account").Where("id=?", account.Id).Update("cookie_status", 2)
-	} else if cookie == "" && account.Mode != "native" {
-		log.Infoln(msg + "cookie更新 >> 登录失败，请检查用户名,密码(token)是否正确")
+	} else {
+		log.Infoln(msg + " >> cookie更新 >> " + err.Error())
 		model.SqliteDb.Table("account").Where("id=?", account.Id).Update("cookie_status", 3)
 	}
 }
 func SyncOneAccount(account entity.Account) {
 	t1 := time.Now()
 	model.SqliteDb.Table("account").Where("id=?", account.Id).Update("status", -1)
-	if account.Mode == "cloud189" {
-		Util.Cloud189GetFiles(account.Id, account.RootId, account.RootId, "")
-	} else if account.Mode == "teambition" {
-		rootId := Util.ProjectIdCheck("www", account.Id, account.RootId)
-		if Util.TeambitionSessions[account.Id].IsPorject {
-			Util.TeambitionGetProjectFiles("www", account.Id, rootId, "/")
+	stat := drive.SyncStat{}
+	var err error
+	if d := drive.Get(account.Mode); d != nil {
+		if drive.IncrementalSync(account) {
+			stat, err = drive.CrawlIncremental(d, account)
 		} else {
-			Util.TeambitionGetFiles(account.Id, account.RootId, account.RootId, "/")
+			err = d.Walk(account, account.RootId, "/")
+			//全量缓存读取了所有目录
+			var dirCount int64
+			model.SqliteDb.Model(&entity.FileNode{}).Where("account_id=? and is_folder=1 and `delete`=1", account.Id).Count(&dirCount)
+			stat.Listed = int(dirCount) + 1
 		}
-	} else if account.Mode == "teambition-us" {
-		rootId := Util.ProjectIdCheck("us", account.Id, account.RootId)
-		if Util.TeambitionSessions[account.Id].IsPorject {
-			Util.TeambitionGetProjectFiles("us", account.Id, rootId, "/")
-		} else {
+		if err != nil {
+			log.Warningln("[目录缓存][" + account.Name + "]缓存刷新 >> " + err.Error())
 		}
-	} else if account.Mode == "aliyundrive" {
-		Util.AliGetFiles(account.Id, account.RootId, account.RootId, "/")
-	} else if account.Mode == "native" {
+	} else {
+		err = errors.New("不支持的网盘模式")
 	}
 	//删除旧数据
 	model.SqliteDb.Where("account_id=? and `delete`=0", account.Id).Delete(entity.FileNode{})
 	//暴露新数据
 	model.SqliteDb.Table("file_node").Where("account_id=?", account.Id).Update("delete", 0)
+	model.RebuildSearchIndex(account.Id)
 	var fileNodeCount int64
 	model.SqliteDb.Model(&entity.FileNode{}).Where("account_id=?", account.Id).Count(&fileNodeCount)
 	status := 3
-	if int(fileNodeCount) > 0 {
+	//实时读取的账号只是建立索引，空目录也算成功
+	if int(fileNodeCount) > 0 || (err == nil && !drive.CapabilitiesOf(account).Cached) {
 		status = 2
-		log.Infoln("[目录缓存][" + account.Name + "]缓存刷新 >> 刷新成功")
+		log.Infof("[目录缓存][%s]缓存刷新 >> 刷新成功，读取目录：%d，跳过目录：%d", account.Name, stat.Listed, stat.Skipped)
 	}
 	t2 := time.Now()
 	d := t2.Sub(t1)
@@ -148,6 +172,39 @@ func SyncOneAccount(account entity.Account) {
 	//更新同步记录
 	model.SqliteDb.Table("account").Where("id=?", account.Id).Updates(map[string]interface{}{
 		"status": status, "files_count": int(fileNodeCount), "last_op_time": now.Format("2006-01-02 15:04:05"),
-		"time_span": Util.ShortDur(d),
+		"time_span": Util.ShortDur(d), "listed_dirs": stat.Listed, "skipped_dirs": stat.Skipped,
 	})
+	if err == nil && status != 2 {
+		err = errors.New("没有读取到任何文件")
+	}
+	metrics.SyncDuration.Observe(d.Seconds(), account.Name)
+	metrics.SyncNodes.Set(float64(fileNodeCount), account.Name)
+	metrics.Syncs.Inc(account.Name, metrics.Result(err == nil))
+	addEvent(account, "sync", t1, err, fmt.Sprintf("读取目录：%d，跳过目录：%d", stat.Listed, stat.Skipped), int(fileNodeCount))
+}
+
+//账号事件保留天数
+const eventKeepDays = 30
+
+//记录账号事件并发送通知，err为空时记为成功，msg为成功时的说明；同时清理过期的事件
+func addEvent(account entity.Account, kind string, start time.Time, err error, msg string, filesCount int) {
+	event := entity.AccountEvent{
+		AccountId:  account.Id,
+		Type:       kind,
+		Success:    err == nil,
+		Msg:        msg,
+		Duration:   time.Since(start).Milliseconds(),
+		FilesCount: filesCount,
+		CreateTime: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	if err != nil {
+		event.Msg = err.Error()
+	}
+	model.SqliteDb.Create(&event)
+	if kind == "login" || kind == "refresh" {
+		metrics.Logins.Inc(account.Name, account.Mode, kind, metrics.Result(event.Success))
+	}
+	notify.Send(notify.NewEvent(kind, event.Success, account.Id, account.Name, account.Mode, "", event.Msg))
+	model.SqliteDb.Where("account_id=? and create_time<?", account.Id,
+		time.Now().AddDate(0, 0, -eventKeepDays).Format("2006-01-02 15:04:05")).Delete(entity.AccountEvent{})
 }
@@ -0,0 +1,41 @@
+package jobs
+
+import (
+	"PanIndex/entity"
+	"PanIndex/model"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAddEvent(t *testing.T) {
+	model.InitDb("", "", t.TempDir(), false)
+	account := entity.Account{Id: "a1", Name: "a1", Mode: "native"}
+	old := entity.AccountEvent{AccountId: account.Id, Type: "sync", Success: true,
+		CreateTime: time.Now().AddDate(0, 0, -eventKeepDays-1).Format("2006-01-02 15:04:05")}
+	other := old
+	other.AccountId = "a2"
+	model.SqliteDb.Create(&old)
+	model.SqliteDb.Create(&other)
+
+	addEvent(account, "login", time.Now(), nil, "登录成功", 0)
+	addEvent(account, "sync", time.Now(), errors.New("没有读取到任何文件"), "读取目录：0，跳过目录：0", 0)
+
+	events := []entity.AccountEvent{}
+	model.SqliteDb.Where("account_id=?", account.Id).Order("id").Find(&events)
+	if len(events) != 2 {
+		t.Fatalf("events = %+v, want the 2 new events", events)
+	}
+	if e := events[0]; e.Type != "login" || !e.Success || e.Msg != "登录成功" {
+		t.Errorf("login event = %+v", e)
+	}
+	if e := events[1]; e.Type != "sync" || e.Success || e.Msg != "没有读取到任何文件" {
+		t.Errorf("sync event = %+v", e)
+	}
+	//只清理当前账号的过期事件
+	var n int64
+	model.SqliteDb.Model(entity.AccountEvent{}).Where("account_id=?", "a2").Count(&n)
+	if n != 1 {
+		t.Errorf("other account events = %d, want 1", n)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"PanIndex/Util"
 	"PanIndex/boot"
 	"PanIndex/config"
+	"PanIndex/drive"
 	"PanIndex/entity"
 	"PanIndex/jobs"
+	"PanIndex/metrics"
+	"PanIndex/notify"
 	"PanIndex/service"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/bluele/gcache"
@@ -15,12 +19,17 @@ import (
 	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
 	"github.com/unrolled/secure"
+	"golang.org/x/net/webdav"
 	"html/template"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -34,12 +43,14 @@ var DataPath = flag.String("data_path", "data", "数据存储目录，默认程
 var CertFile = flag.String("cert_file", "", "/path/to/test.pem")
 var KeyFile = flag.String("key_file", "", "/path/to/test.key")
 var GC = gcache.New(100).LRU().Build()
+var DavLockSystem = webdav.NewMemLS()
 
 func main() {
 	flag.Parse()
 	boot.Start(*Host, *Port, *Debug, *DataPath)
 	r := gin.New()
 	r.Use(gin.Logger())
+	r.Use(metricsHandler())
 	//	staticBox := packr.NewBox("./static")
 	r.SetHTMLTemplate(initTemplates())
 	//r.LoadHTMLGlob("templates/*	")
@@ -53,22 +64,47 @@ func main() {
 		path := c.Request.URL.Path
 		method := c.Request.Method
 		_, ad := c.GetQuery("admin")
-		if strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/api/public") {
+		if strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/api/public") && !strings.HasPrefix(path, "/api/v1/") && path != "/api/thumb" {
+			//token视为管理员，上传接口允许上传者调用，其余接口需要管理员登录
 			requestToken := c.Query("token")
-			if requestToken != config.GloablConfig.ApiToken {
+			user := currentUser(c)
+			if requestToken != config.GloablConfig.ApiToken && user.Role != "admin" &&
+				!(strings.HasPrefix(path, "/api/admin/upload") && user.Role == "uploader") {
 				message := "Invalid api token"
+				setRoute(c, "unauthorized")
 				c.String(http.StatusOK, message)
 				return
 			}
 		}
-		if path == "/api/public/downloadMultiFiles" {
+		if path == "/metrics" {
+			//Prometheus指标
+			metricsApi(c)
+		} else if path == "/dav" || strings.HasPrefix(path, "/dav/") {
+			//WebDAV
+			dav(c)
+		} else if path == "/api/public/downloadMultiFiles" {
 			//文件夹下载
 			downloadMultiFiles(c)
+		} else if path == "/api/public/exportLinks" {
+			//导出文件夹下载任务（aria2等）
+			exportLinks(c)
+		} else if path == "/api/public/search" {
+			//跨账号搜索
+			searchApi(c)
+		} else if path == "/api/thumb" {
+			//缩略图，与文件使用相同的权限、目录密码及签名校验
+			thumb(c)
+		} else if strings.HasPrefix(path, "/s/") {
+			//分享链接，不受防盗链限制
+			shareIndex(c)
+		} else if strings.HasPrefix(path, "/api/v1/") {
+			//json接口，按用户权限访问
+			apiV1(c, strings.TrimPrefix(path, "/api/v1"))
 		} else if method == http.MethodGet && path == "/api/updateFolderCache" {
 			message := ""
 			for _, account := range config.GloablConfig.Accounts {
-				if account.Mode == "native" {
-					log.Infoln("[API请求]目录缓存刷新 >> 当前为本地模式，无需刷新")
+				if !drive.CapabilitiesOf(account).Cached {
+					log.Infoln("[API请求]目录缓存刷新 >> 当前为实时读取模式，无需刷新")
 				} else {
 					go updateCaches(account)
 					log.Infoln("[API请求]目录缓存刷新 >> 请求刷新")
@@ -79,8 +115,8 @@ func main() {
 		} else if method == http.MethodGet && path == "/api/refreshCookie" {
 			message := ""
 			for _, account := range config.GloablConfig.Accounts {
-				if account.Mode == "native" {
-					log.Infoln("[API请求]cookie刷新刷新 >> 当前为本地模式，无需刷新")
+				if !drive.CapabilitiesOf(account).Cached {
+					log.Infoln("[API请求]cookie刷新刷新 >> 当前为实时读取模式，无需刷新")
 				} else {
 					go refreshCookie(account)
 					log.Infoln("[API请求]cookie刷新 >> 请求刷新")
@@ -106,7 +142,36 @@ func main() {
 			envToConfig(c)
 		} else if path == "/api/admin/upload" {
 			upload(c)
+		} else if method == http.MethodPost && path == "/api/admin/upload/init" {
+			uploadInit(c)
+		} else if method == http.MethodPut && path == "/api/admin/upload/chunk" {
+			uploadChunk(c)
+		} else if method == http.MethodPost && path == "/api/admin/upload/complete" {
+			uploadComplete(c)
+		} else if path == "/api/admin/upload/status" {
+			uploadStatus(c)
+		} else if method == http.MethodPost && path == "/api/admin/saveUser" {
+			saveUser(c)
+		} else if path == "/api/admin/deleteUser" {
+			deleteUser(c)
+		} else if method == http.MethodPost && path == "/api/admin/saveAcl" {
+			saveAcl(c)
+		} else if path == "/api/admin/deleteAcl" {
+			deleteAcl(c)
+		} else if method == http.MethodPost && path == "/api/admin/saveShare" {
+			saveShare(c)
+		} else if path == "/api/admin/revokeShare" {
+			revokeShare(c)
+		} else if path == "/api/admin/accountEvents" {
+			accountEvents(c)
+		} else if path == "/api/admin/notifyTest" {
+			notifyTest(c)
+		} else if path == "/api/admin/audit" {
+			auditLog(c)
+		} else if path == "/api/admin/downloadReport" {
+			downloadReport(c)
 		} else if ad {
+			setRoute(c, "admin")
 			admin(c)
 		} else {
 			isForbidden := true
@@ -133,14 +198,23 @@ func main() {
 			} else {
 				isForbidden = false
 			}
+			if config.GloablConfig.SignUrl == 1 {
+				//开启下载地址签名后，由签名校验代替防盗链
+				isForbidden = false
+			}
 			if isForbidden == true {
 				c.String(http.StatusForbidden, "403 Hotlink Forbidden")
 				return
 			} else {
 				k, s := c.GetQuery("search")
 				if s {
+					setRoute(c, "search")
 					search(c, k)
+				} else if view := statView(c); view != "" {
+					setRoute(c, view)
+					downloadView(c, view)
 				} else {
+					setRoute(c, "index")
 					index(c)
 				}
 			}
@@ -179,6 +253,8 @@ func initTemplates() *template.Template {
 	tmpl.Parse(data)
 	data, _ = box.FindString("pan/admin/index.html")
 	tmpl.New("pan/admin/index.html").Parse(data)
+	data, _ = box.FindString("pan/preview.html")
+	tmpl.New("pan/preview.html").Funcs(template.FuncMap{"unescaped": unescaped}).Parse(data)
 	for _, theme := range themes {
 		tmpName := strings.Join([]string{"pan/", "/index.html"}, theme)
 		tmpFile := strings.ReplaceAll(tmpName, "-dark", "")
@@ -188,7 +264,7 @@ func initTemplates() *template.Template {
 			s, _ := ioutil.ReadFile("./templates/" + tmpFile)
 			data = string(s)
 		}
-		tmpl.New(tmpName).Funcs(template.FuncMap{"unescaped": unescaped}).Parse(data)
+		tmpl.New(tmpName).Funcs(template.FuncMap{"unescaped": unescaped, "sign": service.SignQuery}).Parse(data)
 	}
 	return tmpl
 }
@@ -203,15 +279,7 @@ func initStaticBox(r *gin.Engine) {
 
 func index(c *gin.Context) {
 	tmpFile := strings.Join([]string{"pan/", "/index.html"}, config.GloablConfig.Theme)
-	pwd := ""
-	pwdCookie, err := c.Request.Cookie("dir_pwd")
-	if err == nil {
-		decodePwd, err := url.QueryUnescape(pwdCookie.Value)
-		if err != nil {
-			log.Warningln(err)
-		}
-		pwd = decodePwd
-	}
+	pwd := dirPwd(c)
 	pathName := c.Request.URL.Path
 	if pathName != "/" && pathName[len(pathName)-1:] == "/" {
 		pathName = pathName[0 : len(pathName)-1]
@@ -232,7 +300,16 @@ func index(c *gin.Context) {
 		return
 	}
 	account := config.GloablConfig.Accounts[index]
-	result := service.GetFilesByPath(account, pathName, pwd)
+	setAccount(c, account)
+	user := currentUser(c)
+	if !service.HasPerm(user, account.Id, pathName, service.PermRead) {
+		forbidden(c, user)
+		return
+	}
+	result := service.GetFilesByPath(account, pathName, pwd, listParams(c))
+	if fs, ok := result["List"].([]entity.FileNode); ok {
+		result["List"] = service.FilterReadable(user, fs)
+	}
 	result["HerokuappUrl"] = config.GloablConfig.HerokuAppUrl
 	result["Mode"] = account.Mode
 	result["PrePaths"] = Util.GetPrePath(result["Path"].(string))
@@ -243,21 +320,259 @@ func index(c *gin.Context) {
 	result["Footer"] = config.GloablConfig.Footer
 	result["Theme"] = config.GloablConfig.Theme
 	result["FaviconUrl"] = config.GloablConfig.FaviconUrl
+	//导出文件夹下载任务，管理员配置aria2 RPC后可以直接提交
+	result["FolderExport"] = true
+	result["Aria2Rpc"] = config.GloablConfig.Aria2Rpc != "" && user.Role == "admin"
+	pager(c, result)
 	fs, ok := result["List"].([]entity.FileNode)
 	if ok {
 		if len(fs) == 1 && !fs[0].IsFolder && result["isFile"].(bool) {
 			//文件
-			if account.Mode == "native" {
-				c.Writer.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fs[0].FileName))
-				c.Writer.Header().Add("Content-Type", "application/octet-stream")
-				c.File(fs[0].FileId)
+			if !checkSign(c, account.Id, fs[0].Path) {
 				return
+			}
+			if mode, ok := c.GetQuery("preview"); ok {
+				siblings, _, _ := service.ListFiles(account, service.PetParentPath(fs[0].Path), pwd)
+				urlOf := func(p string) string {
+					return DIndex + p + service.SignQuery(account.Id, p)
+				}
+				preview(c, account, fs[0], fs[0].Path, mode, service.FilterReadable(user, siblings), urlOf, result)
 			} else {
-				downUrl := service.GetDownlaodUrl(account, fs[0])
-				c.Redirect(http.StatusFound, downUrl)
+				serveFile(c, account, fs[0])
 			}
+			return
+		}
+	}
+	c.HTML(http.StatusOK, tmpFile, result)
+}
+
+//开启下载地址签名时校验sign、exp参数，无效或过期时返回403
+func checkSign(c *gin.Context, accountId, p string) bool {
+	if service.CheckSign(accountId, p, c.Query("sign"), c.Query("exp")) {
+		return true
+	}
+	c.String(http.StatusForbidden, "403 Invalid Signature")
+	return false
+}
+
+//下载文件，本地模式直接输出，其他模式代理下载或跳转到直链
+func serveFile(c *gin.Context, account entity.Account, fileNode entity.FileNode) {
+	setRoute(c, "download")
+	if account.Mode == "native" {
+		auditDownload(c, "download", account, fileNode.Path, "", "")
+		c.Writer.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileNode.FileName))
+		c.Writer.Header().Add("Content-Type", "application/octet-stream")
+		c.File(fileNode.FileId)
+	} else if drive.ProxyDownload(account) {
+		proxyDownload(c, "download", account, fileNode)
+	} else {
+		downUrl := service.GetDownlaodUrl(account, fileNode)
+		metrics.DownloadRedirects.Inc(account.Name)
+		auditDownload(c, "download", account, fileNode.Path, urlHost(downUrl), "")
+		c.Redirect(http.StatusFound, downUrl)
+	}
+}
+
+//文件预览，mode为空时显示预览页面，为raw时在页面中打开pdf，为vtt时将字幕转换为WebVTT
+//pagePath为文件在页面中的路径，siblings为同一目录下的文件（页面路径），用于字幕、播放列表及图片浏览
+func preview(c *gin.Context, account entity.Account, fileNode entity.FileNode, pagePath, mode string, siblings []entity.FileNode, urlOf func(string) string, result map[string]interface{}) {
+	setRoute(c, "preview")
+	kind := service.PreviewKind(fileNode)
+	if mode == "raw" && kind == service.PreviewPdf {
+		//只允许pdf以inline方式输出，避免html等文件在本站执行
+		rc, err := service.OpenFileContent(c.Request.Context(), account, fileNode)
+		if err != nil {
+			c.String(http.StatusBadGateway, err.Error())
+			return
 		}
+		defer rc.Close()
+		c.DataFromReader(http.StatusOK, -1, "application/pdf", rc, map[string]string{
+			"Content-Disposition": mime.FormatMediaType("inline", map[string]string{"filename": fileNode.FileName}),
+		})
+		return
+	} else if mode == "vtt" {
+		rc, err := service.OpenFileContent(c.Request.Context(), account, fileNode)
+		if err != nil {
+			c.String(http.StatusBadGateway, err.Error())
+			return
+		}
+		defer rc.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(rc, service.PreviewTextLimit))
+		if err != nil {
+			c.String(http.StatusBadGateway, err.Error())
+			return
+		}
+		c.Data(http.StatusOK, "text/vtt; charset=utf-8", service.ToVtt(fileNode.FileName, data))
+		return
+	} else if mode == "thumb" {
+		serveThumb(c, account, fileNode, c.Query("size"))
+		return
+	} else if mode != "" || kind == "" {
+		//不支持预览的文件直接下载
+		serveFile(c, account, fileNode)
+		return
 	}
+	fileUrl := urlOf(pagePath)
+	withQuery := func(u, q string) string {
+		if strings.Contains(u, "?") {
+			return u + "&" + q
+		}
+		return u + "?" + q
+	}
+	result["File"] = fileNode
+	result["Kind"] = kind
+	result["FileUrl"] = fileUrl
+	result["RawUrl"] = withQuery(fileUrl, "preview=raw")
+	result["ParentPath"] = service.PetParentPath(pagePath)
+	switch kind {
+	case service.PreviewVideo:
+		subtitles := []map[string]string{}
+		for _, s := range service.Subtitles(fileNode, siblings) {
+			subtitles = append(subtitles, map[string]string{"Label": s.Label, "Url": withQuery(urlOf(s.Path), "preview=vtt")})
+		}
+		result["Subtitles"] = subtitles
+		result["Poster"] = withQuery(fileUrl, "preview=thumb&size=large")
+	case service.PreviewAudio, service.PreviewImage:
+		//同目录下同类型的文件作为播放列表
+		playlist := []map[string]interface{}{}
+		for _, fn := range siblings {
+			if !fn.IsFolder && service.PreviewKind(fn) == kind {
+				playlist = append(playlist, map[string]interface{}{
+					"Name": fn.FileName, "Url": urlOf(fn.Path), "Thumb": withQuery(urlOf(fn.Path), "preview=thumb"), "Active": fn.Path == pagePath,
+				})
+			}
+		}
+		result["Playlist"] = playlist
+	case service.PreviewText, service.PreviewMarkdown:
+		content, truncated, err := service.ReadPreviewText(c.Request.Context(), account, fileNode)
+		if err != nil {
+			log.Warningf("[文件预览][%s]%s >> %s", account.Name, fileNode.Path, err.Error())
+		}
+		result["Content"] = content
+		result["Truncated"] = truncated
+		result["Lang"] = service.PreviewLang(fileNode)
+	}
+	c.HTML(http.StatusOK, "pan/preview.html", result)
+}
+
+//输出缩略图，没有缩略图时返回404
+func serveThumb(c *gin.Context, account entity.Account, fileNode entity.FileNode, size string) {
+	file, err := service.Thumbnail(c.Request.Context(), account, fileNode, size)
+	if err != nil {
+		if err != service.ErrNoThumb {
+			log.Warningf("[缩略图][%s]%s >> %s", account.Name, fileNode.Path, err.Error())
+		}
+		c.String(http.StatusNotFound, service.ErrNoThumb.Error())
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.File(file)
+}
+
+//缩略图接口，参数account为账号ID或名称，path为文件路径，size为small、medium、large
+func thumb(c *gin.Context) {
+	account, ok := findAccount(c.Query("account"))
+	if !ok {
+		c.String(http.StatusNotFound, "指定的账号不存在")
+		return
+	}
+	p := path.Clean("/" + c.Query("path"))
+	user := currentUser(c)
+	if !service.HasPerm(user, account.Id, p, service.PermRead) {
+		forbidden(c, user)
+		return
+	}
+	if !checkSign(c, account.Id, p) {
+		return
+	}
+	pwd := c.Query("pwd")
+	if pwd == "" {
+		pwd = dirPwd(c)
+	}
+	fileNode, found, pwdFileId := service.FindFile(account, p, pwd)
+	if pwdFileId != "" {
+		c.String(http.StatusUnauthorized, "目录密码错误")
+		return
+	}
+	if !found {
+		c.String(http.StatusNotFound, service.ErrNoThumb.Error())
+		return
+	}
+	serveThumb(c, account, fileNode, c.Query("size"))
+}
+
+//分享页面，路径为/s/token/相对路径，提取码通过参数pwd或页面输入（cookie）提供
+func shareIndex(c *gin.Context) {
+	token, rel := strings.TrimPrefix(c.Request.URL.Path, "/s/"), "/"
+	if i := strings.Index(token, "/"); i >= 0 {
+		token, rel = token[:i], token[i:]
+	}
+	share, err := service.GetShare(token)
+	if err == nil && !share.IsFolder && rel != "/" {
+		err = service.ErrShareNotFound
+	}
+	if err == service.ErrShareNotFound {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		c.String(http.StatusGone, err.Error())
+		return
+	}
+	tmpFile := strings.Join([]string{"pan/", "/index.html"}, config.GloablConfig.Theme)
+	account := service.GetAccount(share.AccountId)
+	setAccount(c, account)
+	pwd := c.Query("pwd")
+	if pwd == "" {
+		pwd = dirPwd(c)
+	}
+	var result map[string]interface{}
+	if !service.CheckSharePassword(share, pwd) {
+		result = map[string]interface{}{"HasPwd": true, "FileId": share.Id, "Path": "/", "List": []entity.FileNode{}}
+	} else {
+		account, result = service.ShareFiles(share, rel, listParams(c))
+		if fileNode, ok := result["File"].(entity.FileNode); ok {
+			mode, isPreview := c.GetQuery("preview")
+			//预览页面、字幕、缩略图及断点续传的后续请求不计入下载次数
+			if r := c.GetHeader("Range"); c.Request.Method == http.MethodGet && !(isPreview && (mode == "" || mode == "vtt" || mode == "thumb")) &&
+				(r == "" || strings.HasPrefix(r, "bytes=0-")) && !service.ShareDownloaded(share) {
+				c.String(http.StatusGone, service.ErrShareExhausted.Error())
+				return
+			}
+			if isPreview {
+				_, parent := service.ShareFiles(share, service.PetParentPath(rel), service.ListParams{})
+				siblings, _ := parent["List"].([]entity.FileNode)
+				urlOf := func(p string) string {
+					return "/s/" + share.Token + p
+				}
+				result["HerokuappUrl"] = config.GloablConfig.HerokuAppUrl
+				result["Mode"] = account.Mode
+				result["PrePaths"] = Util.GetPrePath(rel)
+				result["Title"] = service.ZipName(account, share.Path)
+				result["DIndex"] = "/s/" + share.Token
+				result["Footer"] = config.GloablConfig.Footer
+				result["Theme"] = config.GloablConfig.Theme
+				result["FaviconUrl"] = config.GloablConfig.FaviconUrl
+				preview(c, account, fileNode, rel, mode, siblings, urlOf, result)
+			} else {
+				serveFile(c, account, fileNode)
+			}
+			return
+		}
+		if rel == "/" {
+			service.ShareViewed(share)
+		}
+	}
+	result["HerokuappUrl"] = config.GloablConfig.HerokuAppUrl
+	result["Mode"] = account.Mode
+	result["PrePaths"] = Util.GetPrePath(result["Path"].(string))
+	result["Title"] = service.ZipName(account, share.Path)
+	result["Accounts"] = []entity.Account{account}
+	result["DIndex"] = "/s/" + share.Token
+	result["AccountId"] = account.Id
+	result["Footer"] = config.GloablConfig.Footer
+	result["Theme"] = config.GloablConfig.Theme
+	result["FaviconUrl"] = config.GloablConfig.FaviconUrl
+	pager(c, result)
 	c.HTML(http.StatusOK, tmpFile, result)
 }
 
@@ -283,7 +598,10 @@ func search(c *gin.Context, key string) {
 		return
 	}
 	account := config.GloablConfig.Accounts[index]
-	result := service.SearchFilesByKey(account, key)
+	params := searchParams(c)
+	params.Key = key
+	params.User = currentUser(c)
+	result := service.SearchFilesByKey(account, params)
 	result["HerokuappUrl"] = config.GloablConfig.HerokuAppUrl
 	result["Mode"] = account.Mode
 	result["PrePaths"] = Util.GetPrePath(result["Path"].(string))
@@ -295,32 +613,302 @@ func search(c *gin.Context, key string) {
 	result["Theme"] = config.GloablConfig.Theme
 	result["FaviconUrl"] = config.GloablConfig.FaviconUrl
 	result["SearchKey"] = key
+	result["PageNo"] = params.Page
+	result["TotalPage"] = service.GetTotalPage(int(result["Total"].(int64)), params.Size)
+	//搜索结果按时间排序，不显示排序按钮
+	result["Sort"] = ""
+	result["Order"] = ""
+	pager(c, result)
+	c.HTML(http.StatusOK, tmpFile, result)
+}
+
+//热门（?popular）或最近下载（?recent）视图，days为统计天数，默认30天
+func statView(c *gin.Context) string {
+	if _, ok := c.GetQuery("popular"); ok {
+		return "popular"
+	}
+	if _, ok := c.GetQuery("recent"); ok {
+		return "recent"
+	}
+	return ""
+}
+
+func downloadView(c *gin.Context, view string) {
+	tmpFile := strings.Join([]string{"pan/", "/index.html"}, config.GloablConfig.Theme)
+	pathName := c.Request.URL.Path
+	index := 0
+	DIndex := ""
+	if strings.HasPrefix(pathName, "/d_") {
+		iStr := Util.GetBetweenStr(pathName, "_", "/")
+		index, _ = strconv.Atoi(iStr)
+		DIndex = fmt.Sprintf("/d_%d", index)
+	}
+	if len(config.GloablConfig.Accounts) == 0 {
+		//未绑定任何账号，跳转到后台进行配置
+		c.Redirect(http.StatusFound, "/?admin")
+		return
+	}
+	account := config.GloablConfig.Accounts[index]
+	setAccount(c, account)
+	days, _ := strconv.Atoi(c.Query("days"))
+	var stats []service.FileStat
+	if view == "popular" {
+		stats = service.PopularFiles(currentUser(c), account.Id, days, 50)
+	} else {
+		stats = service.RecentDownloads(currentUser(c), account.Id, days, 50)
+	}
+	list := []entity.FileNode{}
+	labels := map[string]string{}
+	for _, fs := range stats {
+		list = append(list, fs.FileNode)
+		labels[fs.Path] = fmt.Sprintf("下载%d次 / %s", fs.Downloads, fs.LastTime)
+	}
+	result := map[string]interface{}{}
+	result["List"] = list
+	result["StatView"] = view
+	result["StatLabels"] = labels
+	result["Path"] = "/"
+	result["HasParent"] = false
+	result["ParentPath"] = ""
+	result["SurportFolderDown"] = drive.CapabilitiesOf(account).FolderDownload
+	result["HerokuappUrl"] = config.GloablConfig.HerokuAppUrl
+	result["Mode"] = account.Mode
+	result["PrePaths"] = Util.GetPrePath("/")
+	result["Title"] = account.Name
+	result["Accounts"] = config.GloablConfig.Accounts
+	result["DIndex"] = DIndex
+	result["AccountId"] = account.Id
+	result["Footer"] = config.GloablConfig.Footer
+	result["Theme"] = config.GloablConfig.Theme
+	result["FaviconUrl"] = config.GloablConfig.FaviconUrl
+	//按下载次数或时间排序，不显示排序按钮及分页
+	result["Sort"] = ""
+	result["Order"] = ""
+	pager(c, result)
 	c.HTML(http.StatusOK, tmpFile, result)
 }
 
+//目录分页及排序：page、size（默认100）、sort（name、size、time、type）、order（asc、desc）
+func listParams(c *gin.Context) service.ListParams {
+	params := service.ListParams{Sort: c.Query("sort"), Order: c.Query("order")}
+	params.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	params.Size, _ = strconv.Atoi(c.DefaultQuery("size", "100"))
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.Size < 1 || params.Size > 1000 {
+		params.Size = 100
+	}
+	return params
+}
+
+//分页链接，保留当前的查询参数；当前页前后各显示2页，其余以省略号代替（PageNo为0）
+func pager(c *gin.Context, result map[string]interface{}) {
+	pageNo, _ := result["PageNo"].(int)
+	totalPage, _ := result["TotalPage"].(int)
+	pageUrl := func(n int) string {
+		query := c.Request.URL.Query()
+		query.Set("page", strconv.Itoa(n))
+		return "?" + query.Encode()
+	}
+	pages := []map[string]interface{}{}
+	for i := 1; i <= totalPage; i++ {
+		if i == 1 || i == totalPage || (i >= pageNo-2 && i <= pageNo+2) {
+			pages = append(pages, map[string]interface{}{"PageNo": i, "PageUrl": pageUrl(i), "Active": i == pageNo})
+		} else if pages[len(pages)-1]["PageNo"] != 0 {
+			pages = append(pages, map[string]interface{}{"PageNo": 0})
+		}
+	}
+	result["Pages"] = pages
+	result["PrevUrl"] = ""
+	result["NextUrl"] = ""
+	if pageNo > 1 {
+		result["PrevUrl"] = pageUrl(pageNo - 1)
+	}
+	if pageNo < totalPage {
+		result["NextUrl"] = pageUrl(pageNo + 1)
+	}
+}
+
+//搜索过滤条件：type扩展名（多个逗号分隔）、media文件类型、min_size/max_size大小（支持K、M、G单位）
+//from/to修改日期（yyyy-MM-dd）、scope目录范围、page/size分页
+func searchParams(c *gin.Context) service.SearchParams {
+	params := service.SearchParams{Key: c.Query("search"), Scope: c.Query("scope"), From: c.Query("from"), To: c.Query("to")}
+	for _, t := range strings.Split(c.Query("type"), ",") {
+		if t = strings.ToLower(strings.Trim(strings.TrimSpace(t), ".")); t != "" {
+			params.FileTypes = append(params.FileTypes, t)
+		}
+	}
+	params.MediaType, _ = strconv.Atoi(c.Query("media"))
+	params.MinSize = Util.ParseFileSize(c.Query("min_size"))
+	params.MaxSize = Util.ParseFileSize(c.Query("max_size"))
+	params.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	params.Size, _ = strconv.Atoi(c.DefaultQuery("size", "50"))
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.Size < 1 || params.Size > 1000 {
+		params.Size = 50
+	}
+	return params
+}
+
+//搜索所有账号（可通过account指定账号id，多个逗号分隔），返回json
+func searchApi(c *gin.Context) {
+	params := searchParams(c)
+	if params.Key == "" {
+		params.Key = c.Query("key")
+	}
+	if accountIds := c.Query("account"); accountIds != "" {
+		params.AccountIds = strings.Split(accountIds, ",")
+	}
+	params.User = apiUser(c)
+	list, total := service.SearchFiles(params)
+	c.JSON(http.StatusOK, gin.H{
+		"status": 0, "total": total, "page": params.Page, "size": params.Size,
+		"totalPage": service.GetTotalPage(int(total), params.Size), "list": service.SearchResults(list),
+	})
+}
+
+//文件夹打包下载，边读取边压缩输出
 func downloadMultiFiles(c *gin.Context) {
+	user := currentUser(c)
+	account, p, ok := folderPath(c, user)
+	if !ok {
+		return
+	}
+	entries, err := service.ZipEntries(user, account, p, dirPwd(c))
+	if err != nil {
+		if errors.Is(err, service.ErrZipPwd) {
+			c.JSON(http.StatusUnauthorized, gin.H{"status": -1, "msg": err.Error()})
+		} else if errors.Is(err, service.ErrZipLimit) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"status": -1, "msg": err.Error()})
+		} else {
+			log.Warningf("[打包下载][%s]%s >> %s", account.Name, p, err.Error())
+			c.JSON(http.StatusNotFound, gin.H{"status": -1, "msg": "目录不存在"})
+		}
+		return
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": service.ZipName(account, p) + ".zip"}))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+	auditDownload(c, "zip", account, p, "", fmt.Sprintf("文件数：%d", len(entries)))
+	if err = service.WriteZip(c.Request.Context(), c.Writer, account, p, entries); err != nil {
+		log.Warningf("[打包下载][%s]%s >> %s", account.Name, p, err.Error())
+	}
+}
+
+//打包下载、导出下载任务的目录，参数为accountId及目录的fileId，校验浏览权限及签名
+func folderPath(c *gin.Context, user entity.User) (entity.Account, string, bool) {
 	fileId := c.Query("fileId")
 	accountId := c.Query("accountId")
 	account := service.GetAccount(accountId)
-	if account.Mode == "native" {
-		dp := *DataPath
-		if os.Getenv("PAN_INDEX_DATA_PATH") != "" {
-			dp = os.Getenv("PAN_INDEX_DATA_PATH")
-		}
-		if dp == "" {
-			dp = "data"
-		}
-		//src := service.GetPath(accountId, accountId)
-		t := time.Now().Format("20060102150405")
-		dst := dp + string(filepath.Separator) + t + ".zip"
-		Util.Zip(dp+string(filepath.Separator)+t+".zip", fileId)
-		c.Writer.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%s", t+".zip"))
-		c.Writer.Header().Add("Content-Type", "application/octet-stream")
-		c.File(dst)
+	p := ""
+	if account.Id != "" && !drive.CapabilitiesOf(account).Cached {
+		//实时读取的模式fileId为根目录加路径
+		p = "/" + strings.Trim(filepath.ToSlash(strings.TrimPrefix(fileId, account.RootId)), "/")
+	} else {
+		p = service.GetPath(accountId, fileId)
+	}
+	if account.Id == "" || p == "" {
+		c.JSON(http.StatusNotFound, gin.H{"status": -1, "msg": "目录不存在"})
+		return account, p, false
+	}
+	if !service.HasPerm(user, account.Id, p, service.PermRead) {
+		c.JSON(http.StatusForbidden, gin.H{"status": -1, "msg": "没有访问权限"})
+		return account, p, false
+	}
+	if !checkSign(c, account.Id, p) {
+		return account, p, false
+	}
+	return account, p, true
+}
+
+//导出文件夹的下载任务，format为aria2（aria2输入文件，默认）、m3u、txt（地址列表）或rpc（提交到后台配置的aria2，需要管理员权限）
+func exportLinks(c *gin.Context) {
+	user := apiUser(c)
+	format := c.DefaultQuery("format", "aria2")
+	if format == "rpc" && user.Role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"status": -1, "msg": "只有管理员可以提交到aria2"})
+		return
+	}
+	account, p, ok := folderPath(c, user)
+	if !ok {
+		return
+	}
+	tasks, err := service.FolderTasks(c.Request.Context(), user, account, p, dirPwd(c), siteUrl(c))
+	if err != nil {
+		if errors.Is(err, service.ErrZipPwd) {
+			c.JSON(http.StatusUnauthorized, gin.H{"status": -1, "msg": err.Error()})
+		} else if errors.Is(err, service.ErrZipLimit) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"status": -1, "msg": err.Error()})
+		} else {
+			log.Warningf("[导出下载任务][%s]%s >> %s", account.Name, p, err.Error())
+			c.JSON(http.StatusNotFound, gin.H{"status": -1, "msg": "目录不存在"})
+		}
+		return
+	}
+	name := service.ZipName(account, p)
+	switch format {
+	case "rpc":
+		n, err := service.Aria2Submit(c.Request.Context(), tasks)
+		if err != nil {
+			log.Warningf("[导出下载任务][%s]%s >> %s", account.Name, p, err.Error())
+			c.JSON(http.StatusOK, gin.H{"status": -1, "msg": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": 0, "msg": fmt.Sprintf("已提交%d个下载任务到aria2", n)})
 		return
-	} else if account.Mode == "cloud189" {
-		downUrl := service.GetDownlaodMultiFiles(accountId, fileId)
-		c.JSON(http.StatusOK, gin.H{"redirect_url": downUrl})
+	case "m3u":
+		attachment(c, name+".m3u", "audio/x-mpegurl", service.M3u(tasks))
+	case "txt":
+		attachment(c, name+".txt", "text/plain; charset=utf-8", service.UrlList(tasks))
+	default:
+		attachment(c, name+".aria2.txt", "text/plain; charset=utf-8", service.Aria2InputFile(tasks))
+	}
+}
+
+func attachment(c *gin.Context, filename, contentType string, data []byte) {
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+//站点地址，例如https://example.com，用于生成完整的文件地址
+func siteUrl(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+//加密目录的访问密码，由页面输入后保存在cookie中
+func dirPwd(c *gin.Context) string {
+	pwd := ""
+	pwdCookie, err := c.Request.Cookie("dir_pwd")
+	if err == nil {
+		decodePwd, err := url.QueryUnescape(pwdCookie.Value)
+		if err != nil {
+			log.Warningln(err)
+		}
+		pwd = decodePwd
+	}
+	return pwd
+}
+
+//代理下载，响应头未写出时返回错误信息，action为审计日志中的操作
+func proxyDownload(c *gin.Context, action string, account entity.Account, fileNode entity.FileNode) {
+	host, err := service.ProxyDownload(account, fileNode, c.Writer, c.Request)
+	detail := ""
+	if err != nil {
+		detail = err.Error()
+	}
+	auditDownload(c, action, account, fileNode.Path, host, detail)
+	if err != nil {
+		log.Warningf("[代理下载][%s]%s >> %s", account.Name, fileNode.Path, err.Error())
+		if !c.Writer.Written() {
+			c.String(http.StatusBadGateway, err.Error())
+		}
 	}
 }
 
@@ -350,47 +938,106 @@ func shareToDown(c *gin.Context) {
 
 func admin(c *gin.Context) {
 	logout := c.Query("logout")
-	sessionId, error := c.Cookie("sessionId")
+	sessionId, _ := c.Cookie("sessionId")
 	if logout != "" && logout == "true" {
 		//退出登录
 		GC.Remove(sessionId)
 		c.HTML(http.StatusOK, "pan/admin/login.html", gin.H{"Error": true, "Msg": "退出成功", "Theme": config.GloablConfig.Theme})
 	} else {
 		if c.Request.Method == "GET" {
-			if error == nil && sessionId != "" && GC.Has(sessionId) {
+			user := currentUser(c)
+			if user.Role == "admin" {
 				//登录状态跳转首页
 				config := service.GetConfig()
 				c.HTML(http.StatusOK, "pan/admin/index.html", config)
+			} else if user.Id != "" {
+				//非管理员没有后台权限
+				c.Redirect(http.StatusFound, "/")
 			} else {
 				c.HTML(http.StatusOK, "pan/admin/login.html", gin.H{"Error": false, "Theme": config.GloablConfig.Theme, "FaviconUrl": config.GloablConfig.FaviconUrl})
 			}
 		} else {
 			//登录
+			name, _ := c.GetPostForm("name")
 			password, _ := c.GetPostForm("password")
+			if name == "" {
+				name = "admin"
+			}
 			config := service.GetConfig()
-			if password == config.AdminPassword {
+			user, ok := service.Login(name, password)
+			if ok {
 				//登录成功
 				u1 := uuid.NewV4().String()
 				c.SetCookie("sessionId", u1, 7*24*60*60, "/", "", false, true)
-				GC.SetWithExpire(u1, u1, time.Hour*24*7)
-				config := service.GetConfig()
-				c.HTML(http.StatusOK, "pan/admin/index.html", config)
+				GC.SetWithExpire(u1, user.Id, time.Hour*24*7)
+				if user.Role == "admin" {
+					c.HTML(http.StatusOK, "pan/admin/index.html", config)
+				} else {
+					c.Redirect(http.StatusFound, "/")
+				}
 			} else {
-				c.HTML(http.StatusOK, "pan/admin/login.html", gin.H{"Error": true, "Theme": config.Theme, "FaviconUrl": config.FaviconUrl, "Msg": "密码错误，请重试！"})
+				c.HTML(http.StatusOK, "pan/admin/login.html", gin.H{"Error": true, "Theme": config.Theme, "FaviconUrl": config.FaviconUrl, "Msg": "用户名或密码错误，请重试！"})
+			}
+		}
+	}
+}
+
+//当前用户，优先使用登录会话，其次是basic认证（WebDAV、脚本调用），都没有时为未登录用户
+func currentUser(c *gin.Context) entity.User {
+	sessionId, err := c.Cookie("sessionId")
+	if err == nil && sessionId != "" {
+		if userId, err := GC.Get(sessionId); err == nil {
+			if id, ok := userId.(string); ok {
+				return service.GetUser(id)
 			}
 		}
 	}
+	if name, password, ok := c.Request.BasicAuth(); ok {
+		if user, ok := service.BasicLogin(name, password); ok {
+			return user
+		}
+	}
+	return service.Guest
+}
+
+//无权限时，未登录用户跳转到登录页面
+func forbidden(c *gin.Context, user entity.User) {
+	if user.Id == "" {
+		c.Redirect(http.StatusFound, "/?admin")
+	} else {
+		c.String(http.StatusForbidden, "403 Forbidden")
+	}
 }
 
 func adminSave(c *gin.Context) {
 	configMap := make(map[string]interface{})
 	c.BindJSON(&configMap)
 	service.SaveConfig(configMap)
+	if accounts, ok := configMap["accounts"].([]interface{}); ok {
+		for _, a := range accounts {
+			if m, ok := a.(map[string]interface{}); ok {
+				account := entity.Account{}
+				account.Id, _ = m["id"].(string)
+				account.Name, _ = m["name"].(string)
+				mode, _ := m["mode"].(string)
+				auditAdmin(c, "save_account", account, "", "网盘模式："+mode)
+			}
+		}
+	} else {
+		//只记录修改的配置项，不记录值（可能包含密码）
+		keys := []string{}
+		for k := range configMap {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		auditAdmin(c, "save_config", entity.Account{}, "", strings.Join(keys, ","))
+	}
 	c.JSON(http.StatusOK, gin.H{"status": 0, "msg": "配置已更新，部分配置重启后生效！"})
 }
 
 func adminDeleteAccount(c *gin.Context) {
 	id := c.Query("id")
+	auditAdmin(c, "delete_account", service.GetAccount(id), "", "")
 	service.DeleteAccount(id)
 	c.JSON(http.StatusOK, gin.H{"status": 0, "msg": "删除成功！"})
 }
@@ -424,6 +1071,7 @@ func updateCookie(c *gin.Context) {
 func setDefaultAccount(c *gin.Context) {
 	id := c.Query("id")
 	service.SetDefaultAccount(id)
+	auditAdmin(c, "set_default_account", service.GetAccount(id), "", "")
 	c.JSON(http.StatusOK, gin.H{"status": 0, "msg": "默认账号设置成功！"})
 }
 
@@ -434,20 +1082,423 @@ func envToConfig(c *gin.Context) {
 
 func upload(c *gin.Context) {
 	accountId := c.PostForm("uploadAccount")
-	path := c.PostForm("uploadPath")
+	//先规范化路径再校验权限，避免../越过授权目录
+	path := path.Clean("/" + c.PostForm("uploadPath"))
 	t := c.PostForm("type")
 	msg := ""
+	if c.Query("token") != config.GloablConfig.ApiToken && !service.HasPerm(currentUser(c), accountId, path, service.PermUpload) {
+		c.JSON(http.StatusForbidden, gin.H{"status": -1, "msg": "没有上传权限"})
+		return
+	}
 	if t == "0" {
 		msg = service.Upload(accountId, path, c)
 	} else if t == "1" {
 		service.Async(accountId, path)
 		msg = "刷新缓存成功"
 	} else if t == "2" {
-		service.Upload(accountId, path, c)
+		msg = service.Upload(accountId, path, c)
 		service.Async(accountId, path)
-		msg = "上传并刷新成功"
+		if msg == "上传成功" {
+			msg = "上传并刷新成功"
+		}
+	}
+	if t == "0" || t == "2" {
+		names := []string{}
+		if form, err := c.MultipartForm(); err == nil {
+			for _, f := range form.File["uploadFile"] {
+				names = append(names, f.Filename)
+			}
+		}
+		auditAdmin(c, "upload", service.GetAccount(accountId), path, strings.Join(names, ",")+"："+msg)
 	}
 	c.JSON(http.StatusOK, gin.H{"status": 0, "msg": msg})
 }
 
+//分片上传：init创建任务（断点续传时返回已接收的大小），chunk按顺序写入分片，complete后在后台上传到网盘
+func uploadInit(c *gin.Context) {
+	req := struct {
+		AccountId string `json:"accountId"`
+		Path      string `json:"path"`
+		Name      string `json:"name"`
+		Size      int64  `json:"size"`
+	}{}
+	c.BindJSON(&req)
+	req.Path = path.Clean("/" + req.Path)
+	user := currentUser(c)
+	if c.Query("token") != config.GloablConfig.ApiToken && !service.HasPerm(user, req.AccountId, req.Path, service.PermUpload) {
+		c.JSON(http.StatusForbidden, gin.H{"status": -1, "msg": "没有上传权限"})
+		return
+	}
+	task, msg := service.InitUpload(user, req.AccountId, req.Path, req.Name, req.Size)
+	if msg != "" {
+		c.JSON(http.StatusOK, gin.H{"status": -1, "msg": msg})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 0, "data": task, "chunkSize": service.UploadChunkSize})
+}
+
+//获取上传任务，只有发起上传的用户（或携带token）可以操作
+func uploadTask(c *gin.Context) (entity.UploadTask, bool) {
+	task, ok := service.GetUploadTask(c.Query("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"status": -1, "msg": "上传任务不存在"})
+		return task, false
+	}
+	if c.Query("token") != config.GloablConfig.ApiToken && currentUser(c).Id != task.UserId {
+		c.JSON(http.StatusForbidden, gin.H{"status": -1, "msg": "没有上传权限"})
+		return task, false
+	}
+	return task, true
+}
+
+func uploadChunk(c *gin.Context) {
+	task, ok := uploadTask(c)
+	if !ok {
+		return
+	}
+	offset, _ := strconv.ParseInt(c.Query("offset"), 10, 64)
+	task, err := service.WriteUploadChunk(task.Id, offset, c.Request.Body)
+	if err == service.ErrUploadOffset {
+		c.JSON(http.StatusConflict, gin.H{"status": -1, "msg": err.Error(), "uploaded": task.Uploaded})
+	} else if err != nil {
+		c.JSON(http.StatusOK, gin.H{"status": -1, "msg": err.Error(), "uploaded": task.Uploaded})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"status": 0, "uploaded": task.Uploaded})
+	}
+}
+
+func uploadComplete(c *gin.Context) {
+	task, ok := uploadTask(c)
+	if !ok {
+		return
+	}
+	task, msg := service.CompleteUpload(task.Id, c.Query("refresh") == "1")
+	if msg != "" {
+		c.JSON(http.StatusOK, gin.H{"status": -1, "msg": msg})
+		return
+	}
+	auditAdmin(c, "upload", service.GetAccount(task.AccountId), task.Path, task.Name)
+	c.JSON(http.StatusOK, gin.H{"status": 0, "data": task})
+}
+
+func uploadStatus(c *gin.Context) {
+	task, ok := uploadTask(c)
+	if !ok {
+		return
+	}
+	task, sent, _ := service.UploadStatus(task.Id)
+	c.JSON(http.StatusOK, gin.H{"status": 0, "data": task, "sent": sent})
+}
+
+func dav(c *gin.Context) {
+	mode := config.GloablConfig.WebdavMode
+	if mode == "0" {
+		c.String(http.StatusNotFound, "WebDAV未开启")
+		return
+	}
+	_, password, _ := c.Request.BasicAuth()
+	user := currentUser(c)
+	method := c.Request.Method
+	readOnly := method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || method == "PROPFIND"
+	if !readOnly {
+		//写操作需要开启读写模式，并使用用户登录，具体权限由访问控制规则决定
+		if mode != "2" {
+			c.String(http.StatusForbidden, "WebDAV为只读模式")
+			return
+		}
+		if user.Id == "" {
+			davUnauthorized(c)
+			return
+		}
+	}
+	fs := service.NewDavFileSystem(user, password, !readOnly)
+	c.Set("auditUser", user.Name)
+	name := strings.TrimPrefix(c.Request.URL.Path, "/dav")
+	if !readOnly && method != "LOCK" && method != "UNLOCK" {
+		perm := service.PermUpload
+		if method == http.MethodDelete || method == "MOVE" {
+			perm = service.PermDelete
+		}
+		if !fs.Allowed(name, perm) {
+			c.String(http.StatusForbidden, "403 Forbidden")
+			return
+		}
+	}
+	fi, err := fs.Access(c, name)
+	if os.IsPermission(err) {
+		//加密目录（密码为目录密码）或没有访问权限
+		davUnauthorized(c)
+		return
+	}
+	if err == nil && (method == http.MethodGet || method == http.MethodHead) {
+		//WebDAV客户端无法附带sign、exp参数，开启下载地址签名时只有登录用户可以通过WebDAV下载文件
+		if config.GloablConfig.SignUrl == 1 && user.Id == "" && !fi.IsDir() {
+			davUnauthorized(c)
+			return
+		}
+		if account, fileNode, ok := fs.CloudFile(fi); ok {
+			if drive.ProxyDownload(account) {
+				proxyDownload(c, "dav", account, fileNode)
+			} else {
+				downUrl := service.GetDownlaodUrl(account, fileNode)
+				metrics.DownloadRedirects.Inc(account.Name)
+				auditDownload(c, "dav", account, fileNode.Path, urlHost(downUrl), "")
+				c.Redirect(http.StatusFound, downUrl)
+			}
+			return
+		}
+		if !fi.IsDir() {
+			//本地文件由WebDAV直接输出，路径以账号名称开头
+			auditDownload(c, "dav", entity.Account{}, name, "", "")
+		}
+	}
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: fs,
+		LockSystem: DavLockSystem,
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Warningf("[WebDAV]%s %s >> %s", r.Method, r.URL.Path, err.Error())
+			}
+		},
+	}
+	handler.ServeHTTP(c.Writer, c.Request)
+}
+
+func davUnauthorized(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Basic realm="PanIndex"`)
+	c.String(http.StatusUnauthorized, "401 Unauthorized")
+}
+
+func saveUser(c *gin.Context) {
+	user := make(map[string]interface{})
+	c.BindJSON(&user)
+	if msg := service.SaveUser(user); msg != "" {
+		c.JSON(http.StatusOK, gin.H{"status": -1, "msg": msg})
+		return
+	}
+	name, _ := user["name"].(string)
+	auditAdmin(c, "save_user", entity.Account{}, "", name)
+	c.JSON(http.StatusOK, gin.H{"status": 0, "msg": "用户已保存！"})
+}
+
+func deleteUser(c *gin.Context) {
+	if msg := service.DeleteUser(c.Query("id")); msg != "" {
+		c.JSON(http.StatusOK, gin.H{"status": -1, "msg": msg})
+		return
+	}
+	auditAdmin(c, "delete_user", entity.Account{}, "", c.Query("id"))
+	c.JSON(http.StatusOK, gin.H{"status": 0, "msg": "删除成功！"})
+}
+
+func saveAcl(c *gin.Context) {
+	acl := entity.Acl{}
+	c.BindJSON(&acl)
+	if msg := service.SaveAcl(acl); msg != "" {
+		c.JSON(http.StatusOK, gin.H{"status": -1, "msg": msg})
+		return
+	}
+	auditAdmin(c, "save_acl", service.GetAccount(acl.AccountId), acl.Path, "")
+	c.JSON(http.StatusOK, gin.H{"status": 0, "msg": "规则已保存！"})
+}
+
+func deleteAcl(c *gin.Context) {
+	service.DeleteAcl(c.Query("id"))
+	auditAdmin(c, "delete_acl", entity.Account{}, "", c.Query("id"))
+	c.JSON(http.StatusOK, gin.H{"status": 0, "msg": "删除成功！"})
+}
+
+//后台创建分享，有效期为小时数
+func saveShare(c *gin.Context) {
+	req := shareRequest{}
+	c.BindJSON(&req)
+	share, msg := service.CreateShare(apiUser(c), req.Account, req.Path, "", req.Password, req.ExpireHours, req.MaxDownloads)
+	if msg != "" {
+		c.JSON(http.StatusOK, gin.H{"status": -1, "msg": msg})
+		return
+	}
+	auditAdmin(c, "save_share", service.GetAccount(share.AccountId), share.Path, "/s/"+share.Token)
+	c.JSON(http.StatusOK, gin.H{"status": 0, "msg": "分享已创建：/s/" + share.Token, "data": share})
+}
+
+func revokeShare(c *gin.Context) {
+	if msg := service.RevokeShare(c.Query("id"), ""); msg != "" {
+		c.JSON(http.StatusOK, gin.H{"status": -1, "msg": msg})
+		return
+	}
+	auditAdmin(c, "revoke_share", entity.Account{}, "", c.Query("id"))
+	c.JSON(http.StatusOK, gin.H{"status": 0, "msg": "分享已撤销！"})
+}
+
+//账号的登录、缓存刷新记录，默认最近50条
+func accountEvents(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	events, lastError := service.AccountEvents(c.Query("id"), limit)
+	result := gin.H{"status": 0, "data": events}
+	if lastError.Id != 0 {
+		result["last_error"] = lastError
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+//向已配置的通知渠道发送测试通知，请先保存配置
+func notifyTest(c *gin.Context) {
+	result := notify.Test()
+	if len(result) == 0 {
+		c.JSON(http.StatusOK, gin.H{"status": -1, "msg": "未配置通知渠道"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 0, "data": result})
+}
+
+//审计日志的公共字段：用户、IP、User-Agent及Referer
+func auditEntry(c *gin.Context, kind, action string, account entity.Account, p, detail string) service.AuditEntry {
+	user := c.GetString("auditUser")
+	if user == "" {
+		user = currentUser(c).Name
+	}
+	if user == "" && c.Query("token") != "" && c.Query("token") == config.GloablConfig.ApiToken {
+		user = "token"
+	}
+	return service.AuditEntry{
+		Type:      kind,
+		Action:    action,
+		User:      user,
+		Ip:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Referer:   c.Request.Referer(),
+		AccountId: account.Id,
+		Account:   account.Name,
+		Path:      p,
+		Detail:    detail,
+	}
+}
+
+//记录文件下载并累计下载次数，upstream为实际下载地址的域名，HEAD请求及断点续传的后续请求不记录
+func auditDownload(c *gin.Context, action string, account entity.Account, p, upstream, detail string) {
+	if r := c.GetHeader("Range"); c.Request.Method != http.MethodGet || (r != "" && !strings.HasPrefix(r, "bytes=0-")) {
+		return
+	}
+	if account.Id != "" && action != "zip" {
+		service.CountDownload(account.Id, p)
+	}
+	if strings.HasPrefix(c.Request.URL.Path, "/s/") && detail == "" {
+		detail = "分享链接：" + c.Request.URL.Path
+	}
+	entry := auditEntry(c, "download", action, account, p, detail)
+	entry.UpstreamHost = upstream
+	service.Audit(entry)
+}
+
+//记录后台操作
+func auditAdmin(c *gin.Context, action string, account entity.Account, p, detail string) {
+	service.Audit(auditEntry(c, "admin", action, account, p, detail))
+}
+
+func urlHost(u string) string {
+	if parsed, err := url.Parse(u); err == nil {
+		return parsed.Host
+	}
+	return ""
+}
+
+//查询审计日志，参数见service.AuditFilter
+func auditLog(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	entries := service.QueryAudit(service.AuditFilter{
+		Type:    c.Query("type"),
+		Action:  c.Query("action"),
+		User:    c.Query("user"),
+		Account: c.Query("account"),
+		Ip:      c.Query("ip"),
+		Path:    c.Query("path"),
+		From:    c.Query("from"),
+		To:      c.Query("to"),
+		Limit:   limit,
+	})
+	c.JSON(http.StatusOK, gin.H{"status": 0, "data": entries})
+}
+
+//后台下载报表，days统计天数（默认30），top每个账号显示的热门文件数（默认10）
+func downloadReport(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	top, _ := strconv.Atoi(c.DefaultQuery("top", "10"))
+	if days < 1 || days > 365 {
+		days = 30
+	}
+	c.JSON(http.StatusOK, gin.H{"status": 0, "data": service.GetDownloadReport(days, top)})
+}
+
+//统计请求数及耗时，路由由处理函数通过setRoute指定，未指定时按路径归类
+func metricsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.GetString("metricsRoute")
+		if route == "" {
+			route = metricsRoute(c)
+		}
+		account := c.GetString("metricsAccount")
+		if account == "" {
+			//接口按参数中的账号统计，只接受已绑定的账号，避免标签数量失控
+			id := c.Query("accountId")
+			if id == "" {
+				id = c.Query("account")
+			}
+			for _, a := range config.GloablConfig.Accounts {
+				if id != "" && (a.Id == id || a.Name == id) {
+					account = a.Name
+					break
+				}
+			}
+		}
+		metrics.Requests.Inc(route, account, strconv.Itoa(c.Writer.Status()))
+		metrics.RequestDuration.Observe(time.Since(start).Seconds(), route)
+	}
+}
+
+//未指定路由时按路径归类，接口使用完整路径，页面由处理函数指定
+func metricsRoute(c *gin.Context) string {
+	p := c.Request.URL.Path
+	switch {
+	case strings.HasPrefix(p, "/static/"):
+		return "/static"
+	case p == "/dav" || strings.HasPrefix(p, "/dav/"):
+		return "/dav"
+	case strings.HasPrefix(p, "/s/"):
+		return "/s"
+	case strings.HasPrefix(p, "/api/") && c.Writer.Status() != http.StatusNotFound:
+		return p
+	case p == "/metrics":
+		return p
+	}
+	return "other"
+}
+
+func setRoute(c *gin.Context, route string) {
+	c.Set("metricsRoute", route)
+}
+
+func setAccount(c *gin.Context, account entity.Account) {
+	c.Set("metricsAccount", account.Name)
+}
+
+//Prometheus指标，需要接口token，支持token参数或Authorization: Bearer请求头
+func metricsApi(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		token = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	if token != config.GloablConfig.ApiToken {
+		c.String(http.StatusUnauthorized, "Invalid api token")
+		return
+	}
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metrics.Write(c.Writer)
+}
+
 func unescaped(x string) interface{} { return template.HTML(x) }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"PanIndex/config"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShareIndex(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataPath := t.TempDir()
+	model.InitDb("", "", dataPath, false)
+	root := filepath.Join(dataPath, "root")
+	os.MkdirAll(root, os.ModePerm)
+	if err := ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	model.SqliteDb.Create(&entity.Account{Id: "share-test", Name: "share-test", Mode: "native", RootId: root})
+	now := time.Now()
+	format := func(t time.Time) string { return t.Format("2006-01-02 15:04:05") }
+	shares := []entity.Share{
+		{Id: "1", Token: "ok", AccountId: "share-test", Path: "/a.txt", MaxDownloads: 1},
+		{Id: "2", Token: "revoked", AccountId: "share-test", Path: "/a.txt", Revoked: 1},
+		{Id: "3", Token: "expired", AccountId: "share-test", Path: "/a.txt", ExpireTime: format(now.Add(-time.Minute))},
+		{Id: "4", Token: "future", AccountId: "share-test", Path: "/a.txt", ExpireTime: format(now.Add(time.Hour))},
+		{Id: "5", Token: "exhausted", AccountId: "share-test", Path: "/a.txt", MaxDownloads: 2, Downloads: 2},
+		{Id: "6", Token: "noaccount", AccountId: "missing", Path: "/a.txt"},
+	}
+	for _, s := range shares {
+		model.SqliteDb.Create(&s)
+	}
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"不存在", "/s/unknown", http.StatusNotFound},
+		{"已撤销", "/s/revoked", http.StatusNotFound},
+		{"账号已删除", "/s/noaccount", http.StatusNotFound},
+		{"分享文件时不能访问子路径", "/s/future/b.txt", http.StatusNotFound},
+		{"已过期", "/s/expired", http.StatusGone},
+		{"下载次数已用完", "/s/exhausted", http.StatusGone},
+		{"未过期", "/s/future", http.StatusOK},
+		{"第1次下载", "/s/ok", http.StatusOK},
+		{"超过下载次数", "/s/ok", http.StatusGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, tt.path, nil)
+			shareIndex(c)
+			if w.Code != tt.want {
+				t.Errorf("GET %s = %d %s, want %d", tt.path, w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDavSign(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataPath := t.TempDir()
+	model.InitDb("", "", dataPath, false)
+	root := filepath.Join(dataPath, "root")
+	os.MkdirAll(root, os.ModePerm)
+	if err := ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := config.GloablConfig
+	defer func() { config.GloablConfig = old }()
+	config.GloablConfig.WebdavMode = "1"
+	config.GloablConfig.Acls = nil
+	config.GloablConfig.Accounts = []entity.Account{{Id: "dav-test", Name: "dav", Mode: "native", RootId: root}}
+	tests := []struct {
+		name    string
+		signUrl int
+		method  string
+		path    string
+		auth    bool
+		want    int
+	}{
+		{"开启签名时未登录不能下载", 1, http.MethodGet, "/dav/dav/a.txt", false, http.StatusUnauthorized},
+		{"开启签名时未登录不能HEAD", 1, http.MethodHead, "/dav/dav/a.txt", false, http.StatusUnauthorized},
+		{"开启签名时未登录可以浏览目录", 1, "PROPFIND", "/dav/dav/", false, http.StatusMultiStatus},
+		{"开启签名时登录用户可以下载", 1, http.MethodGet, "/dav/dav/a.txt", true, http.StatusOK},
+		{"未开启签名时未登录可以下载", 0, http.MethodGet, "/dav/dav/a.txt", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.GloablConfig.SignUrl = tt.signUrl
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.method == "PROPFIND" {
+				c.Request.Header.Set("Depth", "1")
+			}
+			if tt.auth {
+				c.Request.SetBasicAuth("admin", "PanIndex")
+			}
+			dav(c)
+			if w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Prometheus文本格式的指标，只实现用到的counter、gauge及histogram
+
+//耗时（秒）的默认分桶
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(buf *bytes.Buffer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+//以Prometheus文本格式输出所有指标
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	cs := append([]collector{}, registry...)
+	registryMu.Unlock()
+	var buf bytes.Buffer
+	for _, c := range cs {
+		c.write(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+//指标名称、说明及标签名，同一指标按标签值区分
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+}
+
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s需要%d个标签值，实际为%d个", v.name, len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (v *vec) header(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+//标签字符串，例如{route="/",account="a"}，extra为额外的标签（histogram的le）
+func (v *vec) labelString(key string, extra ...string) string {
+	pairs := []string{}
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labels[i]+"=\""+escape(value)+"\"")
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"=\""+escape(extra[i+1])+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(s)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//计数器，只增不减
+type CounterVec struct {
+	vec
+	values map[string]float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: vec{name: name, help: help, kind: "counter", labels: labels}, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(n float64, values ...string) {
+	k := c.key(values)
+	c.mu.Lock()
+	c.values[k] += n
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(buf *bytes.Buffer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(buf)
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(buf, "%s%s %s\n", c.name, c.labelString(k), formatFloat(c.values[k]))
+	}
+}
+
+//仪表盘，可以任意设置
+type GaugeVec struct {
+	vec
+	values map[string]float64
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: vec{name: name, help: help, kind: "gauge", labels: labels}, values: map[string]float64{}}
+	register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(n float64, values ...string) {
+	k := g.key(values)
+	g.mu.Lock()
+	g.values[k] = n
+	g.mu.Unlock()
+}
+
+//删除某组标签的值，例如账号删除后
+func (g *GaugeVec) Delete(values ...string) {
+	k := g.key(values)
+	g.mu.Lock()
+	delete(g.values, k)
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) write(buf *bytes.Buffer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(buf)
+	for _, k := range sortedKeys(g.values) {
+		fmt.Fprintf(buf, "%s%s %s\n", g.name, g.labelString(k), formatFloat(g.values[k]))
+	}
+}
+
+//直方图，记录各分桶的累计次数、总和及次数
+type HistogramVec struct {
+	vec
+	buckets []float64 //分桶上限，最后一个为+Inf
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 //每个分桶（不累计）的次数，最后一个为+Inf
+	sum    float64
+	count  uint64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append(append([]float64{}, buckets...), math.Inf(1))
+	h := &HistogramVec{vec: vec{name: name, help: help, kind: "histogram", labels: labels}, buckets: buckets, values: map[string]*histogram{}}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	k := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv := h.values[k]
+	if hv == nil {
+		hv = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hv
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	hv.counts[i]++
+	hv.sum += v
+	hv.count++
+}
+
+func (h *HistogramVec) write(buf *bytes.Buffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(buf)
+	keys := []string{}
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hv := h.values[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hv.counts[i]
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", h.name, h.labelString(k, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_sum%s %s\n", h.name, h.labelString(k), formatFloat(hv.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", h.name, h.labelString(k), hv.count)
+	}
+}
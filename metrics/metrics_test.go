@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/eddieivan01/nic"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounterGauge(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "请求数", "route", "code")
+	c.Inc("/a", "200")
+	c.Add(2, "/a", "200")
+	c.Inc("/b\"c", "404")
+	var buf bytes.Buffer
+	c.write(&buf)
+	want := "# HELP test_requests_total 请求数\n# TYPE test_requests_total counter\n" +
+		"test_requests_total{route=\"/a\",code=\"200\"} 3\n" +
+		"test_requests_total{route=\"/b\\\"c\",code=\"404\"} 1\n"
+	if buf.String() != want {
+		t.Errorf("counter:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	g := NewGaugeVec("test_nodes", "文件数", "account")
+	g.Set(5, "a")
+	g.Set(7, "b")
+	g.Delete("a")
+	buf.Reset()
+	g.write(&buf)
+	want = "# HELP test_nodes 文件数\n# TYPE test_nodes gauge\ntest_nodes{account=\"b\"} 7\n"
+	if buf.String() != want {
+		t.Errorf("gauge:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("wrong number of label values should panic")
+		}
+	}()
+	c.Inc("/")
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "耗时", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/")
+	h.Observe(0.1, "/")
+	h.Observe(0.5, "/")
+	h.Observe(3, "/")
+	var buf bytes.Buffer
+	h.write(&buf)
+	want := "# HELP test_duration_seconds 耗时\n# TYPE test_duration_seconds histogram\n" +
+		"test_duration_seconds_bucket{route=\"/\",le=\"0.1\"} 2\n" +
+		"test_duration_seconds_bucket{route=\"/\",le=\"1\"} 3\n" +
+		"test_duration_seconds_bucket{route=\"/\",le=\"+Inf\"} 4\n" +
+		"test_duration_seconds_sum{route=\"/\"} 3.65\n" +
+		"test_duration_seconds_count{route=\"/\"} 4\n"
+	if buf.String() != want {
+		t.Errorf("histogram:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestHook(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/target", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer srv.Close()
+	s := &nic.Session{}
+	Hook(s, "test")
+	//重复调用只保留一组钩子
+	Hook(s, "test")
+	if _, err := s.Get(srv.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "panindex_upstream_request_duration_seconds_count{backend=\"test\",code=\"418\"} 1\n") {
+		t.Errorf("upstream duration not recorded:\n%s", buf.String())
+	}
+}
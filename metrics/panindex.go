@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"context"
+	"github.com/eddieivan01/nic"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	Requests = NewCounterVec("panindex_http_requests_total",
+		"按路由、账号及状态码统计的请求数", "route", "account", "code")
+	RequestDuration = NewHistogramVec("panindex_http_request_duration_seconds",
+		"请求耗时（秒），文件下载按整个传输过程计算", DefBuckets, "route")
+	DownloadRedirects = NewCounterVec("panindex_download_redirects_total",
+		"跳转到网盘直链的下载次数", "account")
+	UpstreamDuration = NewHistogramVec("panindex_upstream_request_duration_seconds",
+		"调用网盘接口的耗时（秒），只统计有响应的请求", DefBuckets, "backend", "code")
+	SyncDuration = NewHistogramVec("panindex_sync_duration_seconds",
+		"目录缓存耗时（秒）", []float64{1, 5, 10, 30, 60, 300, 600, 1800, 3600}, "account")
+	SyncNodes = NewGaugeVec("panindex_sync_file_nodes",
+		"最近一次目录缓存后的文件数", "account")
+	Syncs = NewCounterVec("panindex_syncs_total",
+		"目录缓存次数", "account", "result")
+	Logins = NewCounterVec("panindex_logins_total",
+		"登录及令牌刷新次数，type为login或refresh", "account", "mode", "type", "result")
+	ReadmeCache = NewCounterVec("panindex_readme_cache_lookups_total",
+		"README缓存查询次数，result为hit或miss", "result")
+)
+
+//成功或失败的标签值
+func Result(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
+
+type startKey struct{}
+
+//为nic会话注册钩子，记录该会话请求网盘接口的耗时，重复调用会替换之前的钩子
+//开始时间保存在请求的context中，跟随重定向后的请求传递
+func Hook(s *nic.Session, backend string) {
+	s.ResetBeforeReqHook()
+	s.ResetAfterRespHook()
+	s.RegisterBeforeReqHook(func(req *http.Request) error {
+		*req = *req.WithContext(context.WithValue(req.Context(), startKey{}, time.Now()))
+		return nil
+	})
+	s.RegisterAfterRespHook(func(resp *http.Response) error {
+		if start, ok := resp.Request.Context().Value(startKey{}).(time.Time); ok {
+			UpstreamDuration.Observe(time.Since(start).Seconds(), backend, strconv.Itoa(resp.StatusCode))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"unicode"
+)
+
+//是否启用全文索引，sqlite不支持fts4时退回like查询
+var FtsEnabled = false
+
+//文件名全文索引，id、account_id只存储不索引
+func initSearchIndex() {
+	err := SqliteDb.Exec("create virtual table if not exists file_node_fts using fts4(id, account_id, name, notindexed=id, notindexed=account_id, tokenize=unicode61)").Error
+	if err != nil {
+		log.Warningln("[程序启动]搜索索引 >> 不支持全文索引，使用普通查询：" + err.Error())
+		return
+	}
+	FtsEnabled = true
+}
+
+//重建账号的搜索索引，目录缓存更新后调用
+func RebuildSearchIndex(accountId string) {
+	if !FtsEnabled {
+		return
+	}
+	type node struct {
+		Id       string
+		FileName string
+	}
+	nodes := []node{}
+	SqliteDb.Raw("select id, file_name from file_node where account_id=? and `delete`=0", accountId).Scan(&nodes)
+	tx := SqliteDb.Begin()
+	tx.Exec("delete from file_node_fts where account_id=?", accountId)
+	for _, n := range nodes {
+		tx.Exec("insert into file_node_fts(id, account_id, name) values(?, ?, ?)", n.Id, accountId, SearchTokens(n.FileName))
+	}
+	if err := tx.Commit().Error; err != nil {
+		log.Warningf("[搜索索引]%s >> 索引失败：%s", accountId, err.Error())
+	}
+}
+
+//局部刷新目录后更新索引：删除removedIds的索引，为尚未生效（delete=1）的新数据建立索引
+//需要在新数据生效之前调用
+func UpdateSearchIndex(accountId string, removedIds []string) {
+	if !FtsEnabled {
+		return
+	}
+	nodes := []struct {
+		Id       string
+		FileName string
+	}{}
+	SqliteDb.Raw("select id, file_name from file_node where account_id=? and `delete`=1", accountId).Scan(&nodes)
+	tx := SqliteDb.Begin()
+	for i := 0; i < len(removedIds); i += 500 {
+		end := i + 500
+		if end > len(removedIds) {
+			end = len(removedIds)
+		}
+		tx.Exec("delete from file_node_fts where id in ?", removedIds[i:end])
+	}
+	for _, n := range nodes {
+		tx.Exec("insert into file_node_fts(id, account_id, name) values(?, ?, ?)", n.Id, accountId, SearchTokens(n.FileName))
+	}
+	if err := tx.Commit().Error; err != nil {
+		log.Warningf("[搜索索引]%s >> 索引失败：%s", accountId, err.Error())
+	}
+}
+
+func DeleteSearchIndex(accountId string) {
+	if FtsEnabled {
+		SqliteDb.Exec("delete from file_node_fts where account_id=?", accountId)
+	}
+}
+
+//将文件名切分为索引词，中日韩文字逐字切分，字母和数字连续的部分作为一个词
+func SearchTokens(s string) string {
+	return strings.Join(splitTokens(s), " ")
+}
+
+//将搜索关键字转为fts查询，每个关键字作为一个短语，最后一个词前缀匹配
+func SearchMatch(key string) string {
+	phrases := []string{}
+	for _, term := range strings.Fields(key) {
+		tokens := splitTokens(term)
+		if len(tokens) > 0 {
+			phrases = append(phrases, `"`+strings.Join(tokens, " ")+`*"`)
+		}
+	}
+	return strings.Join(phrases, " ")
+}
+
+func splitTokens(s string) []string {
+	tokens := []string{}
+	word := []rune{}
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	for _, r := range strings.ToLower(s) {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			flush()
+			tokens = append(tokens, string(r))
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word = append(word, r)
+		} else {
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
@@ -2,8 +2,12 @@ package model
 
 import (
 	"PanIndex/entity"
+	crand "crypto/rand"
+	"encoding/hex"
 	"fmt"
+	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -16,6 +20,9 @@ import (
 
 var SqliteDb *gorm.DB
 
+//数据目录，存放数据库及上传的临时文件
+var DataPath = "data"
+
 func InitDb(host, port, dataPath string, debug bool) {
 	if os.Getenv("PAN_INDEX_DATA_PATH") != "" {
 		dataPath = os.Getenv("PAN_INDEX_DATA_PATH")
@@ -23,6 +30,7 @@ func InitDb(host, port, dataPath string, debug bool) {
 	if dataPath == "" {
 		dataPath = "data"
 	}
+	DataPath = dataPath
 	if _, err := os.Stat(dataPath); os.IsNotExist(err) {
 		os.Mkdir(dataPath, os.ModePerm)
 	}
@@ -50,13 +58,35 @@ func InitDb(host, port, dataPath string, debug bool) {
 	SqliteDb.AutoMigrate(&entity.Config{})
 	SqliteDb.AutoMigrate(&entity.Account{})
 	SqliteDb.AutoMigrate(&entity.Damagou{})
+	SqliteDb.AutoMigrate(&entity.User{})
+	SqliteDb.AutoMigrate(&entity.Acl{})
+	SqliteDb.AutoMigrate(&entity.UploadTask{})
+	SqliteDb.AutoMigrate(&entity.Share{})
+	SqliteDb.AutoMigrate(&entity.AccountEvent{})
+	SqliteDb.AutoMigrate(&entity.DownloadStat{})
+	initSearchIndex()
 	//初始化数据
 	c := entity.Config{}
 	SqliteDb.Raw("select * from config where 1=1").Find(&c)
 	if c.Host == "" {
 		rand.Seed(time.Now().UnixNano())
 		ApiToken := strconv.Itoa(rand.Intn(10000))
-		SqliteDb.Create(&entity.Config{"0.0.0.0", 5238, nil, "", "", "", ApiToken, "mdui", "PanIndex", entity.Damagou{}, "", "0 0 8 1/1 * ?", "", "", "", ""})
+		c = entity.Config{Host: "0.0.0.0", Port: 5238, ApiToken: ApiToken, Theme: "mdui", AdminPassword: "PanIndex", RefreshCookie: "0 0 8 1/1 * ?", WebdavMode: "1", ZipMaxFiles: 1000, ZipMaxSize: 4096}
+		SqliteDb.Create(&c)
+	}
+	var userCount int64
+	SqliteDb.Model(&entity.User{}).Count(&userCount)
+	if userCount == 0 {
+		//没有用户时，使用后台密码创建管理员
+		hash, _ := bcrypt.GenerateFromPassword([]byte(c.AdminPassword), bcrypt.DefaultCost)
+		SqliteDb.Create(&entity.User{Id: uuid.NewV4().String(), Name: "admin", Password: string(hash), Role: "admin"})
+		log.Println("[程序启动]用户初始化 >> 已创建管理员admin，密码与后台登录密码一致")
+	}
+	if c.SignKey == "" {
+		//下载地址签名密钥
+		key := make([]byte, 32)
+		crand.Read(key)
+		SqliteDb.Table("config").Where("1=1").Update("sign_key", hex.EncodeToString(key))
 	}
 	if os.Getenv("PORT") != "" {
 		port = os.Getenv("PORT")
@@ -0,0 +1,322 @@
+package notify
+
+import (
+	"PanIndex/config"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//默认通知的事件
+const DefaultEvents = "login_fail,refresh_fail,sync_fail,upload_fail"
+
+//单次发送的超时时间
+const sendTimeout = 15 * time.Second
+
+//通知事件，Type为login（登录）、refresh（令牌刷新）、sync（目录缓存）、upload（上传）
+type Event struct {
+	Event       string `json:"event"` //Type加上_ok或_fail，用于匹配配置的通知事件
+	Type        string `json:"type"`
+	Success     bool   `json:"success"`
+	AccountId   string `json:"account_id"`
+	AccountName string `json:"account_name"`
+	Mode        string `json:"mode"`
+	Path        string `json:"path,omitempty"`
+	Msg         string `json:"msg"` //失败时为错误信息
+	Time        string `json:"time"`
+	Title       string `json:"title"`
+	Content     string `json:"content"`
+}
+
+var typeNames = map[string]string{"login": "登录", "refresh": "令牌刷新", "sync": "目录缓存", "upload": "上传", "test": "测试"}
+
+//通知渠道
+type channel struct {
+	name string
+	send func(ctx context.Context, e Event) error
+}
+
+//创建事件并补全标题及内容
+func NewEvent(kind string, success bool, accountId, accountName, mode, path, msg string) Event {
+	e := Event{
+		Type:        kind,
+		Success:     success,
+		AccountId:   accountId,
+		AccountName: accountName,
+		Mode:        mode,
+		Path:        path,
+		Msg:         msg,
+		Time:        time.Now().Format("2006-01-02 15:04:05"),
+	}
+	result := "失败"
+	e.Event = kind + "_fail"
+	if success {
+		result = "成功"
+		e.Event = kind + "_ok"
+	}
+	name := typeNames[kind]
+	if name == "" {
+		name = kind
+	}
+	e.Title = "[PanIndex]" + name + result
+	lines := []string{}
+	if accountName != "" {
+		e.Title = fmt.Sprintf("[PanIndex][%s]%s%s", accountName, name, result)
+		lines = append(lines, "账号："+accountName+"（"+mode+"）")
+	}
+	lines = append(lines, "事件："+name+result)
+	if path != "" {
+		lines = append(lines, "路径："+path)
+	}
+	if msg != "" {
+		lines = append(lines, "详情："+msg)
+	}
+	lines = append(lines, "时间："+e.Time)
+	e.Content = strings.Join(lines, "\n")
+	return e
+}
+
+//事件是否需要通知，通知事件为空时使用默认事件，*表示全部
+func Enabled(event string) bool {
+	events := config.GloablConfig.NotifyEvents
+	if events == "" {
+		events = DefaultEvents
+	}
+	for _, e := range strings.Split(events, ",") {
+		e = strings.TrimSpace(e)
+		if e == "*" || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+//在后台发送通知，每个渠道失败后按1s、2s、4s...重试
+func Send(e Event) {
+	if !Enabled(e.Event) {
+		return
+	}
+	for _, ch := range channels() {
+		go func(ch channel) {
+			if err := retry(ch, e); err != nil {
+				log.Warningf("[通知][%s]%s发送失败 >> %s", ch.name, e.Event, err.Error())
+			}
+		}(ch)
+	}
+}
+
+//立即向所有渠道发送测试通知，返回各渠道的错误信息
+func Test() map[string]string {
+	e := NewEvent("test", true, "", "", "", "", "这是一条测试通知")
+	result := map[string]string{}
+	for _, ch := range channels() {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := ch.send(ctx, e)
+		cancel()
+		result[ch.name] = "发送成功"
+		if err != nil {
+			result[ch.name] = err.Error()
+		}
+	}
+	return result
+}
+
+func retry(ch channel, e Event) error {
+	times := config.GloablConfig.NotifyRetry
+	if times < 0 {
+		times = 0
+	}
+	var err error
+	for i := 0; i <= times; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(1<<uint(i-1)) * time.Second)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err = ch.send(ctx, e)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+//已配置的通知渠道
+func channels() []channel {
+	c := config.GloablConfig
+	chs := []channel{}
+	if c.NotifyWebhook != "" {
+		chs = append(chs, channel{"webhook", webhook})
+	}
+	if c.NotifyTgToken != "" && c.NotifyTgChat != "" {
+		chs = append(chs, channel{"telegram", telegram})
+	}
+	if c.NotifyServerChan != "" {
+		chs = append(chs, channel{"serverchan", serverChan})
+	}
+	if c.NotifySmtpHost != "" && c.NotifyMailTo != "" {
+		chs = append(chs, channel{"email", email})
+	}
+	return chs
+}
+
+//通用webhook，POST事件JSON
+func webhook(ctx context.Context, e Event) error {
+	body, _ := json.Marshal(e)
+	_, err := post(ctx, config.GloablConfig.NotifyWebhook, "application/json", bytes.NewReader(body))
+	return err
+}
+
+//Telegram机器人，chat为用户、群组或频道的ID
+func telegram(ctx context.Context, e Event) error {
+	body, _ := json.Marshal(map[string]string{
+		"chat_id": config.GloablConfig.NotifyTgChat,
+		"text":    e.Title + "\n" + e.Content,
+	})
+	data, err := post(ctx, "https://api.telegram.org/bot"+config.GloablConfig.NotifyTgToken+"/sendMessage",
+		"application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if !jsoniter.Get(data, "ok").ToBool() {
+		return errors.New(jsoniter.Get(data, "description").ToString())
+	}
+	return nil
+}
+
+//Server酱，配置SendKey
+func serverChan(ctx context.Context, e Event) error {
+	form := url.Values{}
+	form.Set("title", e.Title)
+	//desp为markdown，换行需要空行
+	form.Set("desp", strings.ReplaceAll(e.Content, "\n", "\n\n"))
+	data, err := post(ctx, "https://sctapi.ftqq.com/"+config.GloablConfig.NotifyServerChan+".send",
+		"application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	if code := jsoniter.Get(data, "code").ToInt(); code != 0 {
+		return fmt.Errorf("错误代码：%d (%s)", code, jsoniter.Get(data, "message").ToString())
+	}
+	return nil
+}
+
+func post(ctx context.Context, u, contentType string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return data, errors.New(resp.Status)
+	}
+	return data, nil
+}
+
+//SMTP邮件，host为host:port，465端口使用SSL，其他端口服务器支持时使用STARTTLS
+func email(ctx context.Context, e Event) error {
+	c := config.GloablConfig
+	host, port, err := net.SplitHostPort(c.NotifySmtpHost)
+	if err != nil {
+		return err
+	}
+	from := c.NotifySmtpUser
+	to := []string{}
+	for _, addr := range strings.Split(c.NotifyMailTo, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	msg := "From: " + from + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: =?UTF-8?B?" + b64(e.Title) + "?=\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" +
+		wrap(b64(e.Content), 76) + "\r\n"
+	d := net.Dialer{}
+	var conn net.Conn
+	if port == "465" {
+		conn, err = (&tls.Dialer{NetDialer: &d, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", c.NotifySmtpHost)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", c.NotifySmtpHost)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && port != "465" {
+		if err = client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if c.NotifySmtpPass != "" {
+		if err = client.Auth(smtp.PlainAuth("", c.NotifySmtpUser, c.NotifySmtpPass, host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err = client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+//邮件正文每行不超过76个字符
+func wrap(s string, n int) string {
+	lines := []string{}
+	for len(s) > n {
+		lines = append(lines, s[:n])
+		s = s[n:]
+	}
+	return strings.Join(append(lines, s), "\r\n")
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"PanIndex/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewEvent(t *testing.T) {
+	e := NewEvent("sync", false, "a1", "网盘", "cloud189", "/dir", "超时")
+	if e.Event != "sync_fail" || e.Title != "[PanIndex][网盘]目录缓存失败" {
+		t.Errorf("event = %s, title = %s", e.Event, e.Title)
+	}
+	want := "账号：网盘（cloud189）\n事件：目录缓存失败\n路径：/dir\n详情：超时\n时间：" + e.Time
+	if e.Content != want {
+		t.Errorf("content = %q, want %q", e.Content, want)
+	}
+	e = NewEvent("test", true, "", "", "", "", "")
+	if e.Event != "test_ok" || e.Title != "[PanIndex]测试成功" || e.Content != "事件：测试成功\n时间："+e.Time {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	c := config.GloablConfig
+	defer func() { config.GloablConfig = c }()
+	tests := []struct {
+		events, event string
+		want          bool
+	}{
+		{"", "sync_fail", true},
+		{"", "sync_ok", false},
+		{"login_ok, sync_ok", "sync_ok", true},
+		{"login_ok", "sync_fail", false},
+		{"*", "upload_ok", true},
+	}
+	for _, tt := range tests {
+		config.GloablConfig.NotifyEvents = tt.events
+		if got := Enabled(tt.event); got != tt.want {
+			t.Errorf("Enabled(%q) with %q = %v, want %v", tt.event, tt.events, got, tt.want)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	c := config.GloablConfig
+	defer func() { config.GloablConfig = c }()
+	var calls int32
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//第一次请求失败，重试后成功
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+	config.GloablConfig.NotifyWebhook = srv.URL
+	config.GloablConfig.NotifyRetry = 1
+	if err := retry(channels()[0], NewEvent("upload", false, "a1", "网盘", "native", "/a.txt", "失败")); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if calls != 2 || got.Event != "upload_fail" || got.Path != "/a.txt" {
+		t.Errorf("calls = %d, event = %+v", calls, got)
+	}
+	config.GloablConfig.NotifyWebhook = srv.URL + "/404"
+	srv.Config.Handler = http.NotFoundHandler()
+	if result := Test(); !strings.Contains(result["webhook"], "404") {
+		t.Errorf("Test() = %v, want 404 for webhook", result)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if got := wrap(strings.Repeat("a", 160), 76); got != strings.Repeat("a", 76)+"\r\n"+strings.Repeat("a", 76)+"\r\n"+strings.Repeat("a", 8) {
+		t.Errorf("wrap = %q", got)
+	}
+	if got := wrap("abc", 76); got != "abc" {
+		t.Errorf("wrap = %q", got)
+	}
+}
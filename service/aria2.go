@@ -0,0 +1,184 @@
+package service
+
+import (
+	"PanIndex/config"
+	"PanIndex/drive"
+	"PanIndex/entity"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+//导出下载任务时最多的文件数，网盘直链需要逐个获取
+const FolderTaskLimit = 5000
+
+var ErrAria2NotConfigured = errors.New("未配置aria2 RPC地址")
+
+//文件夹导出的下载任务，Dir为保存的相对目录（以导出的目录名开头），保持原有目录结构
+type DownloadTask struct {
+	Url    string
+	Dir    string
+	Name   string
+	Header map[string]string
+}
+
+//导出目录下所有文件的下载任务，siteUrl为站点地址（例如https://example.com）
+//本地模式及代理下载的账号使用站点地址（开启签名时附带签名），其他账号使用网盘直链
+func FolderTasks(ctx context.Context, user entity.User, account entity.Account, p, pwd, siteUrl string) ([]DownloadTask, error) {
+	entries, err := FolderEntries(user, account, p, pwd)
+	if err != nil {
+		return nil, err
+	}
+	files := []entity.FileNode{}
+	for _, fn := range entries {
+		if !fn.IsFolder {
+			files = append(files, fn)
+		}
+	}
+	if len(files) > FolderTaskLimit {
+		return nil, fmt.Errorf("%w：文件数%d，最多%d个", ErrZipLimit, len(files), FolderTaskLimit)
+	}
+	var header map[string]string
+	if dh, ok := drive.Get(account.Mode).(drive.DownloadHeaderer); ok {
+		header = dh.DownloadHeader(account)
+	}
+	base := ZipName(account, p)
+	tasks := []DownloadTask{}
+	for _, fn := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		task := DownloadTask{
+			Dir:  path.Join(base, strings.TrimPrefix(path.Dir(fn.Path), p)),
+			Name: fn.FileName,
+		}
+		if account.Mode != "native" && !drive.ProxyDownload(account) {
+			task.Url = GetDownlaodUrl(account, fn)
+			task.Header = header
+		}
+		if task.Url == "" {
+			task.Url = siteUrl + (&url.URL{Path: PageUrl(account.Id, fn.Path)}).String() + SignQuery(account.Id, fn.Path)
+			task.Header = nil
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+//aria2输入文件（aria2c -i），每个任务指定保存目录及文件名
+func Aria2InputFile(tasks []DownloadTask) []byte {
+	var buf bytes.Buffer
+	for _, t := range tasks {
+		fmt.Fprintf(&buf, "%s\n  dir=%s\n  out=%s\n", t.Url, t.Dir, t.Name)
+		for _, k := range headerKeys(t.Header) {
+			fmt.Fprintf(&buf, "  header=%s: %s\n", k, t.Header[k])
+		}
+	}
+	return buf.Bytes()
+}
+
+//m3u播放列表，标题为文件的相对路径
+func M3u(tasks []DownloadTask) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	for _, t := range tasks {
+		fmt.Fprintf(&buf, "#EXTINF:-1,%s\n%s\n", path.Join(t.Dir, t.Name), t.Url)
+	}
+	return buf.Bytes()
+}
+
+//纯文本地址列表，每行一个
+func UrlList(tasks []DownloadTask) []byte {
+	var buf bytes.Buffer
+	for _, t := range tasks {
+		buf.WriteString(t.Url + "\n")
+	}
+	return buf.Bytes()
+}
+
+//通过JSON-RPC批量提交到aria2，返回提交成功的任务数
+func Aria2Submit(ctx context.Context, tasks []DownloadTask) (int, error) {
+	rpc := config.GloablConfig.Aria2Rpc
+	if rpc == "" {
+		return 0, ErrAria2NotConfigured
+	}
+	calls := []map[string]interface{}{}
+	for _, t := range tasks {
+		options := map[string]interface{}{"dir": path.Join(config.GloablConfig.Aria2Dir, t.Dir), "out": t.Name}
+		if config.GloablConfig.Aria2Dir == "" {
+			//未指定下载目录时，out包含相对目录，保存到aria2默认目录下
+			delete(options, "dir")
+			options["out"] = path.Join(t.Dir, t.Name)
+		}
+		if len(t.Header) > 0 {
+			header := []string{}
+			for _, k := range headerKeys(t.Header) {
+				header = append(header, k+": "+t.Header[k])
+			}
+			options["header"] = header
+		}
+		params := []interface{}{[]string{t.Url}, options}
+		if config.GloablConfig.Aria2Secret != "" {
+			params = append([]interface{}{"token:" + config.GloablConfig.Aria2Secret}, params...)
+		}
+		calls = append(calls, map[string]interface{}{"methodName": "aria2.addUri", "params": params})
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0", "id": "PanIndex", "method": "system.multicall", "params": []interface{}{calls},
+	})
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rpc, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if msg := jsoniter.Get(data, "error", "message").ToString(); msg != "" {
+		return 0, errors.New("aria2：" + msg)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New("aria2：" + resp.Status)
+	}
+	//每个调用成功时返回[gid]，失败时返回{code, message}
+	results := jsoniter.Get(data, "result")
+	submitted, lastErr := 0, ""
+	for i := 0; i < results.Size(); i++ {
+		if msg := results.Get(i, "message").ToString(); msg != "" {
+			lastErr = msg
+		} else {
+			submitted++
+		}
+	}
+	if submitted == 0 && lastErr != "" {
+		return 0, errors.New("aria2：" + lastErr)
+	}
+	return submitted, nil
+}
+
+func headerKeys(header map[string]string) []string {
+	keys := []string{}
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"PanIndex/config"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAria2Submit(t *testing.T) {
+	var status int
+	var response string
+	var calls []struct {
+		MethodName string        `json:"methodName"`
+		Params     []interface{} `json:"params"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req := struct {
+			Method string
+			Params []json.RawMessage
+		}{}
+		json.Unmarshal(body, &req)
+		if req.Method != "system.multicall" || len(req.Params) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.Unmarshal(req.Params[0], &calls)
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	defer srv.Close()
+	old := config.GloablConfig
+	defer func() { config.GloablConfig = old }()
+	tasks := []DownloadTask{
+		{Url: "https://a.test/1", Dir: "dir", Name: "1.txt", Header: map[string]string{"Referer": "https://r.test/", "Cookie": "c=1"}},
+		{Url: "https://a.test/2", Dir: "dir/sub", Name: "2.txt"},
+	}
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		want     int
+		wantErr  bool
+	}{
+		{"全部成功", 200, `{"id":"PanIndex","jsonrpc":"2.0","result":[["2089b05ecca3d829"],["d2703803b52216d1"]]}`, 2, false},
+		{"部分失败", 200, `{"id":"PanIndex","jsonrpc":"2.0","result":[["2089b05ecca3d829"],{"code":1,"message":"Invalid URI"}]}`, 1, false},
+		{"全部失败", 200, `{"id":"PanIndex","jsonrpc":"2.0","result":[{"code":1,"message":"Invalid URI"},{"code":1,"message":"Invalid URI"}]}`, 0, true},
+		{"认证失败", 400, `{"id":"PanIndex","jsonrpc":"2.0","error":{"code":1,"message":"Unauthorized"}}`, 0, true},
+		{"HTTP错误", 500, ``, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.GloablConfig.Aria2Rpc = srv.URL + "/jsonrpc"
+			config.GloablConfig.Aria2Secret = "s"
+			config.GloablConfig.Aria2Dir = "/downloads"
+			status, response = tt.status, tt.response
+			got, err := Aria2Submit(context.Background(), tasks)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("Aria2Submit() = %d, %v, want %d, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+	//每个任务一个aria2.addUri调用，第一个参数为密钥
+	want := []interface{}{
+		"token:s",
+		[]interface{}{"https://a.test/1"},
+		map[string]interface{}{"dir": "/downloads/dir", "out": "1.txt", "header": []interface{}{"Cookie: c=1", "Referer: https://r.test/"}},
+	}
+	if len(calls) != 2 || calls[0].MethodName != "aria2.addUri" || !reflect.DeepEqual(calls[0].Params, want) {
+		t.Errorf("提交的调用为%+v, want %v", calls, want)
+	}
+	//未指定下载目录时out包含相对目录
+	config.GloablConfig.Aria2Dir = ""
+	config.GloablConfig.Aria2Secret = ""
+	Aria2Submit(context.Background(), tasks[1:])
+	want = []interface{}{[]interface{}{"https://a.test/2"}, map[string]interface{}{"out": "dir/sub/2.txt"}}
+	if len(calls) != 1 || !reflect.DeepEqual(calls[0].Params, want) {
+		t.Errorf("提交的调用为%+v, want %v", calls, want)
+	}
+	config.GloablConfig.Aria2Rpc = ""
+	if _, err := Aria2Submit(context.Background(), tasks); err != ErrAria2NotConfigured {
+		t.Errorf("未配置RPC地址时返回%v", err)
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	tasks := []DownloadTask{
+		{Url: "https://a.test/1", Dir: "dir", Name: "1.txt", Header: map[string]string{"Referer": "https://r.test/", "Cookie": "c=1"}},
+		{Url: "https://a.test/2", Dir: "dir/sub", Name: "2.txt"},
+	}
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"aria2", Aria2InputFile(tasks), "https://a.test/1\n  dir=dir\n  out=1.txt\n  header=Cookie: c=1\n  header=Referer: https://r.test/\n" +
+			"https://a.test/2\n  dir=dir/sub\n  out=2.txt\n"},
+		{"m3u", M3u(tasks), "#EXTM3U\n#EXTINF:-1,dir/1.txt\nhttps://a.test/1\n#EXTINF:-1,dir/sub/2.txt\nhttps://a.test/2\n"},
+		{"txt", UrlList(tasks), "https://a.test/1\nhttps://a.test/2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if string(tt.got) != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
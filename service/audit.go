@@ -0,0 +1,155 @@
+package service
+
+import (
+	"PanIndex/model"
+	"bytes"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//审计日志单个文件的大小上限，超过后轮转
+const auditMaxSize = 10 * 1024 * 1024
+
+//保留的历史审计日志文件数
+const auditKeepFiles = 10
+
+//审计日志，Type为download（文件下载）或admin（后台操作）
+type AuditEntry struct {
+	Time         string `json:"time"`
+	Type         string `json:"type"`
+	Action       string `json:"action"` //download、zip、save_config、save_account、delete_account、set_default_account、upload等
+	User         string `json:"user"`   //用户名，使用接口token时为token，未登录为空
+	Ip           string `json:"ip"`
+	UserAgent    string `json:"user_agent"`
+	Referer      string `json:"referer,omitempty"`
+	AccountId    string `json:"account_id,omitempty"`
+	Account      string `json:"account,omitempty"` //账号名称
+	Path         string `json:"path,omitempty"`
+	UpstreamHost string `json:"upstream_host,omitempty"` //下载时实际访问的网盘地址，本地文件为空
+	Detail       string `json:"detail,omitempty"`
+}
+
+//审计日志查询条件，为空表示不限制，Path为包含匹配，同时匹配路径及详情
+type AuditFilter struct {
+	Type    string
+	Action  string
+	User    string
+	Account string //账号id或名称
+	Ip      string
+	Path    string
+	From    string //开始时间，格式2006-01-02 15:04:05，可以只写日期
+	To      string
+	Limit   int
+}
+
+var (
+	auditMu   sync.Mutex
+	auditFile *os.File
+)
+
+func auditDir() string {
+	return filepath.Join(model.DataPath, "logs")
+}
+
+func auditPath() string {
+	return filepath.Join(auditDir(), "audit.log")
+}
+
+//写入一条审计日志，每行一个JSON
+func Audit(entry AuditEntry) {
+	entry.Time = time.Now().Format("2006-01-02 15:04:05")
+	data, _ := json.Marshal(entry)
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if auditFile == nil {
+		if err := os.MkdirAll(auditDir(), os.ModePerm); err != nil {
+			log.Warningln("[审计日志]" + err.Error())
+			return
+		}
+		f, err := os.OpenFile(auditPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Warningln("[审计日志]" + err.Error())
+			return
+		}
+		auditFile = f
+	}
+	if _, err := auditFile.Write(append(data, '\n')); err != nil {
+		log.Warningln("[审计日志]" + err.Error())
+		return
+	}
+	if fi, err := auditFile.Stat(); err == nil && fi.Size() >= auditMaxSize {
+		rotateAudit()
+	}
+}
+
+//当前文件重命名为audit-时间.log，并删除多余的历史文件
+func rotateAudit() {
+	auditFile.Close()
+	auditFile = nil
+	os.Rename(auditPath(), filepath.Join(auditDir(), "audit-"+time.Now().Format("20060102-150405")+".log"))
+	files := auditHistory()
+	for i := auditKeepFiles; i < len(files); i++ {
+		os.Remove(files[i])
+	}
+}
+
+//历史审计日志文件，新的在前
+func auditHistory() []string {
+	files, _ := filepath.Glob(filepath.Join(auditDir(), "audit-*.log"))
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files
+}
+
+//查询审计日志，按时间倒序，默认最近100条，最多1000条
+func QueryAudit(f AuditFilter) []AuditEntry {
+	if f.Limit <= 0 {
+		f.Limit = 100
+	} else if f.Limit > 1000 {
+		f.Limit = 1000
+	}
+	if len(f.To) == len("2006-01-02") {
+		f.To += " 23:59:59"
+	}
+	result := []AuditEntry{}
+	for _, file := range append([]string{auditPath()}, auditHistory()...) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		lines := bytes.Split(data, []byte("\n"))
+		for i := len(lines) - 1; i >= 0; i-- {
+			entry := AuditEntry{}
+			if json.Unmarshal(lines[i], &entry) != nil {
+				continue
+			}
+			if f.From != "" && entry.Time < f.From {
+				//更早的日志都不满足条件
+				return result
+			}
+			if f.match(entry) {
+				result = append(result, entry)
+				if len(result) >= f.Limit {
+					return result
+				}
+			}
+		}
+	}
+	return result
+}
+
+func (f AuditFilter) match(e AuditEntry) bool {
+	return (f.Type == "" || e.Type == f.Type) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.User == "" || e.User == f.User) &&
+		(f.Account == "" || e.AccountId == f.Account || e.Account == f.Account) &&
+		(f.Ip == "" || e.Ip == f.Ip) &&
+		(f.Path == "" || strings.Contains(e.Path, f.Path) || strings.Contains(e.Detail, f.Path)) &&
+		(f.To == "" || e.Time <= f.To)
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"PanIndex/model"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//审计日志写入临时目录，结束后关闭文件
+func initTestAudit(t *testing.T) {
+	dataPath := model.DataPath
+	model.DataPath = t.TempDir()
+	t.Cleanup(func() {
+		auditMu.Lock()
+		if auditFile != nil {
+			auditFile.Close()
+			auditFile = nil
+		}
+		auditMu.Unlock()
+		model.DataPath = dataPath
+	})
+}
+
+func TestQueryAudit(t *testing.T) {
+	initTestAudit(t)
+	os.MkdirAll(auditDir(), os.ModePerm)
+	old := []AuditEntry{
+		{Time: "2021-01-01 10:00:00", Type: "download", Action: "download", Ip: "1.1.1.1", Path: "/old.txt"},
+		{Time: "2021-01-02 10:00:00", Type: "admin", Action: "save_config", User: "admin"},
+	}
+	data := []byte{}
+	for _, e := range old {
+		line, _ := json.Marshal(e)
+		data = append(append(data, line...), '\n')
+	}
+	ioutil.WriteFile(filepath.Join(auditDir(), "audit-20210102-100000.log"), data, 0644)
+	Audit(AuditEntry{Type: "download", Action: "download", Ip: "1.1.1.1", AccountId: "a1", Account: "网盘", Path: "/a/b.txt"})
+	Audit(AuditEntry{Type: "download", Action: "zip", Ip: "2.2.2.2", AccountId: "a1", Account: "网盘", Path: "/a", Detail: "/a/c.txt"})
+
+	tests := []struct {
+		filter  AuditFilter
+		actions []string
+	}{
+		{AuditFilter{}, []string{"zip", "download", "save_config", "download"}},
+		{AuditFilter{Limit: 2}, []string{"zip", "download"}},
+		{AuditFilter{Type: "download"}, []string{"zip", "download", "download"}},
+		{AuditFilter{Ip: "1.1.1.1"}, []string{"download", "download"}},
+		{AuditFilter{Account: "网盘"}, []string{"zip", "download"}},
+		{AuditFilter{Account: "a1", Path: "c.txt"}, []string{"zip"}},
+		{AuditFilter{From: "2021-01-02"}, []string{"zip", "download", "save_config"}},
+		{AuditFilter{To: "2021-01-01"}, []string{"download"}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, e := range QueryAudit(tt.filter) {
+			got = append(got, e.Action)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.actions) {
+			t.Errorf("QueryAudit(%+v) = %v, want %v", tt.filter, got, tt.actions)
+		}
+	}
+}
+
+func TestRotateAudit(t *testing.T) {
+	initTestAudit(t)
+	os.MkdirAll(auditDir(), os.ModePerm)
+	for i := 0; i < auditKeepFiles+2; i++ {
+		ioutil.WriteFile(filepath.Join(auditDir(), fmt.Sprintf("audit-200001%02d-000000.log", i+1)), nil, 0644)
+	}
+	Audit(AuditEntry{Type: "admin", Action: "upload"})
+	auditMu.Lock()
+	rotateAudit()
+	auditMu.Unlock()
+	files := auditHistory()
+	if len(files) != auditKeepFiles {
+		t.Fatalf("history = %d files, want %d", len(files), auditKeepFiles)
+	}
+	//最旧的文件被删除，新轮转的文件排在最前
+	if filepath.Base(files[len(files)-1]) != "audit-20000104-000000.log" {
+		t.Errorf("oldest = %s", files[len(files)-1])
+	}
+	if entries := QueryAudit(AuditFilter{}); len(entries) != 1 || entries[0].Action != "upload" {
+		t.Errorf("entries = %+v", entries)
+	}
+	Audit(AuditEntry{Type: "admin", Action: "save_config"})
+	if _, err := os.Stat(auditPath()); err != nil {
+		t.Errorf("audit.log not reopened: %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"PanIndex/entity"
+	"PanIndex/model"
+	"testing"
+)
+
+func TestAccountEvents(t *testing.T) {
+	initTestDb(t)
+	for _, e := range []entity.AccountEvent{
+		{AccountId: "a1", Type: "login", Success: true},
+		{AccountId: "a1", Type: "sync", Success: false, Msg: "first"},
+		{AccountId: "a1", Type: "sync", Success: false, Msg: "second"},
+		{AccountId: "a1", Type: "sync", Success: true},
+		{AccountId: "a2", Type: "sync", Success: false, Msg: "other"},
+	} {
+		model.SqliteDb.Create(&e)
+	}
+	events, lastError := AccountEvents("a1", 3)
+	if len(events) != 3 || events[0].Id != 4 || events[2].Id != 2 {
+		t.Errorf("events = %+v, want ids 4,3,2", events)
+	}
+	if lastError.Msg != "second" {
+		t.Errorf("lastError = %+v, want second", lastError)
+	}
+	if _, lastError := AccountEvents("a3", 50); lastError.Id != 0 {
+		t.Errorf("lastError = %+v, want none", lastError)
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"PanIndex/entity"
+	"PanIndex/model"
+	"reflect"
+	"testing"
+)
+
+func listNames(list []entity.FileNode) []string {
+	names := []string{}
+	for _, fn := range list {
+		names = append(names, fn.FileName)
+	}
+	return names
+}
+
+func testNodes() []entity.FileNode {
+	return []entity.FileNode{
+		{FileName: "b.mp4", FileType: "mp4", FileSize: 300, LastOpTime: "2021-01-02 00:00:00"},
+		{FileName: "dir2", FileType: "b", IsFolder: true, FileSize: 8192, LastOpTime: "2021-01-01 00:00:00"},
+		{FileName: "a.txt", FileType: "txt", FileSize: 100, LastOpTime: "2021-01-04 00:00:00"},
+		{FileName: "dir1", FileType: "c", IsFolder: true, FileSize: 4096, LastOpTime: "2021-01-03 00:00:00"},
+		{FileName: "c.jpg", FileType: "jpg", FileSize: 200, LastOpTime: "2021-01-01 00:00:00"},
+	}
+}
+
+//缓存模式和实时模式的排序分页结果一致
+var listPageTests = []struct {
+	name   string
+	params ListParams
+	want   []string
+}{
+	{"默认按时间倒序", ListParams{}, []string{"dir1", "dir2", "a.txt", "b.mp4", "c.jpg"}},
+	{"按名称正序", ListParams{Sort: "name"}, []string{"dir1", "dir2", "a.txt", "b.mp4", "c.jpg"}},
+	{"按名称倒序，目录仍在前", ListParams{Sort: "name", Order: "desc"}, []string{"dir2", "dir1", "c.jpg", "b.mp4", "a.txt"}},
+	{"按大小", ListParams{Sort: "size"}, []string{"dir1", "dir2", "a.txt", "c.jpg", "b.mp4"}},
+	{"按类型倒序", ListParams{Sort: "type", Order: "desc"}, []string{"dir1", "dir2", "a.txt", "b.mp4", "c.jpg"}},
+	{"未知排序字段按时间", ListParams{Sort: "file_name;drop", Order: "asc"}, []string{"dir2", "dir1", "c.jpg", "b.mp4", "a.txt"}},
+	{"第1页", ListParams{Page: 1, Size: 2}, []string{"dir1", "dir2"}},
+	{"第2页", ListParams{Page: 2, Size: 2}, []string{"a.txt", "b.mp4"}},
+	{"最后一页", ListParams{Page: 3, Size: 2}, []string{"c.jpg"}},
+	{"超出页数", ListParams{Page: 4, Size: 2}, []string{}},
+}
+
+func TestSortFiles(t *testing.T) {
+	for _, tt := range listPageTests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := testNodes()
+			sortFiles(list, tt.params)
+			if got := listNames(pageOf(list, tt.params)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sortFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListFilesPage(t *testing.T) {
+	model.InitDb("", "", t.TempDir(), false)
+	account := entity.Account{Id: "list-test", Name: "list", Mode: "cloud189"}
+	nodes := append(testNodes(),
+		entity.FileNode{FileName: "hidden.txt", LastOpTime: "2021-01-05 00:00:00", Hide: 1},
+		entity.FileNode{FileName: "deleted.txt", LastOpTime: "2021-01-05 00:00:00", Delete: 1},
+		entity.FileNode{FileName: "other.txt", ParentPath: "/other", LastOpTime: "2021-01-05 00:00:00"},
+	)
+	for _, fn := range nodes {
+		fn.Id = fn.FileName
+		fn.FileId = fn.FileName
+		fn.AccountId = account.Id
+		if fn.ParentPath == "" {
+			fn.ParentPath = "/dir"
+		}
+		fn.Path = fn.ParentPath + "/" + fn.FileName
+		model.SqliteDb.Create(&fn)
+	}
+	for _, tt := range listPageTests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, total, isFile, _ := ListFilesPage(account, "/dir", "", tt.params)
+			if got := listNames(list); !reflect.DeepEqual(got, tt.want) || total != 5 || isFile {
+				t.Errorf("ListFilesPage() = %v, %d, %v, want %v, 5", got, total, isFile, tt.want)
+			}
+		})
+	}
+	list, total, isFile, _ := ListFilesPage(account, "/dir/a.txt", "", ListParams{Page: 1, Size: 2})
+	if got := listNames(list); !reflect.DeepEqual(got, []string{"a.txt"}) || total != 1 || !isFile {
+		t.Errorf("ListFilesPage(文件) = %v, %d, %v", got, total, isFile)
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"PanIndex/entity"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+//预览类型
+const (
+	PreviewImage    = "image"
+	PreviewAudio    = "audio"
+	PreviewVideo    = "video"
+	PreviewText     = "text"
+	PreviewMarkdown = "markdown"
+	PreviewPdf      = "pdf"
+)
+
+//文本预览最多读取的大小，超出部分需要下载查看
+const PreviewTextLimit = 1024 * 1024
+
+var previewExts = map[string][]string{
+	PreviewImage: {"jpg", "jpeg", "png", "gif", "webp", "bmp", "svg", "ico", "avif"},
+	PreviewAudio: {"mp3", "flac", "wav", "ogg", "oga", "m4a", "aac", "opus", "weba"},
+	PreviewVideo: {"mp4", "webm", "mkv", "mov", "m4v", "ogv"},
+	PreviewText: {"txt", "log", "ini", "conf", "cfg", "toml", "yml", "yaml", "json", "xml", "csv", "sql",
+		"go", "java", "kt", "py", "rb", "php", "js", "ts", "jsx", "tsx", "vue", "html", "htm", "css", "scss", "less",
+		"c", "h", "cpp", "hpp", "cs", "rs", "swift", "lua", "sh", "bash", "bat", "ps1", "properties", "gradle",
+		"dockerfile", "srt", "ass", "ssa", "vtt", "lrc", "nfo"},
+	PreviewMarkdown: {"md", "markdown"},
+	PreviewPdf:      {"pdf"},
+}
+
+//字幕文件扩展名
+var subtitleExts = []string{"vtt", "srt", "ass", "ssa"}
+
+//highlight.js的语言名称，与扩展名不同的需要转换
+var previewLangs = map[string]string{
+	"yml": "yaml", "htm": "html", "vue": "html", "sh": "bash", "py": "python", "rb": "ruby", "js": "javascript",
+	"jsx": "javascript", "ts": "typescript", "tsx": "typescript", "kt": "kotlin", "rs": "rust", "cs": "csharp",
+	"hpp": "cpp", "h": "c", "bat": "dos", "ps1": "powershell", "conf": "nginx", "cfg": "ini", "toml": "ini",
+	"txt": "plaintext", "log": "plaintext", "csv": "plaintext", "srt": "plaintext", "vtt": "plaintext",
+	"lrc": "plaintext", "nfo": "plaintext", "ass": "ini", "ssa": "ini", "gradle": "groovy",
+}
+
+func fileExt(name string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+}
+
+//文件的预览类型，按扩展名判断，无法判断时使用文件的媒体类型，不支持预览时返回空
+func PreviewKind(fileNode entity.FileNode) string {
+	ext := fileExt(fileNode.FileName)
+	for kind, exts := range previewExts {
+		for _, e := range exts {
+			if e == ext {
+				return kind
+			}
+		}
+	}
+	switch fileNode.MediaType {
+	case 1:
+		return PreviewImage
+	case 2:
+		return PreviewAudio
+	case 3:
+		return PreviewVideo
+	case 4:
+		return PreviewText
+	}
+	return ""
+}
+
+//代码高亮的语言
+func PreviewLang(fileNode entity.FileNode) string {
+	ext := fileExt(fileNode.FileName)
+	if lang, ok := previewLangs[ext]; ok {
+		return lang
+	}
+	if ext == "" {
+		return "plaintext"
+	}
+	return ext
+}
+
+//读取文本内容，最多读取PreviewTextLimit，超出时truncated为true
+func ReadPreviewText(ctx context.Context, account entity.Account, fileNode entity.FileNode) (string, bool, error) {
+	rc, err := OpenFileContent(ctx, account, fileNode)
+	if err != nil {
+		return "", false, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, PreviewTextLimit+1))
+	if err != nil {
+		return "", false, err
+	}
+	truncated := len(data) > PreviewTextLimit
+	if truncated {
+		data = trimPartialRune(data[:PreviewTextLimit])
+	}
+	return string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))), truncated, nil
+}
+
+//去掉末尾不完整的多字节字符
+func trimPartialRune(data []byte) []byte {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+//视频字幕，同目录下与视频同名的字幕文件，例如movie.srt、movie.zh.ass
+type Subtitle struct {
+	entity.FileNode
+	Label string //字幕名称，同名部分之后的内容，例如zh
+}
+
+func Subtitles(video entity.FileNode, siblings []entity.FileNode) []Subtitle {
+	base := strings.TrimSuffix(video.FileName, path.Ext(video.FileName))
+	subtitles := []Subtitle{}
+	for _, fn := range siblings {
+		if fn.IsFolder || !strings.HasPrefix(fn.FileName, base+".") {
+			continue
+		}
+		ext := fileExt(fn.FileName)
+		for _, e := range subtitleExts {
+			if e == ext {
+				label := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(fn.FileName, base), path.Ext(fn.FileName)), ".")
+				if label == "" {
+					label = strings.ToUpper(ext)
+				}
+				subtitles = append(subtitles, Subtitle{FileNode: fn, Label: label})
+				break
+			}
+		}
+	}
+	return subtitles
+}
+
+var (
+	srtTime = regexp.MustCompile(`(\d{1,2}:\d{2}:\d{2}),(\d{3})`)
+	assTags = regexp.MustCompile(`\{[^}]*\}`)
+)
+
+//将srt、ass字幕转换为浏览器支持的WebVTT格式
+func ToVtt(name string, data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	switch fileExt(name) {
+	case "vtt":
+		return data
+	case "ass", "ssa":
+		return assToVtt(data)
+	}
+	return append([]byte("WEBVTT\n\n"), srtTime.ReplaceAll(data, []byte("$1.$2"))...)
+}
+
+//按[Events]中Format指定的列读取Dialogue的开始、结束时间及文本，忽略样式
+func assToVtt(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n\n")
+	columns := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Format:") {
+			for i, c := range strings.Split(strings.TrimPrefix(line, "Format:"), ",") {
+				columns[strings.TrimSpace(c)] = i
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "Dialogue:") || len(columns) == 0 {
+			continue
+		}
+		textIndex, ok := columns["Text"]
+		if !ok {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimPrefix(line, "Dialogue:"), ",", textIndex+1)
+		if len(fields) <= textIndex {
+			continue
+		}
+		start, end := assTime(fields[columns["Start"]]), assTime(fields[columns["End"]])
+		text := assTags.ReplaceAllString(fields[textIndex], "")
+		text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+		fmt.Fprintf(&buf, "%s --> %s\n%s\n\n", start, end, strings.TrimSpace(text))
+	}
+	return buf.Bytes()
+}
+
+//ass时间格式h:mm:ss.cc转换为hh:mm:ss.mmm
+func assTime(t string) string {
+	var h, m, s, cs int
+	fmt.Sscanf(strings.TrimSpace(t), "%d:%d:%d.%d", &h, &m, &s, &cs)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, cs*10)
+}
@@ -0,0 +1,72 @@
+package service
+
+import "testing"
+
+func TestToVtt(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		want string
+	}{
+		{
+			"srt",
+			"a.srt",
+			"1\n00:00:01,500 --> 00:00:03,000\n你好\n\n2\n01:02:03,004 --> 01:02:05,000\nworld\n",
+			"WEBVTT\n\n1\n00:00:01.500 --> 00:00:03.000\n你好\n\n2\n01:02:03.004 --> 01:02:05.000\nworld\n",
+		},
+		{
+			"srt带BOM及CRLF",
+			"a.SRT",
+			"\xef\xbb\xbf1\r\n00:00:01,000 --> 00:00:02,000\r\nhi\r\n",
+			"WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.000\nhi\n",
+		},
+		{
+			"vtt原样返回",
+			"a.vtt",
+			"WEBVTT\n\n00:01.000 --> 00:02.000\nhi\n",
+			"WEBVTT\n\n00:01.000 --> 00:02.000\nhi\n",
+		},
+		{
+			"ass",
+			"a.ass",
+			"[Script Info]\nTitle: test\n\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+				"Comment: 0,0:00:00.00,0:00:01.00,Default,,0,0,0,,注释\n" +
+				"Dialogue: 0,0:00:01.50,0:00:03.05,Default,,0,0,0,,{\\b1}第一行\\N第二行, 含逗号\n",
+			"WEBVTT\n\n00:00:01.500 --> 00:00:03.050\n第一行\n第二行, 含逗号\n\n",
+		},
+		{
+			"ssa按Format读取列",
+			"a.ssa",
+			"[Events]\nFormat: Start, End, Text\nDialogue: 1:02:03.04,1:02:04.00,a\\hb\n",
+			"WEBVTT\n\n01:02:03.040 --> 01:02:04.000\na b\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(ToVtt(tt.file, []byte(tt.data))); got != tt.want {
+				t.Errorf("ToVtt(%s) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssToVtt(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"没有Format时忽略Dialogue", "Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,hi\n", "WEBVTT\n\n"},
+		{"Format没有Text列", "Format: Start, End\nDialogue: 0:00:01.00,0:00:02.00\n", "WEBVTT\n\n"},
+		{"列数不足", "Format: Start, End, Style, Text\nDialogue: 0:00:01.00,0:00:02.00\n", "WEBVTT\n\n"},
+		{"去除样式标签", "Format: Start, End, Text\nDialogue: 0:00:01.00,0:00:02.00,{\\an8}{\\c&H00FF00&}绿色\\n换行\n", "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\n绿色\n换行\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(assToVtt([]byte(tt.data))); got != tt.want {
+				t.Errorf("assToVtt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"PanIndex/drive"
+	"PanIndex/entity"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+//代理下载时透传的响应头
+var proxyHeaders = []string{"Content-Length", "Content-Type", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag"}
+
+//服务端代理下载，支持Range请求，客户端可以断点续传及拖动视频进度，返回实际下载地址的域名
+func ProxyDownload(account entity.Account, fileNode entity.FileNode, w http.ResponseWriter, r *http.Request) (string, error) {
+	if o, ok := drive.Get(account.Mode).(drive.Opener); ok {
+		return account.Api, serveOpener(o, account, fileNode, w, r)
+	}
+	req, err := downloadRequest(r.Context(), r.Method, account, fileNode)
+	if err != nil {
+		return "", err
+	}
+	for _, h := range []string{"Range", "If-Range", "User-Agent"} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return req.URL.Host, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		return req.URL.Host, errors.New("下载失败：" + resp.Status)
+	}
+	for _, h := range proxyHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	if w.Header().Get("Content-Type") == "" {
+		if contentType := mime.TypeByExtension(path.Ext(fileNode.FileName)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileNode.FileName}))
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, resp.Body)
+	return req.URL.Host, err
+}
+
+//通过网盘连接读取文件内容输出，Range请求由http.ServeContent处理
+func serveOpener(o drive.Opener, account entity.Account, fileNode entity.FileNode, w http.ResponseWriter, r *http.Request) error {
+	rs := &openerReader{size: fileNode.FileSize, open: func(offset int64) (io.ReadCloser, error) {
+		return o.Open(account, fileNode, offset)
+	}}
+	defer rs.Close()
+	if r.Method != http.MethodHead {
+		//先打开文件，文件不存在等错误可以返回给客户端，断点续传时从请求的位置打开
+		if m := rangeStart.FindStringSubmatch(r.Header.Get("Range")); m != nil {
+			rs.offset, _ = strconv.ParseInt(m[1], 10, 64)
+		}
+		if err := rs.reopen(); err != nil {
+			return err
+		}
+	}
+	contentType := mime.TypeByExtension(path.Ext(fileNode.FileName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileNode.FileName}))
+	modTime, _ := time.ParseInLocation("2006-01-02 15:04:05", fileNode.LastOpTime, time.Local)
+	http.ServeContent(w, r, fileNode.FileName, modTime, rs)
+	return rs.err
+}
+
+var rangeStart = regexp.MustCompile(`^bytes=(\d+)-`)
+
+//按需打开的文件，Seek只记录位置，读取的位置和已打开的连接不一致时重新打开，大小使用文件节点中的大小
+type openerReader struct {
+	open   func(offset int64) (io.ReadCloser, error)
+	size   int64
+	offset int64 //下次读取的位置
+	pos    int64 //已打开连接的读取位置
+	rc     io.ReadCloser
+	err    error //读取过程中的错误，不包括EOF
+}
+
+func (r *openerReader) reopen() error {
+	r.Close()
+	r.rc, r.err = r.open(r.offset)
+	r.pos = r.offset
+	return r.err
+}
+
+func (r *openerReader) Read(p []byte) (int, error) {
+	if r.rc == nil || r.pos != r.offset {
+		if err := r.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	r.pos = r.offset
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *openerReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("invalid offset")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *openerReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	if r.pos >= r.size && r.err == nil {
+		//http.ServeContent读取到文件大小就停止，再读一次到EOF，ftp等连接才能复用
+		var b [1]byte
+		r.rc.Read(b[:])
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
+
+//根据下载地址创建请求，附加网盘要求的请求头
+func downloadRequest(ctx context.Context, method string, account entity.Account, fileNode entity.FileNode) (*http.Request, error) {
+	downUrl := GetDownlaodUrl(account, fileNode)
+	if downUrl == "" {
+		return nil, errors.New("下载地址获取失败")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, downUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	if dh, ok := drive.Get(account.Mode).(drive.DownloadHeaderer); ok {
+		for k, v := range dh.DownloadHeader(account) {
+			req.Header.Set(k, v)
+		}
+	}
+	return req, nil
+}
+
+//读取文件内容，本地文件直接打开，网盘文件通过连接或下载地址读取
+func OpenFileContent(ctx context.Context, account entity.Account, fileNode entity.FileNode) (io.ReadCloser, error) {
+	if account.Mode == "native" {
+		return os.Open(fileNode.FileId)
+	}
+	if o, ok := drive.Get(account.Mode).(drive.Opener); ok {
+		return o.Open(account, fileNode, 0)
+	}
+	req, err := downloadRequest(ctx, http.MethodGet, account, fileNode)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		resp.Body.Close()
+		return nil, errors.New("下载失败：" + resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"PanIndex/drive"
+	"PanIndex/entity"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//下载地址指向测试服务器的网盘，代理时需要附加Referer
+type proxyDrive struct {
+	url string
+}
+
+func (d proxyDrive) Login(account entity.Account) (string, error) { return "", nil }
+
+func (d proxyDrive) List(account entity.Account, fileId, path string) ([]entity.FileNode, error) {
+	return nil, drive.ErrNotSupported
+}
+
+func (d proxyDrive) Walk(account entity.Account, fileId, path string) error { return nil }
+
+func (d proxyDrive) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	return d.url + fileNode.FileId, nil
+}
+
+func (d proxyDrive) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	return drive.ErrNotSupported
+}
+
+func (d proxyDrive) Mkdir(account entity.Account, parentId, name string) error {
+	return drive.ErrNotSupported
+}
+
+func (d proxyDrive) Delete(account entity.Account, fileNode entity.FileNode) error {
+	return drive.ErrNotSupported
+}
+
+func (d proxyDrive) Capabilities() drive.Capabilities { return drive.Capabilities{Cached: true} }
+
+func (d proxyDrive) DownloadHeader(account entity.Account) map[string]string {
+	return map[string]string{"Referer": "https://proxy.test/"}
+}
+
+func TestProxyDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Referer") != "https://proxy.test/" || r.URL.Path != "/a" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "a", time.Unix(1609459200, 0), strings.NewReader("0123456789"))
+	}))
+	defer srv.Close()
+	drive.Register("proxy-test", proxyDrive{url: srv.URL})
+	account := entity.Account{Id: "proxy-test", Mode: "proxy-test", DownProxy: 1}
+	tests := []struct {
+		name        string
+		method      string
+		fileId      string
+		rangeHeader string
+		wantCode    int
+		wantBody    string
+		wantHeaders map[string]string
+	}{
+		{"完整下载", http.MethodGet, "/a", "", http.StatusOK, "0123456789",
+			map[string]string{"Content-Length": "10", "Accept-Ranges": "bytes", "Content-Disposition": "attachment; filename*=utf-8''%E6%88%91%E7%9A%84%20%E6%96%87%E4%BB%B6.txt"}},
+		{"断点续传", http.MethodGet, "/a", "bytes=2-5", http.StatusPartialContent, "2345",
+			map[string]string{"Content-Range": "bytes 2-5/10", "Content-Length": "4"}},
+		{"从指定位置到结尾", http.MethodGet, "/a", "bytes=7-", http.StatusPartialContent, "789",
+			map[string]string{"Content-Range": "bytes 7-9/10"}},
+		{"超出文件大小", http.MethodGet, "/a", "bytes=20-", http.StatusRequestedRangeNotSatisfiable, "invalid range: failed to overlap\n",
+			map[string]string{"Content-Range": "bytes */10"}},
+		{"HEAD", http.MethodHead, "/a", "", http.StatusOK, "",
+			map[string]string{"Content-Length": "10", "Last-Modified": "Fri, 01 Jan 2021 00:00:00 GMT"}},
+		{"下载失败时不输出", http.MethodGet, "/missing", "", http.StatusOK, "",
+			map[string]string{"Content-Disposition": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/d/a", nil)
+			if tt.rangeHeader != "" {
+				r.Header.Set("Range", tt.rangeHeader)
+			}
+			ProxyDownload(account, entity.FileNode{FileId: tt.fileId, FileName: "我的 文件.txt"}, w, r)
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("ProxyDownload() = %d %q, want %d %q", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+			for k, v := range tt.wantHeaders {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"PanIndex/config"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"fmt"
+	"strings"
+)
+
+//搜索条件，零值表示不限
+type SearchParams struct {
+	Key        string
+	AccountIds []string //为空时搜索所有账号
+	FileTypes  []string //扩展名，不含.
+	MediaType  int      //1图片，2音频，3视频，4文本文档
+	MinSize    int64
+	MaxSize    int64
+	From       string //修改时间起，yyyy-MM-dd
+	To         string //修改时间止，yyyy-MM-dd
+	Scope      string //目录范围，只搜索该目录下的文件
+	Page       int
+	Size       int
+	User       entity.User //当前用户，只返回有浏览权限的文件
+}
+
+//跨账号搜索结果
+type SearchResult struct {
+	entity.FileNode
+	AccountName string `json:"accountName"`
+	Url         string `json:"url"` //页面访问地址，文件地址包含签名
+}
+
+//搜索文件，优先使用全文索引，没有结果时退回like查询以支持任意子串
+func SearchFiles(p SearchParams) ([]entity.FileNode, int64) {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.Size < 1 {
+		p.Size = 50
+	}
+	list := []entity.FileNode{}
+	var total int64
+	if match := model.SearchMatch(p.Key); model.FtsEnabled && match != "" {
+		total = searchQuery(p, "join file_node_fts t on t.id = f.id and t.name match ?", match, &list)
+	}
+	if total == 0 {
+		total = searchQuery(p, "", nil, &list)
+	}
+	return list, total
+}
+
+func searchQuery(p SearchParams, join string, joinArg interface{}, list *[]entity.FileNode) int64 {
+	where := []string{"f.`delete` = 0", "f.hide = 0"}
+	args := []interface{}{}
+	if joinArg != nil {
+		args = append(args, joinArg)
+	} else if p.Key != "" {
+		where = append(where, "f.file_name like ?")
+		args = append(args, "%"+p.Key+"%")
+	}
+	if len(p.AccountIds) > 0 {
+		where = append(where, "f.account_id in ?")
+		args = append(args, p.AccountIds)
+	}
+	if len(p.FileTypes) > 0 {
+		where = append(where, "lower(f.file_type) in ?")
+		args = append(args, p.FileTypes)
+	}
+	if p.MediaType > 0 {
+		where = append(where, "f.media_type = ? and f.is_folder = 0")
+		args = append(args, p.MediaType)
+	}
+	if p.MinSize > 0 {
+		where = append(where, "f.file_size >= ? and f.is_folder = 0")
+		args = append(args, p.MinSize)
+	}
+	if p.MaxSize > 0 {
+		where = append(where, "f.file_size <= ? and f.is_folder = 0")
+		args = append(args, p.MaxSize)
+	}
+	if p.From != "" {
+		where = append(where, "f.last_op_time >= ?")
+		args = append(args, p.From)
+	}
+	if p.To != "" {
+		//包含结束当天
+		where = append(where, "f.last_op_time < ?")
+		args = append(args, p.To+"~")
+	}
+	if scope := "/" + strings.Trim(p.Scope, "/"); scope != "/" {
+		where = append(where, "substr(f.path, 1, ?) = ?")
+		args = append(args, len([]rune(scope))+1, scope+"/")
+	}
+	from := fmt.Sprintf("from file_node f %s where %s", join, strings.Join(where, " and "))
+	order := " order by f.is_folder desc, f.last_op_time desc"
+	if searchFiltered(p) {
+		//先按访问控制规则过滤，再计算总数和分页，避免暴露无权浏览的文件数量
+		all := []entity.FileNode{}
+		model.SqliteDb.Raw("select f.* "+from+order, args...).Find(&all)
+		all = FilterReadable(p.User, all)
+		start := GetPageStart(p.Page, p.Size)
+		if start < len(all) {
+			end := start + p.Size
+			if end > len(all) {
+				end = len(all)
+			}
+			*list = all[start:end]
+		}
+		return int64(len(all))
+	}
+	var total int64
+	model.SqliteDb.Raw("select count(*) "+from, args...).Scan(&total)
+	if total > 0 {
+		args = append(args, p.Size, GetPageStart(p.Page, p.Size))
+		model.SqliteDb.Raw("select f.* "+from+order+" limit ? offset ?", args...).Find(list)
+	}
+	return total
+}
+
+//非管理员且搜索的账号有禁止浏览的规则时，需要逐个校验权限
+func searchFiltered(p SearchParams) bool {
+	if p.User.Role == "admin" {
+		return false
+	}
+	for _, acl := range config.GloablConfig.Acls {
+		if acl.Read == 1 {
+			continue
+		}
+		if len(p.AccountIds) == 0 {
+			return true
+		}
+		for _, id := range p.AccountIds {
+			if id == acl.AccountId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//补充所属账号及访问地址
+func SearchResults(list []entity.FileNode) []SearchResult {
+	results := []SearchResult{}
+	for _, fn := range list {
+		for _, account := range config.GloablConfig.Accounts {
+			if account.Id == fn.AccountId {
+				result := SearchResult{FileNode: fn, AccountName: account.Name, Url: PageUrl(account.Id, fn.Path)}
+				if !fn.IsFolder {
+					result.Url += SignQuery(account.Id, fn.Path)
+				}
+				results = append(results, result)
+				break
+			}
+		}
+	}
+	return results
+}
+
+//文件在页面中的访问地址，非第一个账号需要加上/d_序号前缀
+func PageUrl(accountId, path string) string {
+	for i, account := range config.GloablConfig.Accounts {
+		if account.Id == accountId && i > 0 {
+			return fmt.Sprintf("/d_%d%s", i, path)
+		}
+	}
+	return path
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"PanIndex/config"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"reflect"
+	"testing"
+)
+
+func TestSearchFiles(t *testing.T) {
+	initTestDb(t)
+	addNodes("s1",
+		entity.FileNode{Path: "/docs", FileName: "docs", IsFolder: true, LastOpTime: "2021-01-03 00:00:00"},
+		entity.FileNode{Path: "/docs/报告2021.pdf", FileName: "报告2021.pdf", FileType: "pdf", FileSize: 2 << 20, LastOpTime: "2021-01-05 00:00:00"},
+		entity.FileNode{Path: "/docs/report.docx", FileName: "report.docx", FileType: "docx", FileSize: 10 << 10, LastOpTime: "2021-02-01 12:00:00"},
+		entity.FileNode{Path: "/pics", FileName: "pics", IsFolder: true, LastOpTime: "2021-03-01 00:00:00"},
+		entity.FileNode{Path: "/pics/cat.jpg", FileName: "cat.jpg", FileType: "JPG", MediaType: 1, FileSize: 500 << 10, LastOpTime: "2021-03-02 00:00:00"},
+		entity.FileNode{Path: "/pics/hidden.jpg", FileName: "hidden.jpg", FileType: "jpg", MediaType: 1, LastOpTime: "2021-03-03 00:00:00", Hide: 1},
+	)
+	addNodes("s2",
+		entity.FileNode{Path: "/report-final.txt", FileName: "report-final.txt", FileType: "txt", FileSize: 100, LastOpTime: "2021-01-10 00:00:00"},
+	)
+	model.RebuildSearchIndex("s1")
+	model.RebuildSearchIndex("s2")
+	tests := []struct {
+		name      string
+		params    SearchParams
+		want      []string
+		wantTotal int64
+	}{
+		{"中文逐字匹配", SearchParams{Key: "报告"}, []string{"/docs/报告2021.pdf"}, 1},
+		{"跨账号，按修改时间倒序", SearchParams{Key: "report"}, []string{"/docs/report.docx", "/report-final.txt"}, 2},
+		{"全文索引没有结果时模糊匹配", SearchParams{Key: "epor"}, []string{"/docs/report.docx", "/report-final.txt"}, 2},
+		{"指定账号", SearchParams{Key: "report", AccountIds: []string{"s2"}}, []string{"/report-final.txt"}, 1},
+		{"扩展名", SearchParams{FileTypes: []string{"pdf", "docx"}}, []string{"/docs/report.docx", "/docs/报告2021.pdf"}, 2},
+		{"扩展名不区分大小写", SearchParams{FileTypes: []string{"jpg"}}, []string{"/pics/cat.jpg"}, 1},
+		{"媒体类型", SearchParams{MediaType: 1}, []string{"/pics/cat.jpg"}, 1},
+		{"最小大小不包括目录", SearchParams{MinSize: 1 << 20}, []string{"/docs/报告2021.pdf"}, 1},
+		{"最大大小不包括目录", SearchParams{MaxSize: 100}, []string{"/report-final.txt"}, 1},
+		{"修改时间包含结束当天", SearchParams{From: "2021-02-01", To: "2021-02-01"}, []string{"/docs/report.docx"}, 1},
+		{"目录范围不包括目录本身", SearchParams{Scope: "/docs/"}, []string{"/docs/report.docx", "/docs/报告2021.pdf"}, 2},
+		{"目录优先", SearchParams{AccountIds: []string{"s1"}, Size: 2}, []string{"/pics", "/docs"}, 5},
+		{"分页", SearchParams{AccountIds: []string{"s1"}, Page: 2, Size: 2}, []string{"/pics/cat.jpg", "/docs/report.docx"}, 5},
+		{"超出页数", SearchParams{AccountIds: []string{"s1"}, Page: 4, Size: 2}, []string{}, 5},
+		{"没有结果", SearchParams{Key: "nothing"}, []string{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, total := SearchFiles(tt.params)
+			if got := paths(list); !reflect.DeepEqual(got, tt.want) || total != tt.wantTotal {
+				t.Errorf("SearchFiles() = %v, %d, want %v, %d", got, total, tt.want, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestSearchFilesAcl(t *testing.T) {
+	initTestDb(t)
+	old := config.GloablConfig.Acls
+	defer func() { config.GloablConfig.Acls = old }()
+	//访客不能浏览/secret，a2没有规则
+	config.GloablConfig.Acls = []entity.Acl{{AccountId: "a1", Path: "/secret", Role: "guest"}}
+	addNodes("a1",
+		entity.FileNode{Path: "/secret", FileName: "secret", IsFolder: true, LastOpTime: "2021-01-09 00:00:00"},
+		entity.FileNode{Path: "/secret/x1.txt", FileName: "x1.txt", LastOpTime: "2021-01-08 00:00:00"},
+		entity.FileNode{Path: "/secret/x2.txt", FileName: "x2.txt", LastOpTime: "2021-01-07 00:00:00"},
+		entity.FileNode{Path: "/x3.txt", FileName: "x3.txt", LastOpTime: "2021-01-06 00:00:00"},
+		entity.FileNode{Path: "/x4.txt", FileName: "x4.txt", LastOpTime: "2021-01-05 00:00:00"},
+	)
+	addNodes("a2", entity.FileNode{Path: "/x5.txt", FileName: "x5.txt", LastOpTime: "2021-01-04 00:00:00"})
+	admin := entity.User{Id: "admin", Role: "admin"}
+	tests := []struct {
+		name      string
+		params    SearchParams
+		want      []string
+		wantTotal int64
+	}{
+		{"访客第1页", SearchParams{Key: "x", User: Guest, Size: 2}, []string{"/x3.txt", "/x4.txt"}, 3},
+		{"访客第2页", SearchParams{Key: "x", User: Guest, Page: 2, Size: 2}, []string{"/x5.txt"}, 3},
+		{"访客超出页数", SearchParams{Key: "x", User: Guest, Page: 3, Size: 2}, []string{}, 3},
+		{"没有禁止规则的账号", SearchParams{Key: "x", User: Guest, AccountIds: []string{"a2"}}, []string{"/x5.txt"}, 1},
+		{"管理员不过滤", SearchParams{Key: "x", User: admin, Size: 2}, []string{"/secret/x1.txt", "/secret/x2.txt"}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, total := SearchFiles(tt.params)
+			if got := paths(list); !reflect.DeepEqual(got, tt.want) || total != tt.wantTotal {
+				t.Errorf("SearchFiles() = %v, %d, want %v, %d", got, total, tt.want, tt.wantTotal)
+			}
+		})
+	}
+}
@@ -3,233 +3,316 @@ package service
 import (
 	"PanIndex/Util"
 	"PanIndex/config"
+	"PanIndex/drive"
 	"PanIndex/entity"
 	"PanIndex/jobs"
+	"PanIndex/metrics"
 	"PanIndex/model"
+	"PanIndex/notify"
+	"context"
 	"errors"
+	"fmt"
 	"github.com/bluele/gcache"
-	"github.com/eddieivan01/nic"
 	"github.com/gin-gonic/gin"
 	jsoniter "github.com/json-iterator/go"
 	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
-	"time"
 )
 
-func GetFilesByPath(account entity.Account, path, pwd string) map[string]interface{} {
+func GetFilesByPath(account entity.Account, path, pwd string, p ListParams) map[string]interface{} {
 	if path == "" {
 		path = "/"
 	}
 	result := make(map[string]interface{})
-	list := []entity.FileNode{}
 	defer func() {
 		if p := recover(); p != nil {
 			log.Errorln(p)
 		}
 	}()
+	list, total, isFile, pwdFileId := ListFilesPage(account, path, pwd, p)
+	filesResult(result, account, path, p, list, total, isFile, pwdFileId)
+	return result
+}
+
+//目录页面的模板数据
+func filesResult(result map[string]interface{}, account entity.Account, path string, p ListParams, list []entity.FileNode, total int, isFile bool, pwdFileId string) {
 	result["HasReadme"] = false
-	if account.Mode == "native" {
-		//列出文件夹相对路径
-		rootPath := account.RootId
-		fullPath := filepath.Join(rootPath, path)
-		if Util.FileExist(fullPath) {
-			if Util.IsDirectory(fullPath) {
-				//是目录
-				// 读取该文件夹下所有文件
-				fileInfos, err := ioutil.ReadDir(fullPath)
-				//默认按照目录，时间倒序排列
-				sort.Slice(fileInfos, func(i, j int) bool {
-					d1 := 0
-					if fileInfos[i].IsDir() {
-						d1 = 1
-					}
-					d2 := 0
-					if fileInfos[j].IsDir() {
-						d2 = 1
-					}
-					if d1 > d2 {
-						return true
-					} else if d1 == d2 {
-						return fileInfos[i].ModTime().After(fileInfos[j].ModTime())
-					} else {
-						return false
-					}
-				})
-				if err != nil {
-					panic(err.Error())
-				} else {
-					for _, fileInfo := range fileInfos {
-						fileId := filepath.Join(fullPath, fileInfo.Name())
-						// 当前文件是隐藏文件(以.开头)则不显示
-						if Util.IsHiddenFile(fileInfo.Name()) {
-							continue
-						}
-						if fileInfo.Name() == "README.md" {
-							result["HasReadme"] = true
-							result["ReadmeContent"] = Util.ReadStringByFile(fileId)
-						}
-						//指定隐藏的文件或目录过滤
-						if config.GloablConfig.HideFileId != "" {
-							listSTring := strings.Split(config.GloablConfig.HideFileId, ",")
-							sort.Strings(listSTring)
-							i := sort.SearchStrings(listSTring, fileId)
-							if i < len(listSTring) && listSTring[i] == fileId {
-								continue
-							}
-						}
-						fileType := Util.GetMimeType(fileInfo)
-						// 实例化FileNode
-						file := entity.FileNode{
-							FileId:     fileId,
-							IsFolder:   fileInfo.IsDir(),
-							FileName:   fileInfo.Name(),
-							FileSize:   int64(fileInfo.Size()),
-							SizeFmt:    Util.FormatFileSize(int64(fileInfo.Size())),
-							FileType:   strings.TrimLeft(filepath.Ext(fileInfo.Name()), "."),
-							Path:       filepath.Join(path, fileInfo.Name()),
-							MediaType:  fileType,
-							LastOpTime: time.Unix(fileInfo.ModTime().Unix(), 0).Format("2006-01-02 15:04:05"),
-						}
-						// 添加到切片中等待json序列化
-						list = append(list, file)
-					}
-				}
-				result["isFile"] = false
-				result["HasPwd"] = false
-				PwdDirIds := config.GloablConfig.PwdDirId
-				for _, pdi := range strings.Split(PwdDirIds, ",") {
-					if strings.Split(pdi, ":")[0] == fullPath && pwd != strings.Split(pdi, ":")[1] {
-						result["HasPwd"] = true
-						result["FileId"] = fullPath
-					}
-				}
-			} else {
-				//是文件
-				fileInfo, err := os.Stat(fullPath)
-				if err != nil {
-					panic(err.Error())
-				} else {
-					fileType := Util.GetMimeType(fileInfo)
-					file := entity.FileNode{
-						FileId:     fullPath,
-						IsFolder:   fileInfo.IsDir(),
-						FileName:   fileInfo.Name(),
-						FileSize:   int64(fileInfo.Size()),
-						SizeFmt:    Util.FormatFileSize(int64(fileInfo.Size())),
-						FileType:   strings.TrimLeft(filepath.Ext(fileInfo.Name()), "."),
-						Path:       filepath.Join(path, fileInfo.Name()),
-						MediaType:  fileType,
-						LastOpTime: time.Unix(fileInfo.ModTime().Unix(), 0).Format("2006-01-02 15:04:05"),
+	if !isFile && pwdFileId == "" {
+		if readme, ok := findReadme(account, path, list); ok {
+			result["HasReadme"] = true
+			result["ReadmeContent"] = readmeContent(account, readme)
+		}
+	}
+	result["isFile"] = isFile
+	result["HasPwd"] = pwdFileId != ""
+	if pwdFileId != "" {
+		result["FileId"] = pwdFileId
+	}
+	result["List"] = list
+	result["Path"] = path
+	if path == "/" {
+		result["HasParent"] = false
+	} else {
+		result["HasParent"] = true
+	}
+	result["ParentPath"] = PetParentPath(path)
+	result["SurportFolderDown"] = drive.CapabilitiesOf(account).FolderDownload
+	result["Total"] = total
+	result["PageNo"] = p.Page
+	result["TotalPage"] = GetTotalPage(total, p.Size)
+	result["Sort"] = p.sortKey()
+	result["Order"] = p.order()
+}
+
+//目录列表的分页及排序参数，Size为0时不分页
+type ListParams struct {
+	Page  int
+	Size  int
+	Sort  string //name、size、time、type，默认time
+	Order string //asc、desc，默认按时间倒序，其他按正序
+}
+
+//排序字段对应的列，同时用于校验参数
+var sortColumns = map[string]string{"name": "file_name", "size": "file_size", "time": "last_op_time", "type": "file_type"}
+
+func (p ListParams) sortKey() string {
+	if _, ok := sortColumns[p.Sort]; ok {
+		return p.Sort
+	}
+	return "time"
+}
+
+func (p ListParams) order() string {
+	if p.Order == "asc" || p.Order == "desc" {
+		return p.Order
+	}
+	if p.sortKey() == "time" {
+		return "desc"
+	}
+	return "asc"
+}
+
+//列出路径下的所有文件，不分页
+func ListFiles(account entity.Account, path, pwd string) (list []entity.FileNode, isFile bool, pwdFileId string) {
+	list, _, isFile, pwdFileId = ListFilesPage(account, path, pwd, ListParams{})
+	return
+}
+
+//分页列出路径下的文件，目录始终在前，total为总数；路径是文件时isFile为true并返回该文件
+//缓存模式在查询时排序分页，实时模式读取目录后排序分页
+//目录设置了访问密码且pwd不正确时，返回该目录的fileId
+func ListFilesPage(account entity.Account, path, pwd string, p ListParams) (list []entity.FileNode, total int, isFile bool, pwdFileId string) {
+	list, total, isFile, folderId := listFilesPage(account, path, p)
+	if lockedDirs(pwd)[folderId] {
+		pwdFileId = folderId
+	}
+	return
+}
+
+//分页列出路径下的文件，不校验访问密码，folderId为目录的fileId
+func listFilesPage(account entity.Account, path string, p ListParams) (list []entity.FileNode, total int, isFile bool, folderId string) {
+	list = []entity.FileNode{}
+	d := drive.Get(account.Mode)
+	if d == nil {
+		log.Warningf("[%s]不支持的网盘模式：%s", account.Name, account.Mode)
+		return
+	} else if !drive.CapabilitiesOf(account).Cached {
+		//实时读取目录，fileId为完整路径
+		fullPath := filepath.Join(account.RootId, path)
+		fs, err := d.List(account, fullPath, path)
+		if err == nil {
+			sortFiles(fs, p)
+			total = len(fs)
+			list = pageOf(fs, p)
+			folderId = fullPath
+		} else if path != "/" {
+			//不是目录，从上级目录中查找文件
+			parentPath := PetParentPath(path)
+			fs, err = d.List(account, filepath.Join(account.RootId, parentPath), parentPath)
+			if err == nil {
+				for _, fn := range fs {
+					if !fn.IsFolder && fn.Path == path {
+						list = append(list, fn)
+						total = 1
+						isFile = true
+						break
 					}
-					// 添加到切片中等待json序列化
-					list = append(list, file)
 				}
-				result["isFile"] = true
 			}
 		}
 	} else {
-		model.SqliteDb.Raw("select * from file_node where parent_path=? and `delete`=0 and hide = 0 and account_id=?", path, account.Id).Find(&list)
-		result["isFile"] = false
-		if len(list) == 0 {
-			result["isFile"] = true
+		where := "from file_node where parent_path=? and `delete`=0 and hide = 0 and account_id=?"
+		var count int64
+		model.SqliteDb.Raw("select count(*) "+where, path, account.Id).Scan(&count)
+		total = int(count)
+		if total == 0 {
+			isFile = true
 			model.SqliteDb.Raw("select * from file_node where path = ? and is_folder = 0 and `delete`=0 and hide = 0 and account_id=? limit 1", path, account.Id).Find(&list)
+			total = len(list)
 		} else {
-			readmeFile := entity.FileNode{}
-			model.SqliteDb.Raw("select * from file_node where parent_path=? and file_name=? and `delete`=0 and account_id=?", path, "README.md", account.Id).Find(&readmeFile)
-			if !readmeFile.IsFolder && readmeFile.FileName == "README.md" {
-				result["HasReadme"] = true
-				result["ReadmeContent"] = Util.ReadStringByUrl(GetDownlaodUrl(account, readmeFile), readmeFile.FileId)
+			args := []interface{}{path, account.Id}
+			sql := fmt.Sprintf("select * %s order by is_folder desc, %s %s", where, sortColumns[p.sortKey()], p.order())
+			if p.Size > 0 {
+				sql += " limit ? offset ?"
+				args = append(args, p.Size, GetPageStart(p.Page, p.Size))
 			}
+			model.SqliteDb.Raw(sql, args...).Find(&list)
 		}
-		result["HasPwd"] = false
 		fileNode := entity.FileNode{}
 		model.SqliteDb.Raw("select * from file_node where path = ? and is_folder = 1 and `delete`=0 and account_id = ?", path, account.Id).First(&fileNode)
-		PwdDirIds := config.GloablConfig.PwdDirId
-		for _, pdi := range strings.Split(PwdDirIds, ",") {
-			if pdi != "" {
-				if strings.Split(pdi, ":")[0] == fileNode.FileId && pwd != strings.Split(pdi, ":")[1] {
-					result["HasPwd"] = true
-					result["FileId"] = fileNode.FileId
-				}
-			}
+		folderId = fileNode.FileId
+	}
+	return
+}
+
+//密码与pwd不一致的加密目录
+func lockedDirs(pwd string) map[string]bool {
+	locked := map[string]bool{}
+	for _, pdi := range strings.Split(config.GloablConfig.PwdDirId, ",") {
+		if kv := strings.Split(pdi, ":"); len(kv) == 2 && kv[1] != pwd {
+			locked[kv[0]] = true
 		}
 	}
-	result["List"] = list
-	result["Path"] = path
+	return locked
+}
+
+//根据路径查找文件（夹），从上级目录中查找，因此会校验上级目录的访问密码
+//根目录返回以账号名称命名的目录
+func FindFile(account entity.Account, path, pwd string) (fileNode entity.FileNode, found bool, pwdFileId string) {
+	fileNode, found, parentId := findFile(account, path)
+	if lockedDirs(pwd)[parentId] {
+		return entity.FileNode{}, false, parentId
+	}
+	return
+}
+
+//根据路径查找文件（夹），不校验访问密码，parentId为上级目录的fileId
+func findFile(account entity.Account, path string) (entity.FileNode, bool, string) {
 	if path == "/" {
-		result["HasParent"] = false
-	} else {
-		result["HasParent"] = true
+		return entity.FileNode{AccountId: account.Id, FileId: account.RootId, FileName: account.Name, IsFolder: true, Path: "/", SizeFmt: "-"}, true, ""
 	}
-	result["ParentPath"] = PetParentPath(path)
-	if account.Mode == "cloud189" || account.Mode == "native" {
-		result["SurportFolderDown"] = true
-	} else {
-		result["SurportFolderDown"] = false
+	list, _, isFile, parentId := listFilesPage(account, PetParentPath(path), ListParams{})
+	if !isFile {
+		for _, fn := range list {
+			if fn.Path == path {
+				return fn, true, parentId
+			}
+		}
 	}
-	return result
+	return entity.FileNode{}, false, parentId
 }
 
-func SearchFilesByKey(account entity.Account, key string) map[string]interface{} {
-	result := make(map[string]interface{})
-	list := []entity.FileNode{}
-	defer func() {
-		if p := recover(); p != nil {
-			log.Errorln(p)
+//排序，目录始终在前，排序字段相同时保持原有顺序
+func sortFiles(list []entity.FileNode, p ListParams) {
+	key, desc := p.sortKey(), p.order() == "desc"
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].IsFolder != list[j].IsFolder {
+			return list[i].IsFolder
 		}
-	}()
+		a, b := list[i], list[j]
+		if desc {
+			a, b = b, a
+		}
+		switch key {
+		case "name":
+			return a.FileName < b.FileName
+		case "size":
+			return a.FileSize < b.FileSize
+		case "type":
+			return a.FileType < b.FileType
+		}
+		return a.LastOpTime < b.LastOpTime
+	})
+}
+
+//取出当前页，Size为0时返回全部
+func pageOf(list []entity.FileNode, p ListParams) []entity.FileNode {
+	if p.Size <= 0 {
+		return list
+	}
+	start := GetPageStart(p.Page, p.Size)
+	if start >= len(list) {
+		return []entity.FileNode{}
+	}
+	end := start + p.Size
+	if end > len(list) {
+		end = len(list)
+	}
+	return list[start:end]
+}
+
+//查找目录下的README.md，不在当前页时单独查询
+func findReadme(account entity.Account, path string, list []entity.FileNode) (entity.FileNode, bool) {
+	for _, fn := range list {
+		if !fn.IsFolder && fn.FileName == "README.md" {
+			return fn, true
+		}
+	}
+	readme := entity.FileNode{}
 	if account.Mode == "native" {
-		list = Util.FileSearch(account.RootId, "", key)
-	} else {
-		model.SqliteDb.Raw("select * from file_node where file_name like ? and `delete`=0 and hide = 0 and account_id=?", "%"+key+"%", account.Id).Find(&list)
+		readme.FileId = filepath.Join(account.RootId, path, "README.md")
+		return readme, Util.IsFile(readme.FileId)
 	}
+	result := model.SqliteDb.Raw("select * from file_node where parent_path=? and file_name='README.md' and is_folder=0 and `delete`=0 and hide = 0 and account_id=? limit 1", path, account.Id).Take(&readme)
+	return readme, result.Error == nil
+}
+
+//读取README.md内容，本地模式直接读文件，sftp等没有下载地址的模式通过连接读取，其他模式通过下载地址读取（有缓存）
+func readmeContent(account entity.Account, readmeFile entity.FileNode) string {
+	if account.Mode == "native" {
+		return Util.ReadStringByFile(readmeFile.FileId)
+	}
+	if _, ok := drive.Get(account.Mode).(drive.Opener); ok {
+		rc, err := OpenFileContent(context.Background(), account, readmeFile)
+		if err != nil {
+			log.Errorln(err)
+			return ""
+		}
+		defer rc.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(rc, 1<<20))
+		if err != nil {
+			log.Errorln(err)
+		}
+		return string(data)
+	}
+	return Util.ReadStringByUrl(GetDownlaodUrl(account, readmeFile), readmeFile.FileId)
+}
+
+func SearchFilesByKey(account entity.Account, params SearchParams) map[string]interface{} {
+	result := make(map[string]interface{})
+	params.AccountIds = []string{account.Id}
+	list, total := SearchFiles(params)
 	result["List"] = list
+	result["Total"] = total
 	result["Path"] = "/"
 	result["HasParent"] = false
 	result["ParentPath"] = PetParentPath("/")
-	if account.Mode == "cloud189" || account.Mode == "native" {
-		result["SurportFolderDown"] = true
-	} else {
-		result["SurportFolderDown"] = false
-	}
+	result["SurportFolderDown"] = drive.CapabilitiesOf(account).FolderDownload
 	return result
 }
 
 func GetDownlaodUrl(account entity.Account, fileNode entity.FileNode) string {
-	if account.Mode == "cloud189" {
-		return Util.GetDownlaodUrl(account.Id, fileNode.FileIdDigest)
-	} else if account.Mode == "teambition" {
-		if Util.TeambitionSessions[account.Id].IsPorject {
-			return Util.GetTeambitionProDownUrl("www", account.Id, fileNode.FileId)
-		} else {
-			return Util.GetTeambitionDownUrl(account.Id, fileNode.FileId)
-		}
-	} else if account.Mode == "teambition-us" {
-		if Util.TeambitionSessions[account.Id].IsPorject {
-			return Util.GetTeambitionProDownUrl("us", account.Id, fileNode.FileId)
-		} else {
-			//国际版暂时没有个人文件
-		}
-	} else if account.Mode == "aliyundrive" {
-		return Util.AliGetDownloadUrl(account.Id, fileNode.FileId)
-	} else if account.Mode == "native" {
+	d := drive.Get(account.Mode)
+	if d == nil {
+		return ""
 	}
-	return ""
-}
-
-func GetDownlaodMultiFiles(accountId, fileId string) string {
-	return Util.GetDownlaodMultiFiles(accountId, fileId)
+	downUrl, err := d.DownloadURL(account, fileNode)
+	if err != nil {
+		log.Warningln(err)
+	}
+	return downUrl
 }
 
 func GetPath(accountId, fileId string) string {
 	fileNode := entity.FileNode{}
-	model.SqliteDb.Raw("select * from file_node where account_id = ? and file_id = ? and delete = 0 limit 1", accountId, fileId).Find(&fileNode)
+	model.SqliteDb.Raw("select * from file_node where account_id = ? and file_id = ? and `delete` = 0 limit 1", accountId, fileId).Find(&fileNode)
 	return fileNode.Path
 }
 
@@ -277,23 +360,12 @@ func GetTotalPage(totalCount, pageSize int) int {
 //刷新目录缓存
 func UpdateFolderCache(account entity.Account) {
 	Util.GC = gcache.New(10).LRU().Build()
-	model.SqliteDb.Delete(entity.FileNode{})
-	if account.Mode == "cloud189" {
-		Util.Cloud189GetFiles(account.Id, account.RootId, account.RootId, "")
-	} else if account.Mode == "teambition" {
-		Util.TeambitionGetFiles(account.Id, account.RootId, account.RootId, "/")
-	} else if account.Mode == "native" {
-	}
+	jobs.SyncOneAccount(account)
 }
 
 //刷新登录cookie
 func RefreshCookie(account entity.Account) {
-	if account.Mode == "cloud189" {
-		Util.Cloud189Login(account.Id, account.User, account.Password)
-	} else if account.Mode == "teambition" {
-		Util.TeambitionLogin(account.Id, account.User, account.Password)
-	} else if account.Mode == "native" {
-	}
+	jobs.AccountLogin(account)
 }
 func IsDirectory(filename string) bool {
 	info, err := os.Stat(filename)
@@ -318,6 +390,9 @@ func GetConfig() entity.Config {
 	model.SqliteDb.Raw("select * from config where 1=1 limit 1").Find(&c)
 	model.SqliteDb.Raw("select * from account order by `default`desc").Find(&accounts)
 	model.SqliteDb.Raw("select * from damagou where 1-1 limit 1").Find(&damagou)
+	model.SqliteDb.Raw("select * from user order by role, name").Find(&c.Users)
+	model.SqliteDb.Raw("select * from acl order by account_id, path").Find(&c.Acls)
+	c.Shares = ActiveShares("")
 	c.Accounts = accounts
 	c.Damagou = damagou
 	config.GloablConfig = c
@@ -328,6 +403,10 @@ func SaveConfig(config map[string]interface{}) {
 	if config["accounts"] == nil {
 		//基本配置
 		model.SqliteDb.Table("config").Where("1 = 1").Updates(config)
+		if adminPassword, ok := config["admin_password"].(string); ok && adminPassword != "" {
+			//后台登录密码即admin用户的密码
+			ResetAdminPassword(adminPassword)
+		}
 		if config["hide_file_id"] != nil {
 			hideFiles := config["hide_file_id"].(string)
 			if hideFiles != "" {
@@ -340,7 +419,7 @@ func SaveConfig(config map[string]interface{}) {
 	} else {
 		//账号信息
 		for _, account := range config["accounts"].([]interface{}) {
-			mode := account.(map[string]interface{})["Mode"]
+			mode := account.(map[string]interface{})["mode"]
 			ID := ""
 			if account.(map[string]interface{})["id"] != nil && account.(map[string]interface{})["id"] != "" {
 				old := entity.Account{}
@@ -351,15 +430,14 @@ func SaveConfig(config map[string]interface{}) {
 				//更新网盘账号
 				model.SqliteDb.Table("account").Where("id = ?", account.(map[string]interface{})["id"]).Updates(account.(map[string]interface{}))
 				if mode != old.Mode {
+					//模式变更，清除旧的登录状态
 					delete(Util.CLoud189Sessions, old.Id)
 					delete(Util.TeambitionSessions, old.Id)
-					if mode == "cloud189" {
-						Util.CLoud189Sessions[old.Id] = nic.Session{}
-					} else if mode == "teambition" {
-						Util.TeambitionSessions[old.Id] = entity.Teambition{nic.Session{}, "", "", "", "", "", false}
-					} else if mode == "teambition-us" {
-						Util.TeambitionSessions[old.Id] = entity.Teambition{nic.Session{}, "", "", "", "", "", false}
-					}
+					delete(Util.Alis, old.Id)
+					Util.OneDrives.Delete(old.Id)
+					Util.GoogleDrives.Delete(old.Id)
+					Util.ClosePool(old.Id)
+					Util.WebDavDigests.Delete(old.Id)
 				}
 				ID = old.Id
 			} else {
@@ -371,13 +449,6 @@ func SaveConfig(config map[string]interface{}) {
 				account.(map[string]interface{})["cookie_status"] = 1
 				account.(map[string]interface{})["files_count"] = 0
 				model.SqliteDb.Table("account").Create(account.(map[string]interface{}))
-				if mode == "cloud189" {
-					Util.CLoud189Sessions[id] = nic.Session{}
-				} else if mode == "teambition" {
-					Util.TeambitionSessions[id] = entity.Teambition{nic.Session{}, "", "", "", "", "", false}
-				} else if mode == "teambition-us" {
-					Util.TeambitionSessions[id] = entity.Teambition{nic.Session{}, "", "", "", "", "", false}
-				}
 			}
 			ac := entity.Account{}
 			model.SqliteDb.Table("account").Where("id=?", ID).Take(&ac)
@@ -387,9 +458,23 @@ func SaveConfig(config map[string]interface{}) {
 	go GetConfig()
 	//其他（打码狗）
 }
+
+//账号最近的事件（时间倒序），以及最近一次失败的事件（没有失败时Id为0）
+func AccountEvents(accountId string, limit int) ([]entity.AccountEvent, entity.AccountEvent) {
+	events := []entity.AccountEvent{}
+	model.SqliteDb.Where("account_id=?", accountId).Order("id desc").Limit(limit).Find(&events)
+	lastError := entity.AccountEvent{}
+	model.SqliteDb.Where("account_id=? and success=?", accountId, false).Order("id desc").Limit(1).Find(&lastError)
+	return events, lastError
+}
+
 func DeleteAccount(id string) {
 	//删除账号对应节点数据
 	model.SqliteDb.Where("account_id = ?", id).Delete(entity.FileNode{})
+	model.DeleteSearchIndex(id)
+	model.SqliteDb.Where("account_id = ?", id).Delete(entity.AccountEvent{})
+	model.SqliteDb.Where("account_id = ?", id).Delete(entity.DownloadStat{})
+	metrics.SyncNodes.Delete(GetAccount(id).Name)
 	//删除账号数据
 	var a entity.Account
 	a.Id = id
@@ -397,6 +482,11 @@ func DeleteAccount(id string) {
 	go GetConfig()
 	delete(Util.CLoud189Sessions, id)
 	delete(Util.TeambitionSessions, id)
+	delete(Util.Alis, id)
+	Util.OneDrives.Delete(id)
+	Util.GoogleDrives.Delete(id)
+	Util.ClosePool(id)
+	Util.WebDavDigests.Delete(id)
 }
 func GetAccount(id string) entity.Account {
 	account := entity.Account{}
@@ -438,109 +528,121 @@ func EnvToConfig() {
 func Upload(accountId, path string, c *gin.Context) string {
 	form, _ := c.MultipartForm()
 	files := form.File["uploadFile"]
-	dbFile := entity.FileNode{}
 	account := entity.Account{}
 	result := model.SqliteDb.Raw("select * from account where id=?", accountId).Take(&account)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return "指定的账号不存在"
 	}
-	if account.Mode == "native" {
-		p := filepath.FromSlash(account.RootId + path)
-		if !Util.FileExist(p) {
-			return "指定的目录不存在"
+	d := drive.Get(account.Mode)
+	fileId, msg := uploadFolderId(account, path)
+	if msg != "" {
+		return msg
+	}
+	names := []string{}
+	for _, file := range files {
+		f, err := file.Open()
+		if err == nil {
+			err = d.Upload(account, fileId, file.Filename, file.Size, f)
+			f.Close()
 		}
-		//服务器本地模式
-		for _, file := range files {
-			log.Debugf("开始上传文件：%s，大小：%d", file.Filename, file.Size)
-			t1 := time.Now()
-			p = filepath.FromSlash(account.RootId + path + "/" + file.Filename)
-			c.SaveUploadedFile(file, p)
-			log.Debugf("文件：%s，上传成功，耗时：%s", file.Filename, Util.ShortDur(time.Now().Sub(t1)))
+		if err != nil {
+			log.Warningf("文件：%s，上传失败：%s", file.Filename, err.Error())
+			notify.Send(notify.NewEvent("upload", false, account.Id, account.Name, account.Mode, path, file.Filename+"："+err.Error()))
+			return "上传失败：" + err.Error()
 		}
-		return "上传成功"
-	} else {
-		if path == "/" {
-			result = model.SqliteDb.Raw("select * from file_node where parent_path=? and `delete`=0 and account_id=? limit 1", path, accountId).Take(&dbFile)
-		} else {
-			result = model.SqliteDb.Raw("select * from file_node where path=? and `delete`=0 and account_id=?", path, accountId).Take(&dbFile)
+		names = append(names, file.Filename)
+	}
+	notify.Send(notify.NewEvent("upload", true, account.Id, account.Name, account.Mode, path, strings.Join(names, "、")))
+	return "上传成功"
+}
+
+//获取上传目录的ID，网盘不支持上传或目录不存在时返回错误信息
+func uploadFolderId(account entity.Account, path string) (string, string) {
+	d := drive.Get(account.Mode)
+	if d == nil || !d.Capabilities().Upload {
+		return "", "当前网盘模式不支持上传"
+	}
+	if !safePath(path) {
+		return "", "指定的目录不存在"
+	}
+	if drive.CapabilitiesOf(account).Cached {
+		fileId := GetFolderId(account, path)
+		if fileId == "" {
+			return "", "指定的目录不存在"
 		}
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return "指定的目录不存在"
-		} else {
-			fileId := dbFile.FileId
-			if path == "/" {
-				fileId = dbFile.ParentId
-			}
-			if account.Mode == "teambition" && !Util.TeambitionSessions[accountId].IsPorject {
-				//teambition 个人文件上传
-				Util.TeambitionUpload(accountId, fileId, files)
-			} else if account.Mode == "teambition" && Util.TeambitionSessions[accountId].IsPorject {
-				//teambition 项目文件上传
-				Util.TeambitionProUpload("", accountId, fileId, files)
-			} else if account.Mode == "teambition-us" && Util.TeambitionSessions[accountId].IsPorject {
-				//teambition-us 项目文件上传
-				Util.TeambitionProUpload("us", accountId, fileId, files)
-			} else if account.Mode == "cloud189" {
-				//天翼云盘文件上传
-				Util.Cloud189UploadFiles(accountId, fileId, files)
-			} else if account.Mode == "aliyundrive" {
-				//阿里云盘文件上传
-				Util.AliUpload(accountId, fileId, files)
-			}
-			return "上传成功"
+		return fileId, ""
+	}
+	fileId := filepath.Join(account.RootId, path)
+	if rel, err := filepath.Rel(filepath.Join(account.RootId), fileId); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "指定的目录不存在"
+	}
+	if _, err := d.List(account, fileId, path); err != nil {
+		return "", "指定的目录不存在"
+	}
+	return fileId, ""
+}
+
+//路径中不能含有..（以及windows下的\..），否则拼接到根目录后会越过根目录
+func safePath(p string) bool {
+	for _, s := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if s == ".." {
+			return false
 		}
 	}
+	return true
+}
+
+//根据路径查询缓存中的目录ID，根目录取其子节点的parent_id，不存在返回空
+func GetFolderId(account entity.Account, path string) string {
+	dbFile := entity.FileNode{}
+	var result *gorm.DB
+	if path == "/" {
+		result = model.SqliteDb.Raw("select * from file_node where parent_path=? and `delete`=0 and account_id=? limit 1", path, account.Id).Take(&dbFile)
+	} else {
+		result = model.SqliteDb.Raw("select * from file_node where path=? and is_folder=1 and `delete`=0 and account_id=?", path, account.Id).Take(&dbFile)
+	}
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return ""
+	}
+	if path == "/" {
+		return dbFile.ParentId
+	}
+	return dbFile.FileId
 }
 
 func Async(accountId, path string) string {
 	account := entity.Account{}
 	result := model.SqliteDb.Raw("select * from account where id=?", accountId).Take(&account)
-	dbFile := entity.FileNode{}
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return "指定的账号不存在"
 	}
-	if account.Mode == "native" {
+	d := drive.Get(account.Mode)
+	if d == nil || !drive.CapabilitiesOf(account).Cached {
 		return "无需刷新"
-	} else {
-		if path == "/" {
-			result = model.SqliteDb.Raw("select * from file_node where parent_path=? and `delete`=0 and account_id=? limit 1", path, accountId).Take(&dbFile)
-		} else {
-			result = model.SqliteDb.Raw("select * from file_node where path=? and `delete`=0 and account_id=?", path, accountId).Take(&dbFile)
-		}
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return "指定的目录不存在"
-		} else {
-			fileId := dbFile.FileId
-			if path == "/" {
-				fileId = dbFile.ParentId
-			}
-			if account.Mode == "teambition" && !Util.TeambitionSessions[accountId].IsPorject {
-				//teambition 个人文件
-				Util.TeambitionGetFiles(account.Id, fileId, fileId, path)
-			} else if account.Mode == "teambition" && Util.TeambitionSessions[accountId].IsPorject {
-				//teambition 项目文件
-				Util.TeambitionGetProjectFiles("www", account.Id, fileId, path)
-			} else if account.Mode == "teambition-us" && Util.TeambitionSessions[accountId].IsPorject {
-				//teambition-us 项目文件
-				Util.TeambitionGetProjectFiles("us", account.Id, fileId, path)
-			} else if account.Mode == "cloud189" {
-				Util.Cloud189GetFiles(account.Id, fileId, fileId, path)
-			} else if account.Mode == "aliyundrive" {
-				Util.AliGetFiles(account.Id, fileId, fileId, path)
-			}
-			refreshFileNodes(account.Id, fileId)
-			return "刷新成功"
-		}
 	}
+	fileId := GetFolderId(account, path)
+	if fileId == "" {
+		return "指定的目录不存在"
+	}
+	if err := d.Walk(account, fileId, path); err != nil {
+		log.Warningf("[目录缓存][%s]%s >> %s", account.Name, path, err.Error())
+		return "刷新失败：" + err.Error()
+	}
+	refreshFileNodes(account.Id, fileId)
+	return "刷新成功"
 }
 func refreshFileNodes(accountId, fileId string) {
 	tmpList := []entity.FileNode{}
 	list := []entity.FileNode{}
 	model.SqliteDb.Raw("select * from file_node where parent_id=? and `delete`=0 and account_id=?", fileId, accountId).Find(&tmpList)
 	getAllNodes(&tmpList, &list)
+	ids := []string{}
 	for _, fn := range list {
 		model.SqliteDb.Where("id=?", fn.Id).Delete(entity.FileNode{})
+		ids = append(ids, fn.Id)
 	}
+	//只更新该目录的索引，不重建整个账号
+	model.UpdateSearchIndex(accountId, ids)
 	model.SqliteDb.Table("file_node").Where("account_id=?", accountId).Update("delete", 0)
 }
 
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"PanIndex/entity"
+	"PanIndex/model"
+	uuid "github.com/satori/go.uuid"
+	"testing"
+)
+
+//使用临时目录中的数据库
+func initTestDb(t *testing.T) {
+	model.InitDb("", "", t.TempDir(), false)
+}
+
+//写入目录缓存，路径的上级目录即parent_path
+func addNodes(accountId string, nodes ...entity.FileNode) {
+	for _, fn := range nodes {
+		fn.Id = uuid.NewV4().String()
+		fn.AccountId = accountId
+		fn.ParentPath = PetParentPath(fn.Path)
+		if fn.FileId == "" {
+			fn.FileId = accountId + fn.Path
+		}
+		model.SqliteDb.Create(&fn)
+	}
+}
+
+func paths(list []entity.FileNode) []string {
+	ps := []string{}
+	for _, fn := range list {
+		ps = append(ps, fn.Path)
+	}
+	return ps
+}
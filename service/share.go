@@ -0,0 +1,202 @@
+package service
+
+import (
+	"PanIndex/entity"
+	"PanIndex/model"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/bluele/gcache"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrShareNotFound  = errors.New("分享不存在或已取消")
+	ErrShareExpired   = errors.New("分享已过期")
+	ErrShareExhausted = errors.New("分享的下载次数已用完")
+)
+
+//校验通过的提取码（分享id及提取码的摘要）缓存的时间，访问分享的每个请求都需要校验提取码
+const sharePasswordTTL = 5 * time.Minute
+
+var sharePasswords = gcache.New(1000).LRU().Build()
+
+//创建分享链接，expireHours为有效时长（小时），maxDownloads为最多下载次数，均为0表示不限制
+//创建者需要有浏览权限；非管理员分享加密目录或其中的文件时，pwd需为目录密码
+func CreateShare(user entity.User, accountId, p, pwd, password string, expireHours, maxDownloads int) (entity.Share, string) {
+	share := entity.Share{}
+	account := GetAccount(accountId)
+	if account.Id == "" {
+		return share, "指定的账号不存在"
+	}
+	if expireHours < 0 || maxDownloads < 0 {
+		return share, "有效期及下载次数不能为负数"
+	}
+	p = path.Clean("/" + p)
+	if !HasPerm(user, account.Id, p, PermRead) {
+		return share, "没有访问权限"
+	}
+	fileNode, found, parentId := findFile(account, p)
+	if user.Role != "admin" {
+		locked := lockedDirs(pwd)
+		if locked[parentId] || (fileNode.IsFolder && locked[fileNode.FileId]) {
+			return share, "目录需要访问密码"
+		}
+	}
+	if !found {
+		return share, "文件不存在"
+	}
+	token := make([]byte, 12)
+	if _, err := rand.Read(token); err != nil {
+		return share, err.Error()
+	}
+	hash := ""
+	if password != "" {
+		b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return share, "提取码加密失败：" + err.Error()
+		}
+		hash = string(b)
+	}
+	now := time.Now()
+	share = entity.Share{
+		Id:           uuid.NewV4().String(),
+		Token:        base64.RawURLEncoding.EncodeToString(token),
+		AccountId:    account.Id,
+		Path:         p,
+		IsFolder:     fileNode.IsFolder,
+		Password:     hash,
+		HasPassword:  hash != "",
+		MaxDownloads: maxDownloads,
+		UserId:       user.Id,
+		CreateTime:   now.Format("2006-01-02 15:04:05"),
+	}
+	if expireHours > 0 {
+		share.ExpireTime = now.Add(time.Duration(expireHours) * time.Hour).Format("2006-01-02 15:04:05")
+	}
+	if err := model.SqliteDb.Create(&share).Error; err != nil {
+		return share, err.Error()
+	}
+	GetConfig()
+	return share, ""
+}
+
+//根据token获取分享，已撤销、已过期或下载次数用完时返回错误
+func GetShare(token string) (entity.Share, error) {
+	share := entity.Share{}
+	result := model.SqliteDb.Raw("select * from share where token=? and revoked=0 limit 1", token).Take(&share)
+	if result.Error != nil || GetAccount(share.AccountId).Id == "" {
+		return share, ErrShareNotFound
+	}
+	if share.ExpireTime != "" && share.ExpireTime < time.Now().Format("2006-01-02 15:04:05") {
+		return share, ErrShareExpired
+	}
+	if share.MaxDownloads > 0 && share.Downloads >= share.MaxDownloads {
+		return share, ErrShareExhausted
+	}
+	return share, nil
+}
+
+//校验提取码，校验通过后缓存结果，避免每个请求都计算bcrypt
+func CheckSharePassword(share entity.Share, password string) bool {
+	if share.Password == "" {
+		return true
+	}
+	h := sha256.Sum256([]byte(share.Id + "\n" + password))
+	key := hex.EncodeToString(h[:])
+	if _, err := sharePasswords.Get(key); err == nil {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(share.Password), []byte(password)) != nil {
+		return false
+	}
+	sharePasswords.SetWithExpire(key, true, sharePasswordTTL)
+	return true
+}
+
+//列出分享中的文件，rel为相对于分享路径的路径，页面中的路径均为相对路径
+//分享范围内的加密目录无需目录密码，但不会超出创建者的浏览权限；rel为文件时File为该文件
+func ShareFiles(share entity.Share, rel string, p ListParams) (entity.Account, map[string]interface{}) {
+	account := GetAccount(share.AccountId)
+	result := make(map[string]interface{})
+	rel = path.Clean("/" + rel)
+	full := path.Join(share.Path, rel)
+	owner := shareOwner(share)
+	list, total, isFile := []entity.FileNode{}, 0, false
+	if !share.IsFolder {
+		//分享的是文件，只能访问该文件，rel由调用方校验
+		if fn, found, _ := findFile(account, share.Path); found {
+			list, total, isFile = []entity.FileNode{fn}, 1, true
+		}
+	} else if HasPerm(owner, account.Id, full, PermRead) {
+		list, total, isFile, _ = listFilesPage(account, full, p)
+		list = FilterReadable(owner, list)
+	}
+	filesResult(result, account, full, p, list, total, isFile, "")
+	if isFile {
+		result["File"] = list[0]
+	}
+	shareList := []entity.FileNode{}
+	for _, fn := range list {
+		fn.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(fn.Path, share.Path), "/")
+		shareList = append(shareList, fn)
+	}
+	result["List"] = shareList
+	result["Path"] = rel
+	result["HasParent"] = rel != "/"
+	result["ParentPath"] = PetParentPath(rel)
+	//打包下载需要登录权限，分享页面不提供
+	result["SurportFolderDown"] = false
+	return account, result
+}
+
+//分享的权限以创建者为准，通过token创建的分享视为管理员创建
+func shareOwner(share entity.Share) entity.User {
+	if share.UserId == "" {
+		return entity.User{Role: "admin"}
+	}
+	return GetUser(share.UserId)
+}
+
+//记录一次下载，下载次数已用完时返回false
+func ShareDownloaded(share entity.Share) bool {
+	result := model.SqliteDb.Exec("update share set downloads=downloads+1 where id=? and (max_downloads=0 or downloads<max_downloads)", share.Id)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func ShareViewed(share entity.Share) {
+	model.SqliteDb.Exec("update share set views=views+1 where id=?", share.Id)
+}
+
+//撤销分享，userId不为空时只能撤销自己创建的分享
+func RevokeShare(id, userId string) string {
+	db := model.SqliteDb.Table("share").Where("id=?", id)
+	if userId != "" {
+		db = db.Where("user_id=?", userId)
+	}
+	if db.Update("revoked", 1).RowsAffected == 0 {
+		return "分享不存在"
+	}
+	GetConfig()
+	return ""
+}
+
+//有效的分享，userId不为空时只返回该用户创建的分享
+func ActiveShares(userId string) []entity.Share {
+	shares := []entity.Share{}
+	db := model.SqliteDb.Where("revoked=0 and (expire_time='' or expire_time>=?)", time.Now().Format("2006-01-02 15:04:05"))
+	if userId != "" {
+		db = db.Where("user_id=?", userId)
+	}
+	db.Order("create_time desc").Find(&shares)
+	for i := range shares {
+		shares[i].HasPassword = shares[i].Password != ""
+	}
+	return shares
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"PanIndex/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//开启下载地址签名时，返回文件地址需要附加的参数?sign=...&exp=...，未开启时返回空
+func SignQuery(accountId, p string) string {
+	if config.GloablConfig.SignUrl != 1 {
+		return ""
+	}
+	expire := config.GloablConfig.SignExpire
+	if expire <= 0 {
+		expire = 24
+	}
+	exp := time.Now().Add(time.Duration(expire) * time.Hour).Unix()
+	return fmt.Sprintf("?sign=%s&exp=%d", signature(accountId, p, exp), exp)
+}
+
+//校验下载地址签名，未开启签名时总是通过
+func CheckSign(accountId, p, sign, exp string) bool {
+	if config.GloablConfig.SignUrl != 1 {
+		return true
+	}
+	e, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || e < time.Now().Unix() {
+		return false
+	}
+	return hmac.Equal([]byte(sign), []byte(signature(accountId, p, e)))
+}
+
+//对账号id、路径及过期时间签名，路径为账号内的路径，与访问地址的/d_序号前缀无关
+func signature(accountId, p string, exp int64) string {
+	mac := hmac.New(sha256.New, []byte(config.GloablConfig.SignKey))
+	fmt.Fprintf(mac, "%s\n%s\n%d", accountId, p, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"PanIndex/config"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignQuery(t *testing.T) {
+	old := config.GloablConfig
+	defer func() { config.GloablConfig = old }()
+	config.GloablConfig.SignKey = "test-key"
+
+	config.GloablConfig.SignUrl = 0
+	if q := SignQuery("a1", "/a.txt"); q != "" {
+		t.Fatalf("未开启签名时应返回空，得到%q", q)
+	}
+	config.GloablConfig.SignUrl = 1
+	config.GloablConfig.SignExpire = 2
+	q := SignQuery("a1", "/a.txt")
+	values, err := url.ParseQuery(q[1:])
+	if q[0] != '?' || err != nil {
+		t.Fatalf("SignQuery返回%q", q)
+	}
+	exp, _ := strconv.ParseInt(values.Get("exp"), 10, 64)
+	if d := time.Until(time.Unix(exp, 0)); d < time.Hour || d > 2*time.Hour {
+		t.Errorf("有效期为%v，应为2小时", d)
+	}
+	if !CheckSign("a1", "/a.txt", values.Get("sign"), values.Get("exp")) {
+		t.Errorf("SignQuery生成的签名校验失败")
+	}
+}
+
+func TestCheckSign(t *testing.T) {
+	old := config.GloablConfig
+	defer func() { config.GloablConfig = old }()
+	config.GloablConfig.SignKey = "test-key"
+	config.GloablConfig.SignUrl = 1
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Second).Unix()
+	valid := signature("a1", "/a.txt", future)
+	tampered := "A" + valid[1:]
+	if valid[0] == 'A' {
+		tampered = "B" + valid[1:]
+	}
+	tests := []struct {
+		name      string
+		accountId string
+		path      string
+		sign      string
+		exp       string
+		want      bool
+	}{
+		{"有效签名", "a1", "/a.txt", valid, strconv.FormatInt(future, 10), true},
+		{"已过期", "a1", "/a.txt", signature("a1", "/a.txt", past), strconv.FormatInt(past, 10), false},
+		{"修改过期时间", "a1", "/a.txt", valid, strconv.FormatInt(future+3600, 10), false},
+		{"修改路径", "a1", "/b.txt", valid, strconv.FormatInt(future, 10), false},
+		{"修改账号", "a2", "/a.txt", valid, strconv.FormatInt(future, 10), false},
+		{"修改签名", "a1", "/a.txt", tampered, strconv.FormatInt(future, 10), false},
+		{"缺少签名", "a1", "/a.txt", "", strconv.FormatInt(future, 10), false},
+		{"过期时间不是数字", "a1", "/a.txt", valid, "abc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckSign(tt.accountId, tt.path, tt.sign, tt.exp); got != tt.want {
+				t.Errorf("CheckSign() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	config.GloablConfig.SignKey = "other-key"
+	if CheckSign("a1", "/a.txt", valid, strconv.FormatInt(future, 10)) {
+		t.Errorf("更换密钥后旧签名仍然有效")
+	}
+	config.GloablConfig.SignUrl = 0
+	if !CheckSign("a1", "/a.txt", "", "") {
+		t.Errorf("未开启签名时应总是通过")
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"PanIndex/config"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"time"
+)
+
+//热门及最近下载默认统计的天数
+const statDays = 30
+
+//带下载次数的文件
+type FileStat struct {
+	entity.FileNode
+	Downloads int    `json:"downloads"` //统计期内的下载次数
+	LastTime  string `json:"last_time"` //最近一次下载的时间
+}
+
+//每个账号的下载统计，Counts与DownloadReport.Days一一对应
+type AccountDownloads struct {
+	AccountId string     `json:"account_id"`
+	Name      string     `json:"name"`
+	Mode      string     `json:"mode"`
+	Total     int        `json:"total"`
+	Counts    []int      `json:"counts"`
+	Top       []FileStat `json:"top"`
+}
+
+//后台下载报表
+type DownloadReport struct {
+	Days     []string           `json:"days"`
+	Accounts []AccountDownloads `json:"accounts"`
+}
+
+//文件下载次数加一，按天累计
+func CountDownload(accountId, path string) {
+	now := time.Now()
+	model.SqliteDb.Exec("insert into download_stat(account_id, path, day, count, last_time) values(?, ?, ?, 1, ?) "+
+		"on conflict(account_id, path, day) do update set count = count + 1, last_time = excluded.last_time",
+		accountId, path, now.Format("2006-01-02"), now.Format("2006-01-02 15:04:05"))
+}
+
+//最近days天下载次数最多的文件
+func PopularFiles(user entity.User, accountId string, days, limit int) []FileStat {
+	return downloadStats(user, accountId, days, limit, "downloads desc, last_time desc")
+}
+
+//最近下载的文件，按最后下载时间倒序
+func RecentDownloads(user entity.User, accountId string, days, limit int) []FileStat {
+	return downloadStats(user, accountId, days, limit, "last_time desc")
+}
+
+//只返回未删除、未隐藏、有浏览权限且不在加密目录中的文件
+func downloadStats(user entity.User, accountId string, days, limit int, order string) []FileStat {
+	if days < 1 {
+		days = statDays
+	}
+	stats := []FileStat{}
+	model.SqliteDb.Raw("select f.*, s.downloads, s.last_time from "+
+		"(select account_id, path, sum(count) downloads, max(last_time) last_time from download_stat "+
+		"where account_id = ? and day > ? group by account_id, path) s "+
+		"join file_node f on f.account_id = s.account_id and f.path = s.path and f.`delete` = 0 and f.hide = 0 and f.is_folder = 0 "+
+		"order by "+order, accountId, time.Now().AddDate(0, 0, -days).Format("2006-01-02")).Scan(&stats)
+	locked := lockedDirs("")
+	seen := map[string]bool{}
+	result := []FileStat{}
+	for _, fs := range stats {
+		if seen[fs.Path] || locked[fs.ParentId] || !HasPerm(user, accountId, fs.Path, PermRead) {
+			continue
+		}
+		seen[fs.Path] = true
+		result = append(result, fs)
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+	return result
+}
+
+//最近days天每个账号每天的下载次数及下载最多的文件
+func GetDownloadReport(days, top int) DownloadReport {
+	if days < 1 {
+		days = statDays
+	}
+	report := DownloadReport{Days: []string{}, Accounts: []AccountDownloads{}}
+	index := map[string]int{}
+	now := time.Now()
+	for i := days - 1; i >= 0; i-- {
+		day := now.AddDate(0, 0, -i).Format("2006-01-02")
+		index[day] = len(report.Days)
+		report.Days = append(report.Days, day)
+	}
+	admin := entity.User{Role: "admin"}
+	for _, account := range config.GloablConfig.Accounts {
+		ad := AccountDownloads{AccountId: account.Id, Name: account.Name, Mode: account.Mode, Counts: make([]int, days)}
+		rows := []entity.DownloadStat{}
+		model.SqliteDb.Raw("select day, sum(count) count from download_stat where account_id = ? and day >= ? group by day",
+			account.Id, report.Days[0]).Scan(&rows)
+		for _, row := range rows {
+			if i, ok := index[row.Day]; ok {
+				ad.Counts[i] = row.Count
+				ad.Total += row.Count
+			}
+		}
+		ad.Top = PopularFiles(admin, account.Id, days, top)
+		report.Accounts = append(report.Accounts, ad)
+	}
+	return report
+}
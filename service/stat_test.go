@@ -0,0 +1,80 @@
+package service
+
+import (
+	"PanIndex/config"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDownloadStats(t *testing.T) {
+	initTestDb(t)
+	old := config.GloablConfig
+	defer func() { config.GloablConfig = old }()
+	config.GloablConfig.Acls = []entity.Acl{{AccountId: "a1", Path: "/secret", Role: "guest"}}
+	config.GloablConfig.PwdDirId = "locked:123"
+	config.GloablConfig.Accounts = []entity.Account{{Id: "a1", Name: "网盘", Mode: "native"}}
+	addNodes("a1",
+		entity.FileNode{Path: "/a.txt", FileName: "a.txt"},
+		entity.FileNode{Path: "/b.txt", FileName: "b.txt"},
+		entity.FileNode{Path: "/c.txt", FileName: "c.txt"},
+		entity.FileNode{Path: "/secret/d.txt", FileName: "d.txt"},
+		entity.FileNode{Path: "/locked/e.txt", FileName: "e.txt", ParentId: "locked"},
+		entity.FileNode{Path: "/hidden.txt", FileName: "hidden.txt", Hide: 1},
+		entity.FileNode{Path: "/dir", FileName: "dir", IsFolder: true},
+	)
+	today := time.Now().Format("2006-01-02")
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	expired := time.Now().AddDate(0, 0, -statDays-1).Format("2006-01-02")
+	for _, s := range []entity.DownloadStat{
+		{Path: "/a.txt", Day: yesterday, Count: 2, LastTime: yesterday + " 10:00:00"},
+		{Path: "/a.txt", Day: today, Count: 1, LastTime: today + " 00:00:01"},
+		{Path: "/b.txt", Day: yesterday, Count: 5, LastTime: yesterday + " 12:00:00"},
+		{Path: "/c.txt", Day: expired, Count: 9, LastTime: expired + " 12:00:00"},
+		{Path: "/secret/d.txt", Day: today, Count: 9, LastTime: today + " 00:00:02"},
+		{Path: "/locked/e.txt", Day: today, Count: 9, LastTime: today + " 00:00:03"},
+		{Path: "/hidden.txt", Day: today, Count: 9, LastTime: today + " 00:00:04"},
+		{Path: "/dir", Day: today, Count: 9, LastTime: today + " 00:00:05"},
+	} {
+		s.AccountId = "a1"
+		model.SqliteDb.Create(&s)
+	}
+	//当天再次下载累加到同一条记录
+	CountDownload("a1", "/a.txt")
+	var stat entity.DownloadStat
+	model.SqliteDb.Where("account_id=? and path=? and day=?", "a1", "/a.txt", today).First(&stat)
+	if stat.Count != 2 || stat.LastTime < today+" 00:00:01" {
+		t.Errorf("CountDownload = %+v", stat)
+	}
+
+	names := func(list []FileStat) []string {
+		ns := []string{}
+		for _, fs := range list {
+			ns = append(ns, fs.Path)
+		}
+		return ns
+	}
+	popular := PopularFiles(Guest, "a1", 0, 10)
+	if got := names(popular); !reflect.DeepEqual(got, []string{"/b.txt", "/a.txt"}) || popular[1].Downloads != 4 {
+		t.Errorf("PopularFiles = %+v", popular)
+	}
+	if got := names(RecentDownloads(Guest, "a1", 0, 1)); !reflect.DeepEqual(got, []string{"/a.txt"}) {
+		t.Errorf("RecentDownloads = %v", got)
+	}
+	//管理员可以看到无权浏览的文件，加密目录中的文件仍然不显示
+	admin := entity.User{Role: "admin"}
+	if got := names(PopularFiles(admin, "a1", 0, 10)); !reflect.DeepEqual(got, []string{"/secret/d.txt", "/b.txt", "/a.txt"}) {
+		t.Errorf("PopularFiles(admin) = %v", got)
+	}
+
+	report := GetDownloadReport(2, 1)
+	if !reflect.DeepEqual(report.Days, []string{yesterday, today}) || len(report.Accounts) != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	ad := report.Accounts[0]
+	if ad.Name != "网盘" || !reflect.DeepEqual(ad.Counts, []int{7, 38}) || ad.Total != 45 || len(ad.Top) != 1 || ad.Top[0].Path != "/secret/d.txt" {
+		t.Errorf("report account = %+v", ad)
+	}
+}
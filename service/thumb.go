@@ -0,0 +1,204 @@
+package service
+
+import (
+	"PanIndex/entity"
+	"PanIndex/model"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var ErrNoThumb = errors.New("该文件没有缩略图")
+
+//缩略图尺寸（最长边像素），按名称限定以免缓存被任意尺寸撑大
+var ThumbSizes = map[string]int{"small": 200, "medium": 400, "large": 1024}
+
+//生成缩略图时读取的原图大小上限
+const thumbSourceLimit = 50 * 1024 * 1024
+
+//生成缩略图的原图像素上限，解码前先读取尺寸，避免很小的文件解码出超大图片
+const thumbPixelLimit = 40 * 1000 * 1000
+
+//同时生成缩略图的数量，解码大图比较占内存
+var thumbSem = make(chan struct{}, 2)
+
+//缩略图缓存目录
+func thumbDir() string {
+	return filepath.Join(model.DataPath, "thumbs")
+}
+
+//缓存文件名包含文件大小及修改时间，文件变化后自动重新生成
+func thumbPath(account entity.Account, fileNode entity.FileNode, size string) string {
+	h := sha1.Sum([]byte(fmt.Sprintf("%s\n%s\n%d\n%s\n%s", account.Id, fileNode.Path, fileNode.FileSize, fileNode.LastOpTime, size)))
+	name := hex.EncodeToString(h[:])
+	return filepath.Join(thumbDir(), name[:2], name+".jpg")
+}
+
+//获取缩略图，返回本地缓存文件路径，size为ThumbSizes中的名称，默认small
+//本地图片直接缩放，本地视频需要安装ffmpeg，网盘优先使用网盘提供的缩略图地址
+func Thumbnail(ctx context.Context, account entity.Account, fileNode entity.FileNode, size string) (string, error) {
+	if _, ok := ThumbSizes[size]; !ok {
+		size = "small"
+	}
+	if fileNode.IsFolder {
+		return "", ErrNoThumb
+	}
+	kind := PreviewKind(fileNode)
+	if kind != PreviewImage && kind != PreviewVideo {
+		return "", ErrNoThumb
+	}
+	target := thumbPath(account, fileNode, size)
+	if _, err := os.Stat(target); err == nil {
+		return target, nil
+	}
+	select {
+	case thumbSem <- struct{}{}:
+		defer func() { <-thumbSem }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	//等待期间可能已由其他请求生成
+	if _, err := os.Stat(target); err == nil {
+		return target, nil
+	}
+	var data []byte
+	var err error
+	if account.Mode == "native" && kind == PreviewVideo {
+		data, err = videoFrame(ctx, fileNode.FileId, ThumbSizes[size])
+	} else {
+		data, err = thumbSource(ctx, account, fileNode, kind, size)
+	}
+	if err != nil {
+		return "", err
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && int64(cfg.Width)*int64(cfg.Height) > thumbPixelLimit {
+		return "", ErrNoThumb
+	}
+	if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, scaleImage(img, ThumbSizes[size]), &jpeg.Options{Quality: 80}); err != nil {
+			return "", err
+		}
+		data = buf.Bytes()
+	} else if account.Mode == "native" {
+		//本地图片无法解码（例如webp）时没有缩略图
+		return "", ErrNoThumb
+	}
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return "", err
+	}
+	tmp := target + "." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	return target, os.Rename(tmp, target)
+}
+
+//缩略图原始数据，网盘优先使用网盘的缩略图地址，没有时图片读取原图
+func thumbSource(ctx context.Context, account entity.Account, fileNode entity.FileNode, kind, size string) ([]byte, error) {
+	thumbUrl := fileNode.SmallUrl
+	if (size == "large" && fileNode.LargeUrl != "") || thumbUrl == "" {
+		thumbUrl = fileNode.LargeUrl
+	}
+	if account.Mode != "native" && thumbUrl != "" {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, thumbUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			return nil, errors.New("缩略图下载失败：" + resp.Status)
+		}
+		return ioutil.ReadAll(io.LimitReader(resp.Body, thumbSourceLimit))
+	}
+	if kind != PreviewImage || fileNode.FileSize > thumbSourceLimit {
+		return nil, ErrNoThumb
+	}
+	rc, err := OpenFileContent(ctx, account, fileNode)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(io.LimitReader(rc, thumbSourceLimit))
+}
+
+//使用ffmpeg截取视频第1秒的画面
+func videoFrame(ctx context.Context, file string, max int) ([]byte, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, ErrNoThumb
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpeg, "-v", "error", "-ss", "1", "-i", file, "-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", max), "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil || out.Len() == 0 {
+		return nil, ErrNoThumb
+	}
+	return out.Bytes(), nil
+}
+
+//按最长边等比缩小，每个目标像素取对应区域的平均值，小图不放大
+func scaleImage(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return src
+	}
+	dw, dh := max, h*max/w
+	if w <= max && h <= max {
+		dw, dh = w, h
+	} else if h > w {
+		dw, dh = w*max/h, max
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	//直接读取原图像素，不复制整张图片；jpeg不支持透明，透明部分以白色填充
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, (y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, (x+1)*w/dw
+			var r, g, bl, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sr, sg, sb, sa := src.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r += uint64(sr + 0xffff - sa)
+					g += uint64(sg + 0xffff - sa)
+					bl += uint64(sb + 0xffff - sa)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n>>8), uint8(g/n>>8), uint8(bl/n>>8), 255
+		}
+	}
+	return dst
+}
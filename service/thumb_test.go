@@ -0,0 +1,99 @@
+package service
+
+import (
+	"PanIndex/entity"
+	"PanIndex/model"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScaleImage(t *testing.T) {
+	tests := []struct {
+		w, h, max int
+		dw, dh    int
+	}{
+		{400, 200, 200, 200, 100},
+		{100, 300, 200, 66, 200},
+		{50, 40, 200, 50, 40},
+		{1000, 1, 200, 200, 1},
+	}
+	for _, tt := range tests {
+		b := scaleImage(image.NewRGBA(image.Rect(0, 0, tt.w, tt.h)), tt.max).Bounds()
+		if b.Dx() != tt.dw || b.Dy() != tt.dh {
+			t.Errorf("scaleImage(%dx%d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.max, b.Dx(), b.Dy(), tt.dw, tt.dh)
+		}
+	}
+	//黑白相间的像素取平均值，透明像素按白色计算
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		src.Set(x, 0, color.NRGBA{A: 255})
+		src.Set(x, 1, color.NRGBA{})
+	}
+	dst := scaleImage(src, 2)
+	if b := dst.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+		t.Fatalf("scaleImage = %v, want 2x1", b)
+	}
+	r, g, bl, a := dst.At(0, 0).RGBA()
+	if r>>8 != 127 || g>>8 != 127 || bl>>8 != 127 || a>>8 != 255 {
+		t.Errorf("pixel = %d,%d,%d,%d, want 127,127,127,255", r>>8, g>>8, bl>>8, a>>8)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	dataPath := model.DataPath
+	model.DataPath = t.TempDir()
+	defer func() { model.DataPath = dataPath }()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.png")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 600, 300)))
+	f.Close()
+	txt := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(txt, []byte("text"), 0644)
+	bad := filepath.Join(dir, "b.png")
+	ioutil.WriteFile(bad, []byte("not a png"), 0644)
+
+	account := entity.Account{Id: "native", Mode: "native"}
+	node := entity.FileNode{FileId: file, FileName: "a.png", Path: "/a.png", FileSize: 1, LastOpTime: "2021-01-01 00:00:00"}
+	target, err := Thumbnail(context.Background(), account, node, "")
+	if err != nil {
+		t.Fatalf("Thumbnail: %v", err)
+	}
+	if target != thumbPath(account, node, "small") {
+		t.Errorf("target = %s, want %s", target, thumbPath(account, node, "small"))
+	}
+	tf, err := os.Open(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(tf)
+	tf.Close()
+	if err != nil || cfg.Width != 200 || cfg.Height != 100 {
+		t.Errorf("thumb = %dx%d %v, want 200x100 jpeg", cfg.Width, cfg.Height, err)
+	}
+	//文件修改后缓存路径随之变化
+	changed := node
+	changed.LastOpTime = "2021-01-02 00:00:00"
+	if thumbPath(account, changed, "small") == target {
+		t.Error("thumbPath ignores LastOpTime")
+	}
+	for _, fn := range []entity.FileNode{
+		{FileId: dir, FileName: "dir", Path: "/dir", IsFolder: true},
+		{FileId: txt, FileName: "a.txt", Path: "/a.txt"},
+		{FileId: bad, FileName: "b.png", Path: "/b.png"},
+	} {
+		if _, err := Thumbnail(context.Background(), account, fn, "small"); err != ErrNoThumb {
+			t.Errorf("Thumbnail(%s) = %v, want ErrNoThumb", fn.Path, err)
+		}
+	}
+}
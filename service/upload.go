@@ -0,0 +1,233 @@
+package service
+
+import (
+	"PanIndex/drive"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"PanIndex/notify"
+	"errors"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//分片大小，客户端按此大小切分文件
+const UploadChunkSize = 5 * 1024 * 1024
+
+//分片位置与已接收的大小不一致，客户端应从已接收的位置继续上传
+var ErrUploadOffset = errors.New("分片位置不正确")
+
+var (
+	uploadLocks sync.Map //任务id -> *sync.Mutex，同一任务的分片依次写入
+	uploadSent  sync.Map //任务id -> *int64，已上传到网盘的字节数
+)
+
+func uploadPartFile(id string) string {
+	return filepath.Join(model.DataPath, "uploads", id+".part")
+}
+
+func uploadLock(id string) *sync.Mutex {
+	lock, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+//创建分片上传任务
+//同一用户向同一目录上传同名、同大小的文件时，返回未完成的任务，从已接收的位置继续上传
+func InitUpload(user entity.User, accountId, path, name string, size int64) (entity.UploadTask, string) {
+	cleanUploads()
+	task := entity.UploadTask{}
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || size < 0 {
+		return task, "文件名不能为空"
+	}
+	if !safePath(path) {
+		return task, "指定的目录不存在"
+	}
+	path = "/" + strings.Trim(path, "/")
+	account := GetAccount(accountId)
+	if account.Id == "" {
+		return task, "指定的账号不存在"
+	}
+	if _, msg := uploadFolderId(account, path); msg != "" {
+		return task, msg
+	}
+	result := model.SqliteDb.Raw("select * from upload_task where account_id=? and path=? and name=? and size=? and user_id=? and status in (0, 3) limit 1",
+		accountId, path, name, size, user.Id).Take(&task)
+	if result.Error == nil {
+		//以实际接收的内容为准，临时文件丢失时重新上传
+		task.Uploaded = 0
+		if fi, err := os.Stat(uploadPartFile(task.Id)); err == nil {
+			task.Uploaded = fi.Size()
+		}
+		task.Status = 0
+		task.Msg = ""
+		task.UpdateTime = time.Now().Format("2006-01-02 15:04:05")
+		model.SqliteDb.Save(&task)
+		return task, ""
+	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return task, result.Error.Error()
+	}
+	if err := os.MkdirAll(filepath.Dir(uploadPartFile("")), os.ModePerm); err != nil {
+		return task, err.Error()
+	}
+	task = entity.UploadTask{
+		Id:         uuid.NewV4().String(),
+		AccountId:  accountId,
+		Path:       path,
+		Name:       name,
+		Size:       size,
+		UserId:     user.Id,
+		UpdateTime: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	model.SqliteDb.Create(&task)
+	return task, ""
+}
+
+func GetUploadTask(id string) (entity.UploadTask, bool) {
+	task := entity.UploadTask{}
+	result := model.SqliteDb.Raw("select * from upload_task where id=?", id).Take(&task)
+	return task, result.Error == nil
+}
+
+//写入分片，offset必须等于已接收的大小，每次最多接收一个分片大小的内容
+//连接中断时已写入的部分依然有效，客户端重新查询已接收的大小后继续上传
+func WriteUploadChunk(id string, offset int64, r io.Reader) (entity.UploadTask, error) {
+	lock := uploadLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+	task, ok := GetUploadTask(id)
+	if !ok {
+		return task, errors.New("上传任务不存在")
+	}
+	if task.Status != 0 {
+		return task, errors.New("上传任务已结束")
+	}
+	f, err := os.OpenFile(uploadPartFile(id), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return task, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return task, err
+	}
+	task.Uploaded = fi.Size()
+	if offset != task.Uploaded {
+		return task, ErrUploadOffset
+	}
+	limit := task.Size - task.Uploaded
+	if limit > UploadChunkSize {
+		limit = UploadChunkSize
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return task, err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit))
+	task.Uploaded += n
+	task.UpdateTime = time.Now().Format("2006-01-02 15:04:05")
+	model.SqliteDb.Table("upload_task").Where("id=?", id).Updates(map[string]interface{}{"uploaded": task.Uploaded, "update_time": task.UpdateTime})
+	return task, err
+}
+
+//文件接收完整后，在后台上传到网盘，refresh为true时上传成功后刷新目录缓存
+func CompleteUpload(id string, refresh bool) (entity.UploadTask, string) {
+	lock := uploadLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+	task, ok := GetUploadTask(id)
+	if !ok {
+		return task, "上传任务不存在"
+	}
+	if task.Status == 1 || task.Status == 2 {
+		return task, ""
+	}
+	if fi, err := os.Stat(uploadPartFile(id)); err != nil || fi.Size() != task.Size {
+		return task, "文件未接收完整"
+	}
+	account := GetAccount(task.AccountId)
+	parentId, msg := uploadFolderId(account, task.Path)
+	if msg != "" {
+		return task, msg
+	}
+	task.Status = 1
+	task.Msg = ""
+	task.UpdateTime = time.Now().Format("2006-01-02 15:04:05")
+	model.SqliteDb.Save(&task)
+	uploadSent.Store(task.Id, new(int64))
+	go uploadToDrive(task, account, parentId, refresh)
+	return task, ""
+}
+
+func uploadToDrive(task entity.UploadTask, account entity.Account, parentId string, refresh bool) {
+	n, _ := uploadSent.Load(task.Id)
+	sent := n.(*int64)
+	defer uploadSent.Delete(task.Id)
+	f, err := os.Open(uploadPartFile(task.Id))
+	if err == nil {
+		err = drive.Get(account.Mode).Upload(account, parentId, task.Name, task.Size, &countReader{r: f, n: sent})
+		f.Close()
+	}
+	if err != nil {
+		log.Warningf("文件：%s，上传失败：%s", task.Name, err.Error())
+		model.SqliteDb.Table("upload_task").Where("id=?", task.Id).Updates(map[string]interface{}{"status": 3, "msg": err.Error()})
+		notify.Send(notify.NewEvent("upload", false, account.Id, account.Name, account.Mode, task.Path, task.Name+"："+err.Error()))
+		return
+	}
+	os.Remove(uploadPartFile(task.Id))
+	model.SqliteDb.Table("upload_task").Where("id=?", task.Id).Update("status", 2)
+	notify.Send(notify.NewEvent("upload", true, account.Id, account.Name, account.Mode, task.Path, task.Name))
+	if refresh {
+		Async(account.Id, task.Path)
+	}
+}
+
+//上传任务状态，sent为已上传到网盘的字节数
+func UploadStatus(id string) (entity.UploadTask, int64, bool) {
+	task, ok := GetUploadTask(id)
+	var sent int64
+	if n, exists := uploadSent.Load(id); exists {
+		sent = atomic.LoadInt64(n.(*int64))
+	} else if task.Status == 2 {
+		sent = task.Size
+	}
+	return task, sent, ok
+}
+
+//服务重启后上传到网盘中的任务不会继续，标记为失败，客户端可以重新提交，到期后被清理
+func ResetUploads() {
+	model.SqliteDb.Table("upload_task").Where("status=1").Updates(map[string]interface{}{"status": 3, "msg": "服务重启，上传已中断"})
+}
+
+//清理一天前的上传任务及临时文件，正在上传到网盘的任务除外
+func cleanUploads() {
+	tasks := []entity.UploadTask{}
+	expired := time.Now().Add(-24 * time.Hour).Format("2006-01-02 15:04:05")
+	model.SqliteDb.Raw("select * from upload_task where update_time<?", expired).Find(&tasks)
+	for _, task := range tasks {
+		if _, running := uploadSent.Load(task.Id); running {
+			continue
+		}
+		os.Remove(uploadPartFile(task.Id))
+		uploadLocks.Delete(task.Id)
+		model.SqliteDb.Where("id=?", task.Id).Delete(entity.UploadTask{})
+	}
+}
+
+//统计读取的字节数，用于显示上传到网盘的进度
+type countReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
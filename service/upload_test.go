@@ -0,0 +1,54 @@
+package service
+
+import (
+	"PanIndex/entity"
+	"PanIndex/model"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaleUploads(t *testing.T) {
+	initTestDb(t)
+	os.MkdirAll(filepath.Dir(uploadPartFile("")), os.ModePerm)
+	old := time.Now().Add(-48 * time.Hour).Format("2006-01-02 15:04:05")
+	tasks := []entity.UploadTask{
+		{Id: "interrupted", Status: 1, UpdateTime: old},
+		{Id: "running", Status: 1, UpdateTime: old},
+		{Id: "failed", Status: 3, UpdateTime: old},
+		{Id: "recent", Status: 0, UpdateTime: time.Now().Format("2006-01-02 15:04:05")},
+	}
+	for _, task := range tasks {
+		model.SqliteDb.Create(&task)
+		ioutil.WriteFile(uploadPartFile(task.Id), []byte("x"), 0644)
+	}
+	//重启前的任务没有在上传，重置为失败后可以重新提交
+	ResetUploads()
+	if task, _ := GetUploadTask("interrupted"); task.Status != 3 || task.Msg == "" {
+		t.Fatalf("ResetUploads()后任务为%+v", task)
+	}
+	model.SqliteDb.Table("upload_task").Where("id=?", "running").Update("status", 1)
+	uploadSent.Store("running", new(int64))
+	defer uploadSent.Delete("running")
+	cleanUploads()
+	tests := []struct {
+		id   string
+		keep bool
+	}{
+		{"interrupted", false},
+		{"running", true},
+		{"failed", false},
+		{"recent", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			_, ok := GetUploadTask(tt.id)
+			_, err := os.Stat(uploadPartFile(tt.id))
+			if ok != tt.keep || (err == nil) != tt.keep {
+				t.Errorf("任务存在=%v，临时文件存在=%v, want %v", ok, err == nil, tt.keep)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"PanIndex/config"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/bluele/gcache"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+	"time"
+)
+
+//权限类型
+const (
+	PermRead   = "read"
+	PermUpload = "upload"
+	PermDelete = "delete"
+)
+
+//未登录用户
+var Guest = entity.User{Role: "guest"}
+
+//basic认证通过的凭据（用户名及密码的摘要 -> 用户id）缓存的时间
+//WebDAV客户端每个请求都携带basic认证，避免每次都计算bcrypt
+const basicLoginTTL = 5 * time.Minute
+
+var basicLogins = gcache.New(1000).LRU().Build()
+
+//用户名密码校验，用户不存在、已禁用或密码错误时返回false
+func Login(name, password string) (entity.User, bool) {
+	user := entity.User{}
+	result := model.SqliteDb.Raw("select * from user where name=? and disabled=0 limit 1", name).Take(&user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return Guest, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return Guest, false
+	}
+	return user, true
+}
+
+//basic认证，验证通过后缓存凭据，用户修改或删除后清空缓存
+func BasicLogin(name, password string) (entity.User, bool) {
+	h := sha256.Sum256([]byte(name + "\n" + password))
+	key := hex.EncodeToString(h[:])
+	if id, err := basicLogins.Get(key); err == nil {
+		//禁用或改名后不再通过
+		if user := GetUser(id.(string)); user.Id != "" && user.Name == name {
+			return user, true
+		}
+		basicLogins.Remove(key)
+	}
+	user, ok := Login(name, password)
+	if ok {
+		basicLogins.SetWithExpire(key, user.Id, basicLoginTTL)
+	}
+	return user, ok
+}
+
+//根据id获取用户，不存在或已禁用时返回未登录用户
+func GetUser(id string) entity.User {
+	user := entity.User{}
+	result := model.SqliteDb.Raw("select * from user where id=? and disabled=0 limit 1", id).Take(&user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return Guest
+	}
+	return user
+}
+
+func SaveUser(user map[string]interface{}) string {
+	name, _ := user["name"].(string)
+	password, _ := user["password"].(string)
+	role, _ := user["role"].(string)
+	id, _ := user["id"].(string)
+	if name == "" {
+		return "用户名不能为空"
+	}
+	if role != "admin" && role != "uploader" && role != "viewer" {
+		return "未知的角色：" + role
+	}
+	var count int64
+	model.SqliteDb.Model(&entity.User{}).Where("name=? and id<>?", name, id).Count(&count)
+	if count > 0 {
+		return "用户名已存在"
+	}
+	disabled := 0
+	if v, ok := user["disabled"].(float64); ok && v == 1 {
+		disabled = 1
+	}
+	data := map[string]interface{}{"name": name, "role": role, "disabled": disabled}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "密码加密失败：" + err.Error()
+		}
+		data["password"] = string(hash)
+	}
+	if id == "" {
+		if password == "" {
+			return "密码不能为空"
+		}
+		data["id"] = uuid.NewV4().String()
+		model.SqliteDb.Table("user").Create(data)
+	} else {
+		if (role != "admin" || disabled == 1) && isLastAdmin(id) {
+			return "至少需要保留一个管理员"
+		}
+		model.SqliteDb.Table("user").Where("id=?", id).Updates(data)
+	}
+	basicLogins.Purge()
+	go GetConfig()
+	return ""
+}
+
+func DeleteUser(id string) string {
+	if isLastAdmin(id) {
+		return "至少需要保留一个管理员"
+	}
+	model.SqliteDb.Where("id=?", id).Delete(entity.User{})
+	model.SqliteDb.Where("user_id=?", id).Delete(entity.Acl{})
+	model.SqliteDb.Table("share").Where("user_id=?", id).Update("revoked", 1)
+	basicLogins.Purge()
+	GetConfig()
+	return ""
+}
+
+func isLastAdmin(id string) bool {
+	var count int64
+	model.SqliteDb.Model(&entity.User{}).Where("role='admin' and disabled=0 and id<>?", id).Count(&count)
+	return count == 0
+}
+
+//修改admin用户的密码，后台登录密码变更时调用，admin用户不存在则创建
+func ResetAdminPassword(password string) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return
+	}
+	admin := entity.User{}
+	result := model.SqliteDb.Raw("select * from user where name='admin' limit 1").Take(&admin)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		model.SqliteDb.Create(&entity.User{Id: uuid.NewV4().String(), Name: "admin", Password: string(hash), Role: "admin"})
+	} else {
+		model.SqliteDb.Table("user").Where("id=?", admin.Id).Update("password", string(hash))
+	}
+	basicLogins.Purge()
+}
+
+func SaveAcl(acl entity.Acl) string {
+	if acl.AccountId == "" {
+		return "请选择网盘账号"
+	}
+	acl.Path = "/" + strings.Trim(acl.Path, "/")
+	if acl.Id == "" {
+		acl.Id = uuid.NewV4().String()
+		model.SqliteDb.Create(&acl)
+	} else {
+		model.SqliteDb.Save(&acl)
+	}
+	//规则立即生效
+	GetConfig()
+	return ""
+}
+
+func DeleteAcl(id string) {
+	model.SqliteDb.Where("id=?", id).Delete(entity.Acl{})
+	GetConfig()
+}
+
+//判断用户对账号下某一路径是否有指定权限
+//管理员拥有全部权限；没有匹配的规则时，所有人可读，上传者可上传
+func HasPerm(user entity.User, accountId, path, perm string) bool {
+	if user.Role == "admin" {
+		return true
+	}
+	acl, ok := matchAcl(user, accountId, path)
+	if !ok {
+		return perm == PermRead || (perm == PermUpload && user.Role == "uploader")
+	}
+	switch perm {
+	case PermRead:
+		return acl.Read == 1
+	case PermUpload:
+		return acl.Upload == 1
+	case PermDelete:
+		return acl.Delete == 1
+	}
+	return false
+}
+
+//过滤掉无权浏览的文件
+func FilterReadable(user entity.User, list []entity.FileNode) []entity.FileNode {
+	if user.Role == "admin" {
+		return list
+	}
+	fs := []entity.FileNode{}
+	for _, fn := range list {
+		if HasPerm(user, fn.AccountId, fn.Path, PermRead) {
+			fs = append(fs, fn)
+		}
+	}
+	return fs
+}
+
+//规则来自全局配置，保存后由GetConfig刷新
+func matchAcl(user entity.User, accountId, path string) (entity.Acl, bool) {
+	matched := []entity.Acl{}
+	for _, acl := range config.GloablConfig.Acls {
+		if acl.AccountId != accountId || !pathHasPrefix(path, acl.Path) {
+			continue
+		}
+		if acl.UserId != "" {
+			if acl.UserId == user.Id {
+				matched = append(matched, acl)
+			}
+		} else if acl.Role == "" || acl.Role == user.Role {
+			matched = append(matched, acl)
+		}
+	}
+	if len(matched) == 0 {
+		return entity.Acl{}, false
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if len(matched[i].Path) != len(matched[j].Path) {
+			return len(matched[i].Path) > len(matched[j].Path)
+		}
+		return aclPriority(matched[i]) > aclPriority(matched[j])
+	})
+	return matched[0], true
+}
+
+func aclPriority(acl entity.Acl) int {
+	if acl.UserId != "" {
+		return 2
+	} else if acl.Role != "" {
+		return 1
+	}
+	return 0
+}
+
+//按目录层级判断前缀，/a匹配/a和/a/b，不匹配/ab
+func pathHasPrefix(path, prefix string) bool {
+	if prefix == "/" || prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"PanIndex/config"
+	"PanIndex/entity"
+	"testing"
+)
+
+func TestPathHasPrefix(t *testing.T) {
+	tests := []struct {
+		path, prefix string
+		want         bool
+	}{
+		{"/a", "/", true},
+		{"/a", "", true},
+		{"/a", "/a", true},
+		{"/a/b", "/a", true},
+		{"/ab", "/a", false},
+		{"/", "/a", false},
+		{"/b/a", "/a", false},
+	}
+	for _, tt := range tests {
+		if got := pathHasPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("pathHasPrefix(%q, %q) = %v, want %v", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestHasPerm(t *testing.T) {
+	old := config.GloablConfig.Acls
+	defer func() { config.GloablConfig.Acls = old }()
+	config.GloablConfig.Acls = []entity.Acl{
+		//所有人不能浏览/private，viewer角色可以浏览，u1可以浏览和上传
+		{AccountId: "a1", Path: "/private"},
+		{AccountId: "a1", Path: "/private", Role: "viewer", Read: 1},
+		{AccountId: "a1", Path: "/private", UserId: "u1", Read: 1, Upload: 1},
+		//更长的路径优先，即使是所有人的规则
+		{AccountId: "a1", Path: "/private/pub", Read: 1},
+		//guest为未登录的访客
+		{AccountId: "a1", Path: "/members", Role: "guest"},
+		{AccountId: "a2", Path: "/", Read: 1, Delete: 1},
+	}
+	admin := entity.User{Id: "admin", Role: "admin"}
+	u1 := entity.User{Id: "u1", Role: "viewer"}
+	u2 := entity.User{Id: "u2", Role: "viewer"}
+	uploader := entity.User{Id: "u3", Role: "uploader"}
+	tests := []struct {
+		name      string
+		user      entity.User
+		accountId string
+		path      string
+		perm      string
+		want      bool
+	}{
+		{"管理员拥有全部权限", admin, "a1", "/private", PermDelete, true},
+		{"没有规则时所有人可读", Guest, "a1", "/other", PermRead, true},
+		{"没有规则时访客不能上传", Guest, "a1", "/other", PermUpload, false},
+		{"没有规则时上传者可上传", uploader, "a1", "/other", PermUpload, true},
+		{"没有规则时上传者不能删除", uploader, "a1", "/other", PermDelete, false},
+		{"所有人规则", Guest, "a1", "/private", PermRead, false},
+		{"所有人规则匹配子目录", uploader, "a1", "/private/x", PermRead, false},
+		{"不匹配同名前缀", Guest, "a1", "/privatex", PermRead, true},
+		{"角色规则优先于所有人", u2, "a1", "/private/x", PermRead, true},
+		{"角色规则没有上传权限", u2, "a1", "/private", PermUpload, false},
+		{"用户规则优先于角色", u1, "a1", "/private", PermUpload, true},
+		{"更长的路径优先", Guest, "a1", "/private/pub/x", PermRead, true},
+		{"更长的路径优先于用户规则", u1, "a1", "/private/pub", PermUpload, false},
+		{"guest规则不影响登录用户", u2, "a1", "/members", PermRead, true},
+		{"guest规则", Guest, "a1", "/members", PermRead, false},
+		{"规则按账号区分", Guest, "a2", "/private", PermDelete, true},
+		{"未知权限", u1, "a1", "/private", "unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasPerm(tt.user, tt.accountId, tt.path, tt.perm); got != tt.want {
+				t.Errorf("HasPerm(%s, %s, %s, %s) = %v, want %v", tt.user.Id, tt.accountId, tt.path, tt.perm, got, tt.want)
+			}
+		})
+	}
+}
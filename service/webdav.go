@@ -0,0 +1,397 @@
+package service
+
+import (
+	"PanIndex/config"
+	"PanIndex/drive"
+	"PanIndex/entity"
+	"context"
+	"fmt"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//WebDAV文件系统，第一级目录为网盘名称，之后的路径与页面访问路径一致
+//User为当前用户，按访问控制规则校验权限；Pwd为basic认证的密码，用于访问加密目录
+//Writable为false时拒绝一切写操作
+type DavFileSystem struct {
+	User     entity.User
+	Pwd      string
+	Writable bool
+	lists    map[string]davListing
+}
+
+type davListing struct {
+	list   []entity.FileNode
+	isFile bool
+	err    error
+}
+
+//每个请求创建一个文件系统，只读请求缓存已列出的目录，PROPFIND获取子文件信息时不再重复读取上级目录
+func NewDavFileSystem(user entity.User, pwd string, writable bool) DavFileSystem {
+	fs := DavFileSystem{User: user, Pwd: pwd, Writable: writable}
+	if !writable {
+		fs.lists = map[string]davListing{}
+	}
+	return fs
+}
+
+//将WebDAV路径拆分为网盘和网盘内的路径
+func (fs DavFileSystem) resolve(name string) (entity.Account, string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return entity.Account{}, "", nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, "/"), "/", 2)
+	for _, account := range config.GloablConfig.Accounts {
+		if account.Name == parts[0] {
+			p := "/"
+			if len(parts) == 2 {
+				p = "/" + parts[1]
+			}
+			return account, p, nil
+		}
+	}
+	return entity.Account{}, "", os.ErrNotExist
+}
+
+//列出网盘目录，目录加密且密码不正确或没有浏览权限时返回无权限
+func (fs DavFileSystem) list(account entity.Account, p string) ([]entity.FileNode, bool, error) {
+	if fs.lists == nil {
+		return fs.listFiles(account, p)
+	}
+	key := account.Id + ":" + p
+	l, ok := fs.lists[key]
+	if !ok {
+		l.list, l.isFile, l.err = fs.listFiles(account, p)
+		fs.lists[key] = l
+	}
+	return l.list, l.isFile, l.err
+}
+
+func (fs DavFileSystem) listFiles(account entity.Account, p string) ([]entity.FileNode, bool, error) {
+	if !HasPerm(fs.User, account.Id, p, PermRead) {
+		return nil, false, os.ErrPermission
+	}
+	list, isFile, pwdFileId := ListFiles(account, p, fs.Pwd)
+	if pwdFileId != "" {
+		return nil, false, os.ErrPermission
+	}
+	return FilterReadable(fs.User, list), isFile, nil
+}
+
+//判断当前用户对WebDAV路径是否有指定权限
+func (fs DavFileSystem) Allowed(name, perm string) bool {
+	account, p, err := fs.resolve(name)
+	if err != nil || account.Id == "" {
+		return false
+	}
+	return HasPerm(fs.User, account.Id, p, perm)
+}
+
+//校验写权限
+func (fs DavFileSystem) can(account entity.Account, p, perm string) bool {
+	return fs.Writable && HasPerm(fs.User, account.Id, p, perm)
+}
+
+func (fs DavFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	account, p, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if account.Id == "" {
+		return davFileInfo{name: "/", isDir: true}, nil
+	}
+	if p == "/" {
+		return davFileInfo{account: account, name: account.Name, isDir: true}, nil
+	}
+	list, _, err := fs.list(account, PetParentPath(p))
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range list {
+		if fn.Path == p {
+			return newDavFileInfo(account, fn), nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+//获取文件信息，目录还需校验其本身的访问密码
+//加密目录在上级目录中依然可见，和页面访问规则一致
+func (fs DavFileSystem) Access(ctx context.Context, name string) (os.FileInfo, error) {
+	fi, err := fs.Stat(ctx, name)
+	if err != nil || !fi.IsDir() {
+		return fi, err
+	}
+	account, p, _ := fs.resolve(name)
+	if account.Id != "" {
+		if _, _, err = fs.list(account, p); err != nil {
+			return nil, err
+		}
+	}
+	return fi, nil
+}
+
+func (fs DavFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	account, p, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return fs.create(account, p, flag, perm)
+	}
+	fi, err := fs.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &davDir{fs: fs, account: account, path: p, info: fi}, nil
+	}
+	dfi := fi.(davFileInfo)
+	if account.Mode == "native" {
+		return os.Open(dfi.node.FileId)
+	}
+	//网盘文件的内容不经过服务端，GET请求会直接跳转到下载地址
+	return &davDir{fs: fs, account: account, path: p, info: fi}, nil
+}
+
+//新建文件，本地模式直接写入磁盘，其他模式先写入临时文件，关闭时上传
+func (fs DavFileSystem) create(account entity.Account, p string, flag int, perm os.FileMode) (webdav.File, error) {
+	if account.Id == "" || p == "/" || !fs.can(account, p, PermUpload) {
+		return nil, os.ErrPermission
+	}
+	d := drive.Get(account.Mode)
+	if d == nil || !d.Capabilities().Upload {
+		return nil, os.ErrPermission
+	}
+	if account.Mode == "native" {
+		return os.OpenFile(filepath.Join(account.RootId, p), flag, perm)
+	}
+	parentId, err := fs.folderId(account, PetParentPath(p))
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile("", "PanIndex-dav-")
+	if err != nil {
+		return nil, err
+	}
+	return &davUploadFile{File: tmp, account: account, path: p, parentId: parentId}, nil
+}
+
+//根据路径获取目录ID，缓存模式从缓存中查询，实时模式为完整路径
+func (fs DavFileSystem) folderId(account entity.Account, p string) (string, error) {
+	if drive.CapabilitiesOf(account).Cached {
+		fileId := GetFolderId(account, p)
+		if fileId == "" {
+			return "", os.ErrNotExist
+		}
+		return fileId, nil
+	}
+	return filepath.Join(account.RootId, p), nil
+}
+
+func (fs DavFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	account, p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	d := drive.Get(account.Mode)
+	if p == "/" || p == "" || d == nil || !d.Capabilities().Mkdir || !fs.can(account, p, PermUpload) {
+		return os.ErrPermission
+	}
+	parentPath := PetParentPath(p)
+	parentId, err := fs.folderId(account, parentPath)
+	if err != nil {
+		return err
+	}
+	if err = d.Mkdir(account, parentId, path.Base(p)); err != nil {
+		return err
+	}
+	Async(account.Id, parentPath)
+	return nil
+}
+
+func (fs DavFileSystem) RemoveAll(ctx context.Context, name string) error {
+	account, p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	d := drive.Get(account.Mode)
+	if p == "/" || p == "" || d == nil || !d.Capabilities().Delete || !fs.can(account, p, PermDelete) {
+		return os.ErrPermission
+	}
+	fi, err := fs.Stat(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err = d.Delete(account, fi.(davFileInfo).node); err != nil {
+		return err
+	}
+	Async(account.Id, PetParentPath(p))
+	return nil
+}
+
+//只有本地模式支持重命名和移动
+func (fs DavFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	account, oldPath, err := fs.resolve(oldName)
+	if err != nil {
+		return err
+	}
+	newAccount, newPath, err := fs.resolve(newName)
+	if err != nil {
+		return err
+	}
+	if account.Mode != "native" || account.Id != newAccount.Id || oldPath == "/" || newPath == "/" ||
+		!fs.can(account, oldPath, PermDelete) || !fs.can(account, newPath, PermUpload) {
+		return os.ErrPermission
+	}
+	return os.Rename(filepath.Join(account.RootId, oldPath), filepath.Join(account.RootId, newPath))
+}
+
+//网盘文件返回所属账号及文件节点，由调用方跳转或代理下载，本地文件由WebDAV直接输出
+func (fs DavFileSystem) CloudFile(fi os.FileInfo) (entity.Account, entity.FileNode, bool) {
+	dfi, ok := fi.(davFileInfo)
+	if !ok || dfi.isDir || dfi.account.Mode == "native" {
+		return entity.Account{}, entity.FileNode{}, false
+	}
+	return dfi.account, dfi.node, true
+}
+
+type davFileInfo struct {
+	account entity.Account
+	node    entity.FileNode
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func newDavFileInfo(account entity.Account, fn entity.FileNode) davFileInfo {
+	modTime, _ := time.ParseInLocation("2006-01-02 15:04:05", fn.LastOpTime, time.Local)
+	return davFileInfo{
+		account: account,
+		node:    fn,
+		name:    fn.FileName,
+		size:    fn.FileSize,
+		modTime: modTime,
+		isDir:   fn.IsFolder,
+	}
+}
+
+func (fi davFileInfo) Name() string       { return fi.name }
+func (fi davFileInfo) Size() int64        { return fi.size }
+func (fi davFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi davFileInfo) IsDir() bool        { return fi.isDir }
+func (fi davFileInfo) Sys() interface{}   { return nil }
+func (fi davFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+//根据后缀返回文件类型，避免读取网盘文件内容
+func (fi davFileInfo) ContentType(ctx context.Context) (string, error) {
+	contentType := mime.TypeByExtension(path.Ext(fi.name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return contentType, nil
+}
+
+func (fi davFileInfo) ETag(ctx context.Context) (string, error) {
+	return fmt.Sprintf(`"%x%x"`, fi.modTime.Unix(), fi.size), nil
+}
+
+//目录（或网盘文件）句柄，只支持列出子文件
+type davDir struct {
+	fs      DavFileSystem
+	account entity.Account
+	path    string
+	info    os.FileInfo
+	offset  int
+}
+
+func (f *davDir) Close() error                                 { return nil }
+func (f *davDir) Stat() (os.FileInfo, error)                   { return f.info, nil }
+func (f *davDir) Read(p []byte) (int, error)                   { return 0, os.ErrPermission }
+func (f *davDir) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (f *davDir) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrPermission }
+
+func (f *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	fis := []os.FileInfo{}
+	if !f.info.IsDir() {
+		return fis, os.ErrInvalid
+	}
+	if f.account.Id == "" {
+		//根目录列出所有网盘
+		for _, account := range config.GloablConfig.Accounts {
+			if HasPerm(f.fs.User, account.Id, "/", PermRead) {
+				fis = append(fis, davFileInfo{account: account, name: account.Name, isDir: true})
+			}
+		}
+	} else {
+		list, isFile, err := f.fs.list(f.account, f.path)
+		if err != nil {
+			return fis, err
+		}
+		if !isFile {
+			for _, fn := range list {
+				fis = append(fis, newDavFileInfo(f.account, fn))
+			}
+		}
+	}
+	if f.offset >= len(fis) {
+		if count > 0 {
+			return []os.FileInfo{}, io.EOF
+		}
+		return []os.FileInfo{}, nil
+	}
+	fis = fis[f.offset:]
+	if count > 0 && count < len(fis) {
+		fis = fis[:count]
+	}
+	f.offset += len(fis)
+	return fis, nil
+}
+
+//上传文件句柄，内容先写入临时文件，关闭时上传到网盘并刷新缓存
+type davUploadFile struct {
+	*os.File
+	account  entity.Account
+	path     string
+	parentId string
+}
+
+func (f *davUploadFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return davFileInfo{account: f.account, name: path.Base(f.path), size: fi.Size(), modTime: fi.ModTime()}, nil
+}
+
+func (f *davUploadFile) Close() error {
+	defer os.Remove(f.File.Name())
+	defer f.File.Close()
+	fi, err := f.File.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = f.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	d := drive.Get(f.account.Mode)
+	if err = d.Upload(f.account, f.parentId, path.Base(f.path), fi.Size(), f.File); err != nil {
+		return err
+	}
+	Async(f.account.Id, PetParentPath(f.path))
+	return nil
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"PanIndex/config"
+	"PanIndex/drive"
+	"PanIndex/entity"
+	"context"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//本地网盘挂载到/dav/local，secret目录的访问密码为123
+func davTestAccount(t *testing.T) entity.Account {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "dir", "secret"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(root, "dir", "a.txt"), []byte("hello"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "dir", "secret", "s.txt"), []byte("secret"), 0644)
+	account := entity.Account{Id: "dav-local", Name: "local", Mode: "native", RootId: root}
+	old := config.GloablConfig
+	t.Cleanup(func() { config.GloablConfig = old })
+	config.GloablConfig.Accounts = []entity.Account{account, {Id: "dav-other", Name: "other", Mode: "native", RootId: t.TempDir()}}
+	config.GloablConfig.PwdDirId = filepath.Join(root, "dir", "secret") + ":123"
+	config.GloablConfig.HideFileId = ""
+	return account
+}
+
+func TestDavFileSystem(t *testing.T) {
+	davTestAccount(t)
+	h := &webdav.Handler{Prefix: "/dav", FileSystem: DavFileSystem{}, LockSystem: webdav.NewMemLS()}
+	tests := []struct {
+		name, method, path string
+		code               int
+		contains           []string
+	}{
+		{"根目录列出所有网盘", "PROPFIND", "/dav/", http.StatusMultiStatus, []string{"/dav/local/", "/dav/other/"}},
+		{"网盘目录", "PROPFIND", "/dav/local/dir", http.StatusMultiStatus, []string{"/dav/local/dir/a.txt", "/dav/local/dir/secret/"}},
+		{"本地文件直接输出", "GET", "/dav/local/dir/a.txt", http.StatusOK, []string{"hello"}},
+		{"不存在的网盘", "PROPFIND", "/dav/missing", http.StatusNotFound, nil},
+		{"不存在的文件", "GET", "/dav/local/dir/b.txt", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("Depth", "1")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.code)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(w.Body.String(), s) {
+					t.Errorf("%s %s 的结果中没有%s：%s", tt.method, tt.path, s, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestDavFileSystemAccess(t *testing.T) {
+	davTestAccount(t)
+	ctx := context.Background()
+	//加密目录在上级目录中可见，密码正确才能进入
+	if fi, err := (DavFileSystem{}).Stat(ctx, "/local/dir/secret"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat(secret) = %v, %v", fi, err)
+	}
+	if _, err := (DavFileSystem{}).Access(ctx, "/local/dir/secret"); !os.IsPermission(err) {
+		t.Errorf("Access(secret) without password = %v", err)
+	}
+	if _, err := (DavFileSystem{Pwd: "123"}).Access(ctx, "/local/dir/secret"); err != nil {
+		t.Errorf("Access(secret) with password = %v", err)
+	}
+	//只读时拒绝一切写操作
+	fs := DavFileSystem{}
+	if err := fs.Mkdir(ctx, "/local/dir/new", os.ModePerm); !os.IsPermission(err) {
+		t.Errorf("Mkdir = %v", err)
+	}
+	if err := fs.RemoveAll(ctx, "/local/dir/a.txt"); !os.IsPermission(err) {
+		t.Errorf("RemoveAll = %v", err)
+	}
+	if err := fs.Rename(ctx, "/local/dir/a.txt", "/local/dir/b.txt"); !os.IsPermission(err) {
+		t.Errorf("Rename = %v", err)
+	}
+	if _, err := fs.OpenFile(ctx, "/local/dir/new.txt", os.O_WRONLY|os.O_CREATE, 0644); !os.IsPermission(err) {
+		t.Errorf("OpenFile(create) = %v", err)
+	}
+}
+
+//实时读取目录的网盘，记录每次读取的路径
+type listDrive struct {
+	tree   map[string][]entity.FileNode
+	listed []string
+}
+
+func (d *listDrive) Login(account entity.Account) (string, error) { return "", nil }
+
+func (d *listDrive) List(account entity.Account, fileId, p string) ([]entity.FileNode, error) {
+	d.listed = append(d.listed, p)
+	children, ok := d.tree[p]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	list := []entity.FileNode{}
+	for _, fn := range children {
+		fn.AccountId = account.Id
+		fn.ParentPath = p
+		fn.Path = path.Join(p, fn.FileName)
+		list = append(list, fn)
+	}
+	return list, nil
+}
+
+func (d *listDrive) Walk(account entity.Account, fileId, p string) error { return nil }
+
+func (d *listDrive) DownloadURL(account entity.Account, fileNode entity.FileNode) (string, error) {
+	return "", nil
+}
+
+func (d *listDrive) Upload(account entity.Account, parentId, name string, size int64, r io.Reader) error {
+	return drive.ErrNotSupported
+}
+
+func (d *listDrive) Mkdir(account entity.Account, parentId, name string) error {
+	return drive.ErrNotSupported
+}
+
+func (d *listDrive) Delete(account entity.Account, fileNode entity.FileNode) error {
+	return drive.ErrNotSupported
+}
+
+func (d *listDrive) Capabilities() drive.Capabilities { return drive.Capabilities{} }
+
+func TestDavPropfindListsOnce(t *testing.T) {
+	d := &listDrive{tree: map[string][]entity.FileNode{
+		"/":        {{FileName: "dir", IsFolder: true}},
+		"/dir":     {{FileName: "a.txt"}, {FileName: "b.txt"}, {FileName: "sub", IsFolder: true}},
+		"/dir/sub": {},
+	}}
+	drive.Register("dav-list-test", d)
+	old := config.GloablConfig
+	defer func() { config.GloablConfig = old }()
+	config.GloablConfig.Acls = nil
+	config.GloablConfig.PwdDirId = ""
+	config.GloablConfig.Accounts = []entity.Account{{Id: "dav-list", Name: "dav", Mode: "dav-list-test", RootId: "/"}}
+	fs := NewDavFileSystem(entity.User{}, "", false)
+	if _, err := fs.Access(nil, "/dav/dir"); err != nil {
+		t.Fatal(err)
+	}
+	h := &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()}
+	r := httptest.NewRequest("PROPFIND", "/dav/dir", nil)
+	r.Header.Set("Depth", "1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND = %d %s", w.Code, w.Body.String())
+	}
+	//上级目录和目录本身各读取一次，子文件的信息来自已读取的列表
+	if want := []string{"/", "/dir"}; !reflect.DeepEqual(d.listed, want) {
+		t.Errorf("读取的目录为%v, want %v", d.listed, want)
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"PanIndex/Util"
+	"PanIndex/config"
+	"PanIndex/drive"
+	"PanIndex/entity"
+	"PanIndex/model"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrZipPwd   = errors.New("目录需要访问密码")
+	ErrZipLimit = errors.New("超出打包下载限制")
+)
+
+//打包下载的文件列表，超出打包下载限制时返回ErrZipLimit
+func ZipEntries(user entity.User, account entity.Account, p, pwd string) ([]entity.FileNode, error) {
+	entries, err := FolderEntries(user, account, p, pwd)
+	if err != nil {
+		return nil, err
+	}
+	var count int
+	var size int64
+	for _, fn := range entries {
+		if !fn.IsFolder {
+			count++
+			size += fn.FileSize
+		}
+	}
+	maxFiles, maxSize := config.GloablConfig.ZipMaxFiles, config.GloablConfig.ZipMaxSize*1024*1024
+	if maxFiles > 0 && count > maxFiles {
+		return nil, fmt.Errorf("%w：文件数%d，最多%d个", ErrZipLimit, count, maxFiles)
+	}
+	if maxSize > 0 && size > maxSize {
+		return nil, fmt.Errorf("%w：文件大小%s，最大%s", ErrZipLimit, Util.FormatFileSize(size), Util.FormatFileSize(maxSize))
+	}
+	return entries, nil
+}
+
+//目录下的所有文件（夹），父目录总在子文件之前
+//缓存模式从缓存的目录树中查询，实时模式逐层读取；密码不正确的加密目录及无权浏览的文件不会包含在内
+func FolderEntries(user entity.User, account entity.Account, p, pwd string) ([]entity.FileNode, error) {
+	if _, _, pwdFileId := ListFiles(account, p, pwd); pwdFileId != "" {
+		return nil, ErrZipPwd
+	}
+	all := []entity.FileNode{}
+	if drive.CapabilitiesOf(account).Cached {
+		prefix := "/"
+		if p != "/" {
+			prefix = p + "/"
+		}
+		model.SqliteDb.Raw("select * from file_node where account_id=? and `delete`=0 and hide=0 and substr(path, 1, ?)=? order by path",
+			account.Id, len([]rune(prefix)), prefix).Find(&all)
+	} else if err := listTree(account, p, &all); err != nil {
+		return nil, err
+	}
+	locked := lockedDirs(pwd)
+	entries := []entity.FileNode{}
+	skipped := []string{}
+	for _, fn := range all {
+		skip := false
+		for _, s := range skipped {
+			if pathHasPrefix(fn.Path, s) {
+				skip = true
+				break
+			}
+		}
+		if skip {
+			continue
+		}
+		if (fn.IsFolder && locked[fn.FileId]) || !HasPerm(user, account.Id, fn.Path, PermRead) {
+			skipped = append(skipped, fn.Path)
+			continue
+		}
+		entries = append(entries, fn)
+	}
+	return entries, nil
+}
+
+//实时模式递归读取目录
+func listTree(account entity.Account, p string, list *[]entity.FileNode) error {
+	fs, err := drive.Get(account.Mode).List(account, filepath.Join(account.RootId, p), p)
+	if err != nil {
+		return err
+	}
+	for _, fn := range fs {
+		*list = append(*list, fn)
+		if fn.IsFolder {
+			if err = listTree(account, fn.Path, list); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//边读取边压缩写入w，不产生临时文件；文件只存储不压缩，网盘文件通过下载地址读取
+func WriteZip(ctx context.Context, w io.Writer, account entity.Account, p string, entries []entity.FileNode) error {
+	zw := zip.NewWriter(w)
+	base := ZipName(account, p)
+	for _, fn := range entries {
+		fh := &zip.FileHeader{
+			Name:   base + "/" + strings.TrimPrefix(strings.TrimPrefix(fn.Path, p), "/"),
+			Method: zip.Store,
+		}
+		if modTime, err := time.ParseInLocation("2006-01-02 15:04:05", fn.LastOpTime, time.Local); err == nil {
+			fh.Modified = modTime
+		}
+		if fn.IsFolder {
+			fh.Name += "/"
+			if _, err := zw.CreateHeader(fh); err != nil {
+				return err
+			}
+			continue
+		}
+		fw, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		rc, err := OpenFileContent(ctx, account, fn)
+		if err != nil {
+			return fmt.Errorf("%s：%w", fn.Path, err)
+		}
+		_, err = io.Copy(fw, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s：%w", fn.Path, err)
+		}
+	}
+	return zw.Close()
+}
+
+//压缩包名称（不含后缀），根目录使用账号名称
+func ZipName(account entity.Account, p string) string {
+	if p == "/" {
+		return account.Name
+	}
+	return path.Base(p)
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"PanIndex/config"
+	"PanIndex/entity"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestZipEntries(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "sub"), os.ModePerm)
+	os.MkdirAll(filepath.Join(root, "locked"), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("abc"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "locked", "c.txt"), []byte("secret"), 0644)
+	//超过1MB的文件，用于测试大小限制
+	f, _ := os.Create(filepath.Join(root, "sub", "big.bin"))
+	f.Truncate(1<<20 + 1)
+	f.Close()
+	old := config.GloablConfig
+	defer func() { config.GloablConfig = old }()
+	config.GloablConfig.Acls = nil
+	config.GloablConfig.HideFileId = ""
+	config.GloablConfig.PwdDirId = filepath.Join(root, "locked") + ":pwd"
+	account := entity.Account{Id: "zip-test", Name: "zip", Mode: "native", RootId: root}
+	tests := []struct {
+		name     string
+		path     string
+		pwd      string
+		maxFiles int
+		maxSize  int64
+		want     []string
+		wantErr  error
+	}{
+		{"不限制，跳过密码不正确的加密目录", "/", "", 0, 0, []string{"/a.txt", "/sub", "/sub/b.txt", "/sub/big.bin"}, nil},
+		{"密码正确时包含加密目录", "/", "pwd", 0, 0, []string{"/a.txt", "/locked", "/locked/c.txt", "/sub", "/sub/big.bin", "/sub/b.txt"}, nil},
+		{"刚好达到文件数限制", "/", "", 3, 0, []string{"/a.txt", "/sub", "/sub/b.txt", "/sub/big.bin"}, nil},
+		{"超过文件数限制", "/", "", 2, 0, nil, ErrZipLimit},
+		{"超过大小限制", "/", "", 0, 1, nil, ErrZipLimit},
+		{"子目录未超过大小限制", "/locked", "pwd", 0, 1, []string{"/locked/c.txt"}, nil},
+		{"加密目录密码不正确", "/locked", "", 0, 0, nil, ErrZipPwd},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.GloablConfig.ZipMaxFiles = tt.maxFiles
+			config.GloablConfig.ZipMaxSize = tt.maxSize
+			entries, err := ZipEntries(entity.User{}, account, tt.path, tt.pwd)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ZipEntries() error = %v, want %v", err, tt.wantErr)
+			}
+			got := []string{}
+			for _, fn := range entries {
+				got = append(got, fn.Path)
+			}
+			//实时模式按目录的读取顺序返回，只比较包含的文件
+			if err == nil && !sameItems(got, tt.want) {
+				t.Errorf("ZipEntries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	config.GloablConfig.ZipMaxFiles, config.GloablConfig.ZipMaxSize = 0, 0
+	entries, _ := ZipEntries(entity.User{}, account, "/sub", "")
+	buf := &bytes.Buffer{}
+	if err := WriteZip(context.Background(), buf, account, "/sub", entries); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, f := range zr.File {
+		got[f.Name] = int64(f.UncompressedSize64)
+	}
+	if want := map[string]int64{"sub/b.txt": 3, "sub/big.bin": 1<<20 + 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("WriteZip()写入%v, want %v", got, want)
+	}
+}
+
+func sameItems(a, b []string) bool {
+	m := map[string]int{}
+	for _, s := range a {
+		m[s]++
+	}
+	for _, s := range b {
+		m[s]--
+	}
+	for _, n := range m {
+		if n != 0 {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
@@ -22,78 +22,50 @@ clipboard.on('success', function(e) {
 });
 $(document).ready(function() {
     $('.icon-file').on('click', function(ev) {
-        if(ev.target.tagName == "A" && (ev.target.text == "file_download" ||
+        if(ev.target.tagName == "A" && (ev.target.text == "file_download" || ev.target.text == "playlist_add" ||
             ev.target.text == "content_copy") || ev.target.title == "复制链接") return;
         var dURL = $(this).attr("data-url");
-        var title = $(this).attr("data-title");
-        var dmt = $(this).attr("data-media-type");
         var fileType = $(this).attr("data-file-type");
-        if(dmt == 1){
-            $(this).lightGallery({
-                fullScreen: true,
-                dynamic: true,
-                thumbnail:false,
-                animateThumb: false,
-                showThumbByDefault: false,
-                dynamicEl: findDynamicEl(this),
-                share: false,
-                actualSize: false,
-                closable: true
-            });
-            return;
-        }else if(dmt == 2){
-            const ap = new APlayer({
-                container: document.getElementById('aplayer'),
-                fixed: true,
-                lrcType: 3,
-                autoplay: true,
-                audio: [{
-                    name: title,
-                    artist: 'artist',
-                    url: dURL,
-                    cover: dURL.split('.')[0] + '.jpg',
-                    lrc: dURL.split('.')[0] + '.lrc'
-                }]
-            });
-            return;
-        }else if(dmt == 3){
-            $(this).lightGallery({
-                dynamic: true,
-                thumbnail:false,
-                fullScreen: true,
-                dynamicEl: findDynamicEl(this),
-                share: false,
-                actualSize: false,
-                closable: false
-            });
-            return;
-        }
         var fullUrl = window.location.protocol+"//"+window.location.host + dURL;
         if(fileType == "doc" || fileType == "docx" || fileType == "dotx"
             || fileType == "ppt" || fileType == "pptx" || fileType == "xls" || fileType == "xlsx"){
-            window.open("https://view.officeapps.live.com/op/view.aspx?src="+fullUrl);
+            window.open("https://view.officeapps.live.com/op/view.aspx?src="+encodeURIComponent(fullUrl));
         }else{
-            window.location.href = dURL;
+            //不支持预览的文件由服务端直接下载
+            window.location.href = dURL + (dURL.indexOf("?") >= 0 ? "&" : "?") + "preview";
         }
     });
+    $('.sort-link').on('click', function() {
+        sortBy($(this).attr("data-sort"), $(this).attr("data-order"));
+    });
+    $('.sort-select').on('change', function() {
+        var so = $(this).val().split("-");
+        sortBy(so[0], so[1]);
+    });
     $('.folderDown').on('click', function() {
         var fileId = $(this).attr("data-file-id");
         var accountId = $(this).attr("data-account");
-        var url =  "/api/public/downloadMultiFiles?fileId="+fileId+"&accountId="+accountId;
-        if (fileId.startsWith("/")){
-            window.location.href = url;
-        }else{
+        var sign = $(this).attr("data-sign") || "";
+        window.location.href = "/api/public/downloadMultiFiles?fileId="+encodeURIComponent(fileId)+"&accountId="+accountId+sign.replace("?", "&");
+    });
+    $('.folderExport').on('click', function() {
+        var query = "fileId="+encodeURIComponent($(this).attr("data-file-id"))+"&accountId="+$(this).attr("data-account")+($(this).attr("data-sign") || "").replace("?", "&");
+        //管理员配置了aria2 RPC时可以直接提交，否则下载aria2任务文件（aria2c -i）
+        if($(this).attr("data-rpc") == "true" && confirm("提交到aria2下载？取消则下载aria2任务文件")){
             $.ajax({
-                type: 'POST',
-                url: url,
-                async:false,
-                success: function(data){
-                    window.location.href = data.redirect_url;
+                url: "/api/public/exportLinks?format=rpc&"+query,
+                dataType: "json",
+                complete: function (xhr) {
+                    var d = xhr.responseJSON || {};
+                    alert(d.msg || "提交失败");
                 }
             });
+        }else{
+            window.location.href = "/api/public/exportLinks?"+query;
         }
     });
     $('.table-head').on('click', function() {
+        if($(this).hasClass("sort-link")) return;
         var orderColumn = $(this).text();
         var orderSeq = $(this).attr("data-order-seq");
         var orderType = $(this).attr("data-order-type");
@@ -119,73 +91,6 @@ function sortTable(sort_order, data_type){
         return (sort_order === "down") ? 0-rt : rt;
     });
 }
-function findDynamicEl(obj) {
-    var dynamicEls = [];
-    var dataMediaType = $(obj).attr("data-media-type");
-    var oDURL = $(obj).attr("data-url");
-    var oTitle = $(obj).attr("data-title");
-    if(dataMediaType == 1){
-        dynamicEls.push({
-            src: oDURL,
-            thumb: oDURL,
-            subHtml: '<h4>'+oTitle+'</h4>',
-            downloadUrl:  oDURL
-        });
-    }else if(dataMediaType == 3){
-        dynamicEls.push({
-            html: '<video class="lg-video-object lg-html5" controls preload="none"><source src="'+oDURL+'">Your browser does not support HTML5 video</video>',
-            subHtml: '<h4>'+oTitle+'</h4>',
-            downloadUrl:  oDURL
-        });
-    }
-   var ofs = $(obj).parent().parent().find(".icon-file");
-    if($(obj).parent().get(0).tagName == "TD"){
-        ofs = $(obj).parent().parent().parent().find(".icon-file");
-    }
-    ofs.each(function(i, d){
-        var dURL = $(d).attr("data-url");
-        var title = $(d).attr("data-title");
-        var dmt = $(d).attr("data-media-type");
-        if(dmt == dataMediaType && oTitle != title){
-            if(dataMediaType == 1){
-               dynamicEls.push({
-                   src: dURL,
-                   thumb: dURL,
-                   subHtml: '<h4>'+title+'</h4>',
-                   downloadUrl:  dURL
-               });
-            }/*else if(dataMediaType == 3){
-                dynamicEls.push({
-                    html: '<video class="lg-video-object lg-html5" controls preload="none"><source src="'+dURL+'">Your browser does not support HTML5 video</video>',
-                    subHtml: '<h4>'+title+'</h4>',
-                    downloadUrl:  dURL
-                });
-            }*/
-        }
-    });
-   /* $(obj).parent().parent().parent().find(".icon-file").each(function(i, d){
-        var dURL = $(d).attr("data-url");
-        var title = $(d).attr("data-title");
-        var dmt = $(d).attr("data-media-type");
-        if(dmt == dataMediaType && oTitle != title){
-            if(dataMediaType == 1){
-                dynamicEls.push({
-                    src: dURL,
-                    thumb: dURL,
-                    subHtml: '<h4>'+title+'</h4>',
-                    downloadUrl:  dURL
-                });
-            }/!*else if(dataMediaType == 3){
-                dynamicEls.push({
-                    html: '<video class="lg-video-object lg-html5" controls preload="none"><source src="'+dURL+'">Your browser does not support HTML5 video</video>',
-                    subHtml: '<h4>'+title+'</h4>',
-                    downloadUrl:  dURL
-                });
-            }*!/
-        }
-    });*/
-    return dynamicEls;
-}
 $.fn.extend({
     sortElements: function (comparator, getSortable) {
         getSortable = getSortable || function () { return this; };
@@ -257,3 +162,11 @@ $(".search").bind('keydown', function(event) {
         }
     });
 });*/
+//切换排序，回到第一页
+function sortBy(sort, order){
+    var params = new URLSearchParams(window.location.search);
+    params.set("sort", sort);
+    params.set("order", order);
+    params.delete("page");
+    window.location.search = params.toString();
+}
@@ -0,0 +1,34 @@
+//渲染README及markdown预览，内容可能由上传者提供，不输出原始html，只允许http(s)、相对地址及mailto链接
+function renderMarkdown(src) {
+    //entity为true时保留已转义的实体（marked已转义的标题等），地址中的&一律转义，避免javascript&#58;之类的写法
+    var escapeHtml = function(s, entity) {
+        return String(s).replace(entity ? /&(?!#?\w+;)/g : /&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;")
+            .replace(/"/g, "&quot;").replace(/'/g, "&#39;");
+    };
+    var safeUrl = function(href) {
+        var u = String(href || "").replace(/[\u0000- ]/g, "");
+        if (/^[a-z][a-z0-9+.\-]*:/i.test(u) && !/^(https?|mailto):/i.test(u)) {
+            return "";
+        }
+        return href;
+    };
+    var renderer = new marked.Renderer();
+    renderer.html = function(html) {
+        return escapeHtml(html, true);
+    };
+    renderer.link = function(href, title, text) {
+        href = safeUrl(href);
+        if (href === "") {
+            return text;
+        }
+        return '<a href="' + escapeHtml(href) + '"' + (title ? ' title="' + escapeHtml(title, true) + '"' : '') + '>' + text + '</a>';
+    };
+    renderer.image = function(href, title, text) {
+        href = safeUrl(href);
+        if (href === "") {
+            return text;
+        }
+        return '<img src="' + escapeHtml(href) + '" alt="' + escapeHtml(text, true) + '"' + (title ? ' title="' + escapeHtml(title, true) + '"' : '') + '>';
+    };
+    return marked.parse(src, {renderer: renderer});
+}